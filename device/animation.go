@@ -0,0 +1,266 @@
+package device
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits of animations loaded from files, checked before decoding them so a
+// small GIF cannot take all the memory of the board.
+const (
+	maxAnimationFrames = 256
+	maxAnimationPixels = 8 << 20 // Frames times pixels per frame.
+)
+
+// checkFrames returns an error if frames of w x h pixels are beyond the
+// animation limits.
+func checkFrames(file string, frames, w, h int) error {
+	if frames > maxAnimationFrames {
+		return fmt.Errorf("%v has %v frames, the limit is %v", file, frames, maxAnimationFrames)
+	}
+	if int64(frames)*int64(w)*int64(h) > maxAnimationPixels {
+		return fmt.Errorf("%v has %v frames of %vx%v, the limit is %v pixels in all", file, frames, w, h, maxAnimationPixels)
+	}
+	return nil
+}
+
+// gifFrames returns the number of frames of the GIF in r by walking its
+// blocks without decoding the image data.
+func gifFrames(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+
+	// Header and logical screen descriptor.
+	hdr := make([]byte, 13)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return 0, err
+	}
+	if string(hdr[:3]) != "GIF" {
+		return 0, errors.New("not a GIF")
+	}
+	if hdr[10]&0x80 != 0 {
+		if _, err := br.Discard(3 << (hdr[10]&0x07 + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF && frames > 0 {
+			return frames, nil // Missing trailer.
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case 0x21: // Extension.
+			if _, err := br.ReadByte(); err != nil {
+				return 0, err
+			}
+		case 0x2c: // Image descriptor.
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(br, desc); err != nil {
+				return 0, err
+			}
+			if desc[8]&0x80 != 0 {
+				if _, err := br.Discard(3 << (desc[8]&0x07 + 1)); err != nil {
+					return 0, err
+				}
+			}
+			// LZW minimum code size.
+			if _, err := br.ReadByte(); err != nil {
+				return 0, err
+			}
+			frames++
+		case 0x3b: // Trailer.
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("invalid GIF block %#x", b)
+		}
+
+		// Data sub-blocks up to an empty one.
+		for {
+			n, err := br.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if n == 0 {
+				break
+			}
+			if _, err := br.Discard(int(n)); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// LoadGIF loads an animated GIF with its frame delays. Frames are composed
+// on the GIF canvas so partial frames and disposal work as in a browser.
+func LoadGIF(file string) (*Animation, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := gif.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", file, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	frames, err := gifFrames(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", file, err)
+	}
+	if err := checkFrames(file, frames, cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", file, err)
+	}
+	if len(g.Image) == 0 {
+		return nil, fmt.Errorf("%v has no frames", file)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	imgs := make([]image.Image, len(g.Image))
+	delays := make([]uint, len(g.Image))
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var prev *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			prev = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		imgs[i] = cloneRGBA(canvas)
+		// GIF delays are in 100ths of a second.
+		delays[i] = uint(g.Delay[i]) * 10
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = prev
+		}
+	}
+	return NewAnimation(imgs, delays)
+}
+
+// LoadSpriteSheet splits the image in file into w x h frames, left to right
+// and top to bottom, shown delay ms each.
+func LoadSpriteSheet(file string, w, h int, delay uint) (*Animation, error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("sprite size needs to be positive, got %vx%v", w, h)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", file, err)
+	}
+	if cfg.Width%w != 0 || cfg.Height%h != 0 {
+		return nil, fmt.Errorf("%v size %vx%v is not a multiple of %vx%v", file, cfg.Width, cfg.Height, w, h)
+	}
+	if err := checkFrames(file, (cfg.Width/w)*(cfg.Height/h), w, h); err != nil {
+		return nil, err
+	}
+
+	sheet, err := loadImage(file)
+	if err != nil {
+		return nil, err
+	}
+	b := sheet.Bounds()
+
+	var (
+		imgs   []image.Image
+		delays []uint
+	)
+	for y := b.Min.Y; y < b.Max.Y; y += h {
+		for x := b.Min.X; x < b.Max.X; x += w {
+			frame := image.NewRGBA(image.Rect(0, 0, w, h))
+			draw.Draw(frame, frame.Bounds(), sheet, image.Pt(x, y), draw.Src)
+			imgs = append(imgs, frame)
+			delays = append(delays, delay)
+		}
+	}
+	return NewAnimation(imgs, delays)
+}
+
+// LoadAnimation loads a GIF with its own timing, a sprite sheet if w and h
+// are set or one frame per image file, all shown delay ms each.
+func LoadAnimation(delay uint, w, h int, files ...string) (*Animation, error) {
+	switch {
+	case len(files) == 0:
+		return nil, errors.New("animation has no files")
+	case len(files) == 1 && strings.EqualFold(filepath.Ext(files[0]), ".gif") && w == 0:
+		return LoadGIF(files[0])
+	case w != 0 || h != 0:
+		if len(files) != 1 {
+			return nil, errors.New("sprite sheet needs one file")
+		}
+		return LoadSpriteSheet(files[0], w, h, delay)
+	}
+
+	imgs, err := LoadImages(files...)
+	if err != nil {
+		return nil, err
+	}
+	delays := make([]uint, len(imgs))
+	for i := range delays {
+		delays[i] = delay
+	}
+	return NewAnimation(imgs, delays)
+}
+
+// PreviewGIF writes a as an animated GIF of how it looks on a w x h display,
+// scaled up by scale.
+func (a *Animation) PreviewGIF(out io.Writer, w, h, scale int) error {
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{}
+	for i, img := range a.images {
+		data := convertImage(img, w, h, a.opts)
+		frame := image.NewPaletted(image.Rect(0, 0, w*scale, h*scale), pal)
+		for y := 0; y < h*scale; y++ {
+			for x := 0; x < w*scale; x++ {
+				if data[y/scale][x/scale] {
+					frame.Pix[y*frame.Stride+x] = 1
+				}
+			}
+		}
+		g.Image = append(g.Image, frame)
+		// GIF delays are in 100ths of a second.
+		g.Delay = append(g.Delay, int(a.delay(i)/(10*time.Millisecond)))
+	}
+	return gif.EncodeAll(out, g)
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	return dst
+}
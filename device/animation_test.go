@@ -0,0 +1,176 @@
+package device
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPalette = color.Palette{color.Transparent, color.Black, color.White}
+
+// saveGIF writes g to a file in a temp dir and returns its name.
+func saveGIF(t *testing.T, g *gif.GIF) string {
+	file := filepath.Join(t.TempDir(), "test.gif")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, g); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// savePNG writes img to a file in a temp dir and returns its name.
+func savePNG(t *testing.T, img image.Image) string {
+	file := filepath.Join(t.TempDir(), "test.png")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// filled returns a w x h paletted image at x, y of palette index c.
+func filled(x, y, w, h int, c uint8) *image.Paletted {
+	img := image.NewPaletted(image.Rect(x, y, x+w, y+h), testPalette)
+	for i := range img.Pix {
+		img.Pix[i] = c
+	}
+	return img
+}
+
+func isWhite(img image.Image, x, y int) bool {
+	r, g, b, a := img.At(x, y).RGBA()
+	return r == 0xffff && g == 0xffff && b == 0xffff && a == 0xffff
+}
+
+func TestLoadGIF(t *testing.T) {
+	// A white 4x4 canvas with a black corner drawn, cleared and a last
+	// frame drawn on a restored canvas.
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			filled(0, 0, 4, 4, 2),
+			filled(0, 0, 2, 2, 1),
+			filled(2, 2, 2, 2, 1),
+			filled(0, 2, 2, 2, 1),
+		},
+		Delay:    []int{10, 20, 5, 0},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		Config:   image.Config{ColorModel: testPalette, Width: 4, Height: 4},
+	}
+	a, err := LoadAnimation(500, 0, 0, saveGIF(t, g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Frames() != 4 {
+		t.Fatalf("Frames() = %v, want 4", a.Frames())
+	}
+	// No delay shows the frame for the default time.
+	for i, want := range []uint{100, 200, 50, defaultFrameDelay} {
+		if got := a.delay(i); got != time.Duration(want)*time.Millisecond {
+			t.Errorf("delay(%v) = %v, want %vms", i, got, want)
+		}
+	}
+
+	tests := []struct {
+		frame int
+		x, y  int
+		white bool
+	}{
+		{0, 0, 0, true},
+		{1, 0, 0, false}, // Drawn over the first frame.
+		{1, 3, 3, true},
+		{2, 0, 0, false}, // Cleared after this frame.
+		{2, 3, 3, false},
+		{3, 0, 0, false},
+		{3, 0, 3, false},
+		{3, 3, 3, true}, // The third frame was undone.
+	}
+	for _, tc := range tests {
+		img := a.images[tc.frame]
+		if got := isWhite(img, tc.x, tc.y); got != tc.white {
+			t.Errorf("frame %v at %v,%v: white %v, want %v", tc.frame, tc.x, tc.y, got, tc.white)
+		}
+	}
+	if r, g, b, al := a.images[3].At(1, 1).RGBA(); r|g|b|al != 0 {
+		t.Errorf("frame 3 at 1,1 = %v, want cleared", a.images[3].At(1, 1))
+	}
+}
+
+func TestLoadSpriteSheet(t *testing.T) {
+	// Two rows of three black 2x2 sprites, the first three with a white dot.
+	sheet := filled(0, 0, 6, 4, 1)
+	sheet.SetColorIndex(0, 0, 2)
+	sheet.SetColorIndex(3, 1, 2)
+	sheet.SetColorIndex(4, 0, 2)
+	file := savePNG(t, sheet)
+
+	a, err := LoadAnimation(40, 2, 2, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Frames() != 6 {
+		t.Fatalf("Frames() = %v, want 6", a.Frames())
+	}
+	for i, pt := range []image.Point{{0, 0}, {1, 1}, {0, 0}} {
+		img := a.images[i]
+		if !isWhite(img, pt.X, pt.Y) {
+			t.Errorf("frame %v at %v not white", i, pt)
+		}
+		if b := img.Bounds(); b != image.Rect(0, 0, 2, 2) {
+			t.Errorf("frame %v bounds = %v, want 2x2", i, b)
+		}
+	}
+	if isWhite(a.images[5], 0, 0) {
+		t.Error("frame 5 at 0,0 is white")
+	}
+	if got := a.delay(5); got != 40*time.Millisecond {
+		t.Errorf("delay(5) = %v, want 40ms", got)
+	}
+
+	for _, size := range []image.Point{{4, 4}, {0, 2}, {-2, 2}} {
+		if _, err := LoadSpriteSheet(file, size.X, size.Y, 40); err == nil {
+			t.Errorf("LoadSpriteSheet(%v) succeeded", size)
+		}
+	}
+}
+
+func TestLoadAnimation(t *testing.T) {
+	png1 := savePNG(t, filled(0, 0, 2, 2, 1))
+	png2 := savePNG(t, filled(0, 0, 2, 2, 2))
+
+	a, err := LoadAnimation(30, 0, 0, png1, png2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Frames() != 2 || a.delay(1) != 30*time.Millisecond {
+		t.Errorf("Frames() = %v delay(1) = %v, want 2 and 30ms", a.Frames(), a.delay(1))
+	}
+
+	tests := []struct {
+		name  string
+		w, h  int
+		files []string
+	}{
+		{"no files", 0, 0, nil},
+		{"sprite sheet of two files", 2, 2, []string{png1, png2}},
+		{"missing file", 0, 0, []string{filepath.Join(t.TempDir(), "missing.png")}},
+		{"missing GIF", 0, 0, []string{filepath.Join(t.TempDir(), "missing.gif")}},
+	}
+	for _, tc := range tests {
+		if _, err := LoadAnimation(30, tc.w, tc.h, tc.files...); err == nil {
+			t.Errorf("%v: LoadAnimation succeeded", tc.name)
+		}
+	}
+}
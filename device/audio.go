@@ -6,18 +6,28 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/gordonklaus/portaudio"
 )
 
+// Default jitter buffer of playback.
+const (
+	defaultJitterLatency = 200 * time.Millisecond
+	defaultJitterConceal = CONCEAL_REPEAT
+)
+
+// maxPlaybackBackoff is the longest wait after failed audio out writes.
+const maxPlaybackBackoff = time.Second
+
 type Audio struct {
 	In             chan bytes.Buffer
-	Out            chan bytes.Buffer
 	playSampleRate float64
 	recSampleRate  float64
 	recBuf         []int16
 	playBuf        []int16
+	jitter         *JitterBuffer // Smooths chunks from Play into playBuf.
 	recStop        chan struct{}
 	playStop       chan struct{}
 	playStatus     bool // True is currently in playback loop.
@@ -27,7 +37,6 @@ type Audio struct {
 func NewAudio() *Audio {
 	return &Audio{
 		In:         make(chan bytes.Buffer),
-		Out:        make(chan bytes.Buffer),
 		recStop:    make(chan struct{}),
 		playStop:   make(chan struct{}),
 		playStatus: false,
@@ -38,6 +47,14 @@ func NewAudio() *Audio {
 // Init initializes the audio.
 // oBufLen is 8 bits while bufOut is 16 bits.
 func (s *Audio) Init(recBufLen, playBufLen int, recSampleRate, playSampleRate float64) error {
+	if recBufLen <= 0 || playBufLen <= 0 {
+		return fmt.Errorf("audio buffers need at least one sample, got %v and %v", recBufLen, playBufLen)
+	}
+	for _, r := range []float64{recSampleRate, playSampleRate} {
+		if r < MinSampleRate || r > MaxSampleRate {
+			return fmt.Errorf("sample rate needs to be %v to %vHz, got %v", MinSampleRate, MaxSampleRate, r)
+		}
+	}
 	if err := portaudio.Initialize(); err != nil {
 		return fmt.Errorf("init failed:%v", err)
 	}
@@ -51,9 +68,38 @@ func (s *Audio) Init(recBufLen, playBufLen int, recSampleRate, playSampleRate fl
 	buf = make([]int16, recBufLen)
 	s.recBuf = buf
 
+	return s.SetJitterBuffer(defaultJitterLatency, defaultJitterConceal)
+}
+
+// SetJitterBuffer sets the playback latency, the audio buffered before
+// playing, and the concealment of missing audio (see ConcealModes). Audio
+// beyond twice the latency is dropped. Needs to be called after Init and
+// before playback starts.
+func (s *Audio) SetJitterBuffer(latency time.Duration, conceal string) error {
+	if latency < 0 {
+		return fmt.Errorf("audio latency needs to be positive, got %v", latency)
+	}
+	target := int(latency.Seconds() * s.playSampleRate)
+	max := 2 * target
+	if max < target+len(s.playBuf) {
+		max = target + len(s.playBuf)
+	}
+	j, err := NewJitterBuffer(target, max, conceal)
+	if err != nil {
+		return err
+	}
+	s.jitter = j
 	return nil
 }
 
+// PlaybackStats returns the counters of the playback jitter buffer.
+func (s *Audio) PlaybackStats() JitterStats {
+	if s.jitter == nil {
+		return JitterStats{}
+	}
+	return s.jitter.Stats()
+}
+
 func (s *Audio) Close() {
 	if err := portaudio.Terminate(); err != nil {
 		glog.Errorf("Failed to terminate portaudio: %v", err)
@@ -65,6 +111,30 @@ func (s *Audio) IsRec() bool {
 	return s.recStatus
 }
 
+// Rates returns the recording and playback sample rates in Hz.
+func (s *Audio) Rates() (rec, play int) {
+	return int(s.recSampleRate), int(s.playSampleRate)
+}
+
+// Resamplers returns resamplers for a client at rate Hz. rec converts the
+// recorded audio to rate and play converts audio from the client to the
+// playback rate.
+func (s *Audio) Resamplers(rate int) (rec, play *Resampler, err error) {
+	recRate, playRate := s.Rates()
+	if rec, err = NewResampler(recRate, rate); err != nil {
+		return nil, nil, err
+	}
+	if play, err = NewResampler(rate, playRate); err != nil {
+		return nil, nil, err
+	}
+	return rec, play, nil
+}
+
+// IsPlaying returns true if currently playing audio from the browser.
+func (s *Audio) IsPlaying() bool {
+	return s.playStatus
+}
+
 func (s *Audio) StartPlayback() {
 	if s.playStatus {
 		return
@@ -148,8 +218,11 @@ func (s *Audio) playback() {
 	}
 
 	glog.Info("Started playback audio from browser")
+	s.jitter.Reset()
 	s.playStatus = true
 
+	frame := time.Duration(float64(len(s.playBuf)) / s.playSampleRate * float64(time.Second))
+	var backoff time.Duration
 	for {
 		select {
 		case <-s.playStop:
@@ -164,16 +237,40 @@ func (s *Audio) playback() {
 			s.playStatus = false
 			return
 
-		case out := <-s.Out:
-			glog.V(2).Infof("Playback audio chunk size: %v", out.Len())
-			if err := binary.Read(&out, binary.LittleEndian, s.playBuf); err != nil {
-				glog.Warningf("Failed to convert to binary %v", err)
-				continue
-			}
-			if err := stream.Write(); err != nil {
-				glog.Errorf("Failed to write to audio out: %v", err)
+		default:
+		}
+
+		// Write blocks for about a frame, keeping the speaker fed while
+		// Play fills the jitter buffer.
+		s.jitter.Pop(s.playBuf)
+		err := stream.Write()
+		if err == nil || err == portaudio.OutputUnderflowed {
+			if err != nil {
+				glog.V(2).Info("Audio out underflowed")
 			}
+			backoff = 0
+			continue
+		}
+
+		// Do not spin on a broken stream, wait twice as long after each
+		// failure.
+		if backoff *= 2; backoff == 0 {
+			backoff = frame
+		}
+		if backoff > maxPlaybackBackoff {
+			backoff = maxPlaybackBackoff
 		}
+		glog.Errorf("Failed to write to audio out, retrying in %v: %v", backoff, err)
+		time.Sleep(backoff)
 	}
+}
 
+// Play queues pcm at the playback rate for playback. It is dropped unless
+// playback is running.
+func (s *Audio) Play(pcm []int16) {
+	if !s.playStatus {
+		return
+	}
+	glog.V(2).Infof("Playback audio chunk size: %v", len(pcm))
+	s.jitter.Push(pcm)
 }
@@ -0,0 +1,191 @@
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Audio codecs of the audio websocket.
+const (
+	CODEC_PCM  = "pcm"  // Little endian int16.
+	CODEC_PCMU = "pcmu" // G.711 µ-law, 8 bits per sample.
+	CODEC_PCMA = "pcma" // G.711 A-law, 8 bits per sample.
+	CODEC_OPUS = "opus" // Opus in 20ms packets. Needs building with -tags opus.
+)
+
+// AudioCodec encodes and decodes mono int16 PCM.
+type AudioCodec interface {
+	Name() string
+	// Encode returns the packets for pcm. Codecs with fixed frame sizes keep
+	// left over samples for the next call.
+	Encode(pcm []int16) ([][]byte, error)
+	Decode(packet []byte) ([]int16, error)
+}
+
+// NewAudioCodec returns codec name for audio sampled at rate Hz.
+func NewAudioCodec(name string, rate int) (AudioCodec, error) {
+	switch name {
+	case CODEC_PCM:
+		return pcmCodec{}, nil
+	case CODEC_PCMU:
+		return g711Codec{CODEC_PCMU, linearToULaw, uLawToLinear}, nil
+	case CODEC_PCMA:
+		return g711Codec{CODEC_PCMA, linearToALaw, aLawToLinear}, nil
+	case CODEC_OPUS:
+		return newOpusCodec(rate)
+	}
+	return nil, fmt.Errorf("unknown audio codec %q", name)
+}
+
+// PCMBytes returns pcm as little endian bytes.
+func PCMBytes(pcm []int16) []byte {
+	b := make([]byte, len(pcm)*2)
+	for i, v := range pcm {
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(v))
+	}
+	return b
+}
+
+// PCMSamples returns the samples of little endian bytes b. An odd last
+// byte is dropped.
+func PCMSamples(b []byte) []int16 {
+	pcm := make([]int16, len(b)/2)
+	for i := range pcm {
+		pcm[i] = int16(binary.LittleEndian.Uint16(b[i*2:]))
+	}
+	return pcm
+}
+
+type pcmCodec struct{}
+
+func (pcmCodec) Name() string {
+	return CODEC_PCM
+}
+
+func (pcmCodec) Encode(pcm []int16) ([][]byte, error) {
+	return [][]byte{PCMBytes(pcm)}, nil
+}
+
+func (pcmCodec) Decode(packet []byte) ([]int16, error) {
+	if len(packet)%2 != 0 {
+		return nil, fmt.Errorf("pcm packet has odd length %v", len(packet))
+	}
+	return PCMSamples(packet), nil
+}
+
+// g711Codec is G.711 with one byte per sample.
+type g711Codec struct {
+	name   string
+	encode func(int16) byte
+	decode func(byte) int16
+}
+
+func (c g711Codec) Name() string {
+	return c.name
+}
+
+func (c g711Codec) Encode(pcm []int16) ([][]byte, error) {
+	b := make([]byte, len(pcm))
+	for i, v := range pcm {
+		b[i] = c.encode(v)
+	}
+	return [][]byte{b}, nil
+}
+
+func (c g711Codec) Decode(packet []byte) ([]int16, error) {
+	pcm := make([]int16, len(packet))
+	for i, v := range packet {
+		pcm[i] = c.decode(v)
+	}
+	return pcm, nil
+}
+
+// Segment ends of the G.711 companding curves, from the reference
+// implementation.
+var (
+	uLawSegEnd = [8]int{0x3f, 0x7f, 0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff, 0x1fff}
+	aLawSegEnd = [8]int{0x1f, 0x3f, 0x7f, 0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff}
+)
+
+const (
+	uLawBias = 0x84
+	uLawClip = 8159
+)
+
+// segment returns the segment of v in ends, 8 if it is beyond them.
+func segment(v int, ends [8]int) int {
+	for i, end := range ends {
+		if v <= end {
+			return i
+		}
+	}
+	return 8
+}
+
+func linearToULaw(s int16) byte {
+	v := int(s) >> 2
+	mask := 0xff
+	if v < 0 {
+		v = -v
+		mask = 0x7f
+	}
+	if v > uLawClip {
+		v = uLawClip
+	}
+	v += uLawBias >> 2
+
+	seg := segment(v, uLawSegEnd)
+	if seg >= 8 {
+		return byte(0x7f ^ mask)
+	}
+	return byte((seg<<4 | (v>>uint(seg+1))&0x0f) ^ mask)
+}
+
+func uLawToLinear(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0f) << 3) + uLawBias
+	t <<= uint(u&0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(uLawBias - t)
+	}
+	return int16(t - uLawBias)
+}
+
+func linearToALaw(s int16) byte {
+	v := int(s) >> 3
+	mask := 0xd5
+	if v < 0 {
+		mask = 0x55
+		v = -v - 1
+	}
+
+	seg := segment(v, aLawSegEnd)
+	if seg >= 8 {
+		return byte(0x7f ^ mask)
+	}
+	a := seg << 4
+	if seg < 2 {
+		a |= (v >> 1) & 0x0f
+	} else {
+		a |= (v >> uint(seg)) & 0x0f
+	}
+	return byte(a ^ mask)
+}
+
+func aLawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0f) << 4
+	switch seg := uint(a&0x70) >> 4; seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
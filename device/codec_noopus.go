@@ -0,0 +1,10 @@
+//go:build !opus
+// +build !opus
+
+package device
+
+import "errors"
+
+func newOpusCodec(rate int) (AudioCodec, error) {
+	return nil, errors.New("opus support needs building with -tags opus")
+}
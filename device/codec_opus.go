@@ -0,0 +1,68 @@
+//go:build opus
+// +build opus
+
+package device
+
+import (
+	"fmt"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+// opusFrame is the duration of an Opus packet in ms.
+const opusFrame = 20
+
+// opusCodec is Opus through libopus. Encode packs the samples into 20ms
+// packets.
+type opusCodec struct {
+	enc     *opus.Encoder
+	dec     *opus.Decoder
+	frame   int     // Samples per packet.
+	pending []int16 // Samples not yet encoded.
+	pcm     []int16 // Decode buffer for the longest packet.
+}
+
+func newOpusCodec(rate int) (AudioCodec, error) {
+	enc, err := opus.NewEncoder(rate, 1, opus.AppVoIP)
+	if err != nil {
+		return nil, fmt.Errorf("opus does not support %vHz: %v", rate, err)
+	}
+	dec, err := opus.NewDecoder(rate, 1)
+	if err != nil {
+		return nil, fmt.Errorf("opus does not support %vHz: %v", rate, err)
+	}
+	return &opusCodec{
+		enc:   enc,
+		dec:   dec,
+		frame: rate * opusFrame / 1000,
+		pcm:   make([]int16, rate*120/1000),
+	}, nil
+}
+
+func (c *opusCodec) Name() string {
+	return CODEC_OPUS
+}
+
+func (c *opusCodec) Encode(pcm []int16) ([][]byte, error) {
+	c.pending = append(c.pending, pcm...)
+
+	var packets [][]byte
+	for len(c.pending) >= c.frame {
+		buf := make([]byte, 1000)
+		n, err := c.enc.Encode(c.pending[:c.frame], buf)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, buf[:n])
+		c.pending = append(c.pending[:0], c.pending[c.frame:]...)
+	}
+	return packets, nil
+}
+
+func (c *opusCodec) Decode(packet []byte) ([]int16, error) {
+	n, err := c.dec.Decode(packet, c.pcm)
+	if err != nil {
+		return nil, err
+	}
+	return append([]int16(nil), c.pcm[:n]...), nil
+}
@@ -0,0 +1,163 @@
+package device
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestG711Encode(t *testing.T) {
+	tests := []struct {
+		pcm        int16
+		ulaw, alaw byte
+	}{
+		{0, 0xff, 0xd5},
+		{-1, 0x7e, 0x55},
+		{8, 0xfe, 0xd5},
+		{-8, 0x7e, 0x55},
+		{1000, 0xce, 0xfa},
+		{-1000, 0x4e, 0x7a},
+		{32767, 0x80, 0xaa},
+		{-32768, 0x00, 0x2a},
+	}
+	for _, tc := range tests {
+		if got := linearToULaw(tc.pcm); got != tc.ulaw {
+			t.Errorf("linearToULaw(%v) = %#x, want %#x", tc.pcm, got, tc.ulaw)
+		}
+		if got := linearToALaw(tc.pcm); got != tc.alaw {
+			t.Errorf("linearToALaw(%v) = %#x, want %#x", tc.pcm, got, tc.alaw)
+		}
+	}
+}
+
+func TestG711Decode(t *testing.T) {
+	tests := []struct {
+		code       byte
+		ulaw, alaw int16
+	}{
+		{0xff, 0, 848},
+		{0x7f, 0, -848},
+		{0x80, 32124, 5504},
+		{0x00, -32124, -5504},
+		{0xd5, 716, 8},
+		{0x55, -716, -8},
+		{0xaa, 5372, 32256},
+		{0x2a, -5372, -32256},
+	}
+	for _, tc := range tests {
+		if got := uLawToLinear(tc.code); got != tc.ulaw {
+			t.Errorf("uLawToLinear(%#x) = %v, want %v", tc.code, got, tc.ulaw)
+		}
+		if got := aLawToLinear(tc.code); got != tc.alaw {
+			t.Errorf("aLawToLinear(%#x) = %v, want %v", tc.code, got, tc.alaw)
+		}
+	}
+}
+
+func TestG711RoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(int16) byte
+		decode func(byte) int16
+		// Quantization step at full scale.
+		maxErr int
+	}{
+		{CODEC_PCMU, linearToULaw, uLawToLinear, 1024},
+		{CODEC_PCMA, linearToALaw, aLawToLinear, 1024},
+	}
+	for _, tc := range tests {
+		// Every code decodes to a value that encodes back to it, except
+		// µ-law negative zero.
+		for c := 0; c < 256; c++ {
+			if tc.name == CODEC_PCMU && c == 0x7f {
+				continue
+			}
+			if got := tc.encode(tc.decode(byte(c))); got != byte(c) {
+				t.Errorf("%v: code %#x decodes to %v which encodes to %#x", tc.name, c, tc.decode(byte(c)), got)
+			}
+		}
+		// The error grows with the magnitude like the companding curve.
+		for v := -32768; v <= 32767; v++ {
+			got := int(tc.decode(tc.encode(int16(v))))
+			mag := v
+			if mag < 0 {
+				mag = -mag
+			}
+			lim := mag/16 + 64
+			if lim > tc.maxErr {
+				lim = tc.maxErr
+			}
+			if d := got - v; d > lim || d < -lim {
+				t.Errorf("%v: %v decodes to %v, error above %v", tc.name, v, got, lim)
+				break
+			}
+		}
+	}
+}
+
+func TestAudioCodec(t *testing.T) {
+	pcm := []int16{0, 1, -1, 1000, -1000, 32767, -32768}
+	tests := []struct {
+		name    string
+		wantLen int // Encoded bytes.
+		lossy   bool
+	}{
+		{CODEC_PCM, 14, false},
+		{CODEC_PCMU, 7, true},
+		{CODEC_PCMA, 7, true},
+	}
+	for _, tc := range tests {
+		c, err := NewAudioCodec(tc.name, 8000)
+		if err != nil {
+			t.Fatalf("NewAudioCodec(%q): %v", tc.name, err)
+		}
+		if c.Name() != tc.name {
+			t.Errorf("Name() = %q, want %q", c.Name(), tc.name)
+		}
+		packets, err := c.Encode(pcm)
+		if err != nil || len(packets) != 1 || len(packets[0]) != tc.wantLen {
+			t.Fatalf("%v: Encode = %v packets, %v, want one of %v bytes", tc.name, len(packets), err, tc.wantLen)
+		}
+		got, err := c.Decode(packets[0])
+		if err != nil {
+			t.Fatalf("%v: Decode: %v", tc.name, err)
+		}
+		if !tc.lossy && !reflect.DeepEqual(got, pcm) {
+			t.Errorf("%v: Decode = %v, want %v", tc.name, got, pcm)
+		}
+		if len(got) != len(pcm) {
+			t.Errorf("%v: Decode returned %v samples, want %v", tc.name, len(got), len(pcm))
+		}
+	}
+
+	if _, err := NewAudioCodec("mp3", 8000); err == nil {
+		t.Error("NewAudioCodec of an unknown codec succeeded")
+	}
+	c, _ := NewAudioCodec(CODEC_PCM, 8000)
+	if _, err := c.Decode([]byte{1, 2, 3}); err == nil {
+		t.Error("pcm Decode of an odd length packet succeeded")
+	}
+}
+
+func TestPCMBytes(t *testing.T) {
+	tests := []struct {
+		pcm []int16
+		b   []byte
+	}{
+		{[]int16{}, []byte{}},
+		{[]int16{1}, []byte{1, 0}},
+		{[]int16{-2, 0x1234}, []byte{0xfe, 0xff, 0x34, 0x12}},
+	}
+	for _, tc := range tests {
+		if got := PCMBytes(tc.pcm); !bytes.Equal(got, tc.b) {
+			t.Errorf("PCMBytes(%v) = %v, want %v", tc.pcm, got, tc.b)
+		}
+		if got := PCMSamples(tc.b); !reflect.DeepEqual(got, tc.pcm) {
+			t.Errorf("PCMSamples(%v) = %v, want %v", tc.b, got, tc.pcm)
+		}
+	}
+	// An odd last byte is dropped.
+	if got := PCMSamples([]byte{1, 0, 7}); !reflect.DeepEqual(got, []int16{1}) {
+		t.Errorf("PCMSamples of odd length = %v, want [1]", got)
+	}
+}
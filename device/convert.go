@@ -0,0 +1,191 @@
+package device
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Image scaling modes.
+const (
+	SCALE_NONE = iota // Pixels are copied 1:1 and cropped by the display.
+	SCALE_FIT         // Shrink or grow to show the whole image.
+	SCALE_FILL        // Shrink or grow to cover the display, cropping the overflow.
+)
+
+// Pixel conversion modes.
+const (
+	CONVERT_ALPHA           = iota // Opaque pixels are on. For monochrome images with transparency.
+	CONVERT_THRESHOLD              // Pixels brighter than the threshold are on.
+	CONVERT_FLOYD_STEINBERG        // Error diffusion dithering. Best for photos.
+	CONVERT_ORDERED                // 4x4 Bayer dithering. Stable across video frames.
+)
+
+// ScaleModes maps names to SCALE_* modes.
+var ScaleModes = map[string]int{
+	"none": SCALE_NONE,
+	"fit":  SCALE_FIT,
+	"fill": SCALE_FILL,
+}
+
+// ConvertModes maps names to CONVERT_* modes.
+var ConvertModes = map[string]int{
+	"alpha":           CONVERT_ALPHA,
+	"threshold":       CONVERT_THRESHOLD,
+	"floyd-steinberg": CONVERT_FLOYD_STEINBERG,
+	"ordered":         CONVERT_ORDERED,
+}
+
+// ImageOptions controls how images are converted to display frames. The
+// zero value copies opaque pixels 1:1 from the top left.
+type ImageOptions struct {
+	Scale     int   // SCALE_* mode.
+	Center    bool  // Center the image on the display.
+	Convert   int   // CONVERT_* mode.
+	Threshold uint8 // Luminance (0-255) for CONVERT_THRESHOLD. 0 is 128.
+	Invert    bool  // Turn on the dark pixels instead.
+}
+
+// ParseImageOptions returns the options for named scale and convert modes.
+// Empty names keep the defaults and scaled images are centered.
+func ParseImageOptions(scale, convert string) (ImageOptions, error) {
+	var o ImageOptions
+	if scale != "" {
+		mode, ok := ScaleModes[scale]
+		if !ok {
+			return o, fmt.Errorf("unknown scale %q", scale)
+		}
+		o.Scale = mode
+		o.Center = mode != SCALE_NONE
+	}
+	if convert != "" {
+		mode, ok := ConvertModes[convert]
+		if !ok {
+			return o, fmt.Errorf("unknown convert %q", convert)
+		}
+		o.Convert = mode
+	}
+	return o, nil
+}
+
+// bayer4 is the 4x4 ordered dithering matrix.
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// convertImage converts img to a w x h monochrome frame.
+func convertImage(img image.Image, w, h int, o ImageOptions) [][]bool {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	// Size of the image on the display.
+	dw, dh := sw, sh
+	if sw > 0 && sh > 0 && o.Scale != SCALE_NONE {
+		sx, sy := float64(w)/float64(sw), float64(h)/float64(sh)
+		s := math.Min(sx, sy)
+		if o.Scale == SCALE_FILL {
+			s = math.Max(sx, sy)
+		}
+		dw = int(math.Round(float64(sw) * s))
+		dh = int(math.Round(float64(sh) * s))
+	}
+	var ox, oy int
+	if o.Center {
+		ox, oy = (w-dw)/2, (h-dh)/2
+	}
+
+	// Average luminance (0-255 over black) and alpha (0-1) of the source
+	// pixels under each display pixel.
+	lum := make([][]float64, h)
+	alpha := make([][]float64, h)
+	inside := func(x, y int) bool {
+		return x >= ox && x < ox+dw && y >= oy && y < oy+dh
+	}
+	for y := 0; y < h; y++ {
+		lum[y] = make([]float64, w)
+		alpha[y] = make([]float64, w)
+		for x := 0; x < w; x++ {
+			if !inside(x, y) {
+				continue
+			}
+			x0 := b.Min.X + (x-ox)*sw/dw
+			x1 := b.Min.X + (x-ox+1)*sw/dw
+			y0 := b.Min.Y + (y-oy)*sh/dh
+			y1 := b.Min.Y + (y-oy+1)*sh/dh
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			if y1 <= y0 {
+				y1 = y0 + 1
+			}
+			var l, a float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, bl, al := img.At(sx, sy).RGBA()
+					l += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+					a += float64(al) / 0xffff
+				}
+			}
+			n := float64((x1 - x0) * (y1 - y0))
+			lum[y][x], alpha[y][x] = l/n, a/n
+			if o.Invert {
+				lum[y][x] = 255 - lum[y][x]
+			}
+		}
+	}
+
+	threshold := float64(o.Threshold)
+	if threshold == 0 {
+		threshold = 128
+	}
+
+	data := make([][]bool, h)
+	for y := range data {
+		data[y] = make([]bool, w)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !inside(x, y) {
+				continue
+			}
+			switch o.Convert {
+			case CONVERT_THRESHOLD:
+				data[y][x] = lum[y][x] >= threshold
+
+			case CONVERT_ORDERED:
+				data[y][x] = lum[y][x] > (bayer4[y%4][x%4]+0.5)*16
+
+			case CONVERT_FLOYD_STEINBERG:
+				on := lum[y][x] >= 128
+				data[y][x] = on
+				e := lum[y][x]
+				if on {
+					e -= 255
+				}
+				diffuse := func(x, y int, f float64) {
+					if y < h && x >= 0 && x < w && inside(x, y) {
+						lum[y][x] += e * f
+					}
+				}
+				diffuse(x+1, y, 7.0/16)
+				diffuse(x-1, y+1, 3.0/16)
+				diffuse(x, y+1, 5.0/16)
+				diffuse(x+1, y+1, 1.0/16)
+
+			default:
+				if o.Scale == SCALE_NONE {
+					data[y][x] = alpha[y][x] > 0
+				} else {
+					data[y][x] = alpha[y][x] >= 0.5
+				}
+				if o.Invert {
+					data[y][x] = !data[y][x]
+				}
+			}
+		}
+	}
+	return data
+}
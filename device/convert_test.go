@@ -0,0 +1,156 @@
+package device
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testImage returns an image drawn with '#' white, '+' light grey, '-' dark
+// grey, '.' black and ' ' transparent pixels.
+func testImage(rows ...string) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			var v uint8
+			switch c {
+			case ' ':
+				continue
+			case '#':
+				v = 255
+			case '+':
+				v = 200
+			case '-':
+				v = 100
+			case '.':
+				v = 0
+			}
+			img.Set(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+// frameRows returns a monochrome frame as rows of '#' (on) and '.'.
+func frameRows(data [][]bool) []string {
+	out := make([]string, len(data))
+	for y, row := range data {
+		b := []byte(strings.Repeat(".", len(row)))
+		for x, on := range row {
+			if on {
+				b[x] = '#'
+			}
+		}
+		out[y] = string(b)
+	}
+	return out
+}
+
+func TestConvertImage(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+		w, h int
+		o    ImageOptions
+		want []string
+	}{
+		{"alpha", testImage("# .", ".  "), 3, 2, ImageOptions{}, []string{
+			"#.#",
+			"#..",
+		}},
+		{"alpha inverted", testImage("# .", ".  "), 3, 2, ImageOptions{Invert: true}, []string{
+			".#.",
+			".##",
+		}},
+		{"alpha cropped", testImage("####", "####", "####"), 2, 2, ImageOptions{}, []string{
+			"##",
+			"##",
+		}},
+		{"alpha smaller than display", testImage("##"), 3, 2, ImageOptions{}, []string{
+			"##.",
+			"...",
+		}},
+		{"alpha centered", testImage("##"), 4, 3, ImageOptions{Center: true}, []string{
+			"....",
+			".##.",
+			"....",
+		}},
+		{"threshold", testImage("#-+."), 4, 1, ImageOptions{Convert: CONVERT_THRESHOLD}, []string{
+			"#.#.",
+		}},
+		{"threshold set", testImage("#-+."), 4, 1, ImageOptions{Convert: CONVERT_THRESHOLD, Threshold: 90}, []string{
+			"###.",
+		}},
+		{"threshold inverted", testImage("#-+."), 4, 1, ImageOptions{Convert: CONVERT_THRESHOLD, Invert: true}, []string{
+			".#.#",
+		}},
+		{"fit up", testImage("##", "##"), 6, 4, ImageOptions{Scale: SCALE_FIT, Center: true, Convert: CONVERT_THRESHOLD}, []string{
+			".####.",
+			".####.",
+			".####.",
+			".####.",
+		}},
+		{"fit down averages", testImage("##..", "##..", "..##", "..#."), 2, 2, ImageOptions{Scale: SCALE_FIT, Convert: CONVERT_THRESHOLD}, []string{
+			"#.",
+			".#",
+		}},
+		{"fit down alpha", testImage("## .", "##  "), 2, 1, ImageOptions{Scale: SCALE_FIT}, []string{
+			"#.",
+		}},
+		{"fill crops centered", testImage("##..", "##.."), 2, 2, ImageOptions{Scale: SCALE_FILL, Center: true, Convert: CONVERT_THRESHOLD}, []string{
+			"#.",
+			"#.",
+		}},
+		{"ordered", testImage("----", "----", "----", "----"), 4, 4, ImageOptions{Convert: CONVERT_ORDERED}, []string{
+			"#.#.",
+			".#..",
+			"#.#.",
+			"...#",
+		}},
+		{"floyd-steinberg white", testImage("###", "###"), 3, 2, ImageOptions{Convert: CONVERT_FLOYD_STEINBERG}, []string{
+			"###",
+			"###",
+		}},
+		{"floyd-steinberg black", testImage("...", "..."), 3, 2, ImageOptions{Convert: CONVERT_FLOYD_STEINBERG}, []string{
+			"...",
+			"...",
+		}},
+		{"floyd-steinberg grey", testImage("----"), 4, 1, ImageOptions{Convert: CONVERT_FLOYD_STEINBERG}, []string{
+			".#..",
+		}},
+	}
+	for _, tc := range tests {
+		got := frameRows(convertImage(tc.img, tc.w, tc.h, tc.o))
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: convertImage =\n%v\nwant\n%v", tc.name, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestParseImageOptions(t *testing.T) {
+	tests := []struct {
+		scale, convert string
+		want           ImageOptions
+		ok             bool
+	}{
+		{"", "", ImageOptions{}, true},
+		{"none", "", ImageOptions{Scale: SCALE_NONE}, true},
+		{"fit", "ordered", ImageOptions{Scale: SCALE_FIT, Center: true, Convert: CONVERT_ORDERED}, true},
+		{"fill", "floyd-steinberg", ImageOptions{Scale: SCALE_FILL, Center: true, Convert: CONVERT_FLOYD_STEINBERG}, true},
+		{"", "threshold", ImageOptions{Convert: CONVERT_THRESHOLD}, true},
+		{"stretch", "", ImageOptions{}, false},
+		{"", "blur", ImageOptions{}, false},
+	}
+	for _, tc := range tests {
+		got, err := ParseImageOptions(tc.scale, tc.convert)
+		if (err == nil) != tc.ok {
+			t.Errorf("ParseImageOptions(%q, %q) = %v, want ok %v", tc.scale, tc.convert, err, tc.ok)
+			continue
+		}
+		if tc.ok && got != tc.want {
+			t.Errorf("ParseImageOptions(%q, %q) = %+v, want %+v", tc.scale, tc.convert, got, tc.want)
+		}
+	}
+}
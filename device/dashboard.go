@@ -0,0 +1,48 @@
+package device
+
+import (
+	"strings"
+	"time"
+)
+
+// Dashboard modes.
+const (
+	DASHBOARD_OFF    = iota // Only the animation.
+	DASHBOARD_ON            // Only the dashboard.
+	DASHBOARD_ROTATE        // Alternate between the animation and the dashboard.
+)
+
+const (
+	dashboardTick   = time.Second      // Refresh interval of the dashboard.
+	dashboardRotate = 10 * time.Second // Default time between rotations.
+)
+
+// dashboardMode is sent to the OLED loop to change the dashboard.
+type dashboardMode struct {
+	mode   int
+	rotate time.Duration
+}
+
+// SetDashboard sets the source of the dashboard lines. status is called
+// from the OLED loop on every refresh so it needs to be safe to call from
+// another goroutine. Lines that do not fit are clipped.
+func (s *OLED) SetDashboard(status func() []string) {
+	s.dashSrcCh <- status
+}
+
+// SetDashboardMode shows the dashboard (DASHBOARD_ON), hides it
+// (DASHBOARD_OFF) or alternates it with the animation every rotate
+// (DASHBOARD_ROTATE). A zero rotate keeps the last one.
+func (s *OLED) SetDashboardMode(mode int, rotate time.Duration) {
+	s.dashModeCh <- dashboardMode{mode: mode, rotate: rotate}
+}
+
+// drawDashboard displays the dashboard lines.
+func (s *OLED) drawDashboard() {
+	s.oled.Clear()
+	if s.dashboard != nil {
+		t := Text{Text: strings.Join(s.dashboard(), "\n")}
+		t.draw(s.oled, 0)
+	}
+	s.display()
+}
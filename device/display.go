@@ -0,0 +1,174 @@
+package device
+
+import (
+	"image"
+	"image/color"
+)
+
+// Display is a pixel display that the OLED loop draws on. Set turns a pixel
+// on (c != 0) or off.
+type Display interface {
+	Size() (w, h int)
+	Clear()
+	Set(x, y, c int)
+	Display() error
+	Reset() error
+	Off() error
+}
+
+// ColorDisplay is a Display that can also draw colour pixels. Set draws
+// pixels on (c != 0) in the foreground colour and off in the background
+// colour so monochrome frames work unchanged.
+type ColorDisplay interface {
+	Display
+	SetRGB(x, y int, c color.Color)
+}
+
+// MonoBuffer is a 1 bit per pixel framebuffer in the page layout of
+// SSD1306 and SH1106 controllers. Each byte is a column of 8 pixels with
+// bit 0 the top one and a page is Width bytes for 8 rows.
+type MonoBuffer struct {
+	Width  int
+	Height int
+	Pix    []byte
+}
+
+// NewMonoBuffer returns a cleared w x h buffer. h is rounded up to whole
+// pages.
+func NewMonoBuffer(w, h int) *MonoBuffer {
+	return &MonoBuffer{
+		Width:  w,
+		Height: h,
+		Pix:    make([]byte, w*((h+7)/8)),
+	}
+}
+
+func (b *MonoBuffer) Size() (int, int) {
+	return b.Width, b.Height
+}
+
+func (b *MonoBuffer) Clear() {
+	for i := range b.Pix {
+		b.Pix[i] = 0
+	}
+}
+
+// Set turns pixel x, y on if c is non zero or off.
+func (b *MonoBuffer) Set(x, y, c int) {
+	if x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return
+	}
+	i := y/8*b.Width + x
+	if c != 0 {
+		b.Pix[i] |= 1 << uint(y%8)
+		return
+	}
+	b.Pix[i] &^= 1 << uint(y%8)
+}
+
+// At returns true if pixel x, y is on.
+func (b *MonoBuffer) At(x, y int) bool {
+	if x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return false
+	}
+	return b.Pix[y/8*b.Width+x]&(1<<uint(y%8)) != 0
+}
+
+// Pages returns the number of pages.
+func (b *MonoBuffer) Pages() int {
+	return (b.Height + 7) / 8
+}
+
+// Page returns the bytes of page p.
+func (b *MonoBuffer) Page(p int) []byte {
+	return b.Pix[p*b.Width : (p+1)*b.Width]
+}
+
+// RGBBuffer is a 16 bit RGB565 framebuffer with pixels in big endian byte
+// order as ST7735 and ILI9341 controllers take them.
+type RGBBuffer struct {
+	Width  int
+	Height int
+	Pix    []byte
+	Fg     uint16 // RGB565 colour of pixels Set on.
+	Bg     uint16 // RGB565 colour of pixels Set off and of Clear.
+}
+
+// NewRGBBuffer returns a w x h buffer drawing white on black.
+func NewRGBBuffer(w, h int) *RGBBuffer {
+	return &RGBBuffer{
+		Width:  w,
+		Height: h,
+		Pix:    make([]byte, w*h*2),
+		Fg:     0xffff,
+	}
+}
+
+func (b *RGBBuffer) Size() (int, int) {
+	return b.Width, b.Height
+}
+
+// Clear fills the buffer with the background colour.
+func (b *RGBBuffer) Clear() {
+	for i := 0; i < len(b.Pix); i += 2 {
+		b.Pix[i], b.Pix[i+1] = byte(b.Bg>>8), byte(b.Bg)
+	}
+}
+
+// Set draws pixel x, y in the foreground colour if c is non zero or in the
+// background colour.
+func (b *RGBBuffer) Set(x, y, c int) {
+	col := b.Bg
+	if c != 0 {
+		col = b.Fg
+	}
+	b.set565(x, y, col)
+}
+
+// SetRGB draws pixel x, y in colour c.
+func (b *RGBBuffer) SetRGB(x, y int, c color.Color) {
+	b.set565(x, y, RGB565(c))
+}
+
+func (b *RGBBuffer) set565(x, y int, c uint16) {
+	if x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return
+	}
+	i := (y*b.Width + x) * 2
+	b.Pix[i], b.Pix[i+1] = byte(c>>8), byte(c)
+}
+
+// At returns the colour of pixel x, y.
+func (b *RGBBuffer) At(x, y int) color.Color {
+	if x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return color.Black
+	}
+	i := (y*b.Width + x) * 2
+	c := uint16(b.Pix[i])<<8 | uint16(b.Pix[i+1])
+	r, g, bl := byte(c>>11), byte(c>>5&0x3f), byte(c&0x1f)
+	return color.RGBA{r<<3 | r>>2, g<<2 | g>>4, bl<<3 | bl>>2, 0xff}
+}
+
+// Image returns a copy of the buffer.
+func (b *RGBBuffer) Image() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, b.Width, b.Height))
+	for y := 0; y < b.Height; y++ {
+		for x := 0; x < b.Width; x++ {
+			img.Set(x, y, b.At(x, y))
+		}
+	}
+	return img
+}
+
+// RGB565 converts c to 16 bit 5-6-5 RGB.
+func RGB565(c color.Color) uint16 {
+	r, g, b, _ := c.RGBA()
+	return uint16(r>>11)<<11 | uint16(g>>10)<<5 | uint16(b>>11)
+}
+
+var (
+	_ Display      = (*SH1106)(nil)
+	_ ColorDisplay = (*TFT)(nil)
+	_ ColorDisplay = colorMirror{}
+	_ SPIBus       = (*SPIDev)(nil)
+)
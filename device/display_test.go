@@ -0,0 +1,135 @@
+package device
+
+import (
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// fakeSPI records the transfers on an SPI bus with the level of the D/C
+// pin at the time.
+type fakeSPI struct {
+	dc   *fakePin
+	cmds []byte // Command bytes.
+	data []byte // Data bytes.
+	tx   int    // Transfers of data.
+}
+
+func (s *fakeSPI) Tx(w, r []byte) error {
+	if s.dc.level == 0 {
+		s.cmds = append(s.cmds, w...)
+		return nil
+	}
+	s.data = append(s.data, w...)
+	s.tx++
+	return nil
+}
+
+type fakePin struct {
+	level byte
+}
+
+func (p *fakePin) DigitalWrite(level byte) error {
+	p.level = level
+	return nil
+}
+
+func TestMonoBuffer(t *testing.T) {
+	b := NewMonoBuffer(4, 10)
+	if b.Pages() != 2 || len(b.Pix) != 8 {
+		t.Fatalf("Pages() = %v len(Pix) = %v, want 2 and 8", b.Pages(), len(b.Pix))
+	}
+	b.Set(0, 0, 1)
+	b.Set(1, 7, 1)
+	b.Set(2, 9, 1)
+	b.Set(3, 3, 1)
+	b.Set(3, 3, 0)
+	b.Set(4, 0, 1)  // Off the buffer.
+	b.Set(0, 10, 1) // Off the buffer.
+
+	if got, want := b.Page(0), []byte{0x01, 0x80, 0x00, 0x00}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Page(0) = %#v, want %#v", got, want)
+	}
+	if got, want := b.Page(1), []byte{0x00, 0x00, 0x02, 0x00}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Page(1) = %#v, want %#v", got, want)
+	}
+	if !b.At(2, 9) || b.At(3, 3) || b.At(-1, 0) {
+		t.Errorf("At(2, 9) %v At(3, 3) %v At(-1, 0) %v, want true false false", b.At(2, 9), b.At(3, 3), b.At(-1, 0))
+	}
+	b.Clear()
+	if b.At(0, 0) {
+		t.Error("pixel on after Clear")
+	}
+}
+
+func TestRGB565(t *testing.T) {
+	tests := []struct {
+		c    color.Color
+		want uint16
+	}{
+		{color.Black, 0x0000},
+		{color.White, 0xffff},
+		{color.RGBA{0xff, 0, 0, 0xff}, 0xf800},
+		{color.RGBA{0, 0xff, 0, 0xff}, 0x07e0},
+		{color.RGBA{0, 0, 0xff, 0xff}, 0x001f},
+	}
+	for _, tc := range tests {
+		if got := RGB565(tc.c); got != tc.want {
+			t.Errorf("RGB565(%v) = %#04x, want %#04x", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestRGBBuffer(t *testing.T) {
+	b := NewRGBBuffer(2, 2)
+	b.Bg = RGB565(color.RGBA{0, 0, 0xff, 0xff})
+	b.Clear()
+	b.Set(0, 0, 1)
+	b.SetRGB(1, 1, color.RGBA{0xff, 0, 0, 0xff})
+	b.Set(2, 0, 1) // Off the buffer.
+
+	// Big endian pixels.
+	want := []byte{0xff, 0xff, 0x00, 0x1f, 0x00, 0x1f, 0xf8, 0x00}
+	if !reflect.DeepEqual(b.Pix, want) {
+		t.Errorf("Pix = %#v, want %#v", b.Pix, want)
+	}
+	if got, want := b.At(1, 1), (color.RGBA{0xff, 0, 0, 0xff}); got != want {
+		t.Errorf("At(1, 1) = %v, want %v", got, want)
+	}
+	if got, want := b.Image().At(0, 1), (color.RGBA{0, 0, 0xff, 0xff}); got != want {
+		t.Errorf("Image().At(0, 1) = %v, want %v", got, want)
+	}
+}
+
+func TestTFTDisplay(t *testing.T) {
+	dc := &fakePin{}
+	spi := &fakeSPI{dc: dc}
+	d := &TFT{spi: spi, dc: dc, model: tftModels[TFT_ILI9341], buf: NewRGBBuffer(300, 20)}
+	d.Set(0, 0, 1)
+	if err := d.Display(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []byte{tftCASet, tftRASet, tftRAMWr}; !reflect.DeepEqual(spi.cmds, want) {
+		t.Errorf("commands = %#v, want %#v", spi.cmds, want)
+	}
+	// Window of 300x20 then the pixels in transfers up to tftChunk.
+	if want := []byte{0, 0, 0x01, 0x2b, 0, 0, 0, 19}; !reflect.DeepEqual(spi.data[:8], want) {
+		t.Errorf("window = %#v, want %#v", spi.data[:8], want)
+	}
+	if pix := spi.data[8:]; !reflect.DeepEqual(pix, d.buf.Pix) {
+		t.Errorf("sent %v pixel bytes, want the %v of the buffer", len(pix), len(d.buf.Pix))
+	}
+	if want := 2 + (300*20*2+tftChunk-1)/tftChunk; spi.tx != want {
+		t.Errorf("%v data transfers, want %v", spi.tx, want)
+	}
+
+	for _, size := range [][2]int{{321, 240}, {320, -1}} {
+		if _, err := NewTFT(TFT_ILI9341, spi, dc, nil, size[0], size[1]); err == nil {
+			t.Errorf("NewTFT of %vx%v succeeded", size[0], size[1])
+		}
+	}
+	if _, err := NewTFT(5, spi, dc, nil, 0, 0); err == nil {
+		t.Error("NewTFT of an unknown model succeeded")
+	}
+}
@@ -0,0 +1,128 @@
+package device
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+const gpioPath = "/sys/class/gpio"
+
+// GPIOEncoder counts edges of a wheel encoder on a GPIO pin. Edges are
+// delivered as interrupts through the sysfs GPIO interface.
+type GPIOEncoder struct {
+	count uint64 // Accessed atomically. First for 64 bit alignment on ARM.
+	pin   int
+	file  *os.File
+	epfd  int
+	quit  chan struct{}
+}
+
+// NewGPIOEncoder starts counting edges on BCM pin. edge is one of "rising",
+// "falling" or "both".
+func NewGPIOEncoder(pin int, edge string) (*GPIOEncoder, error) {
+	dir := fmt.Sprintf("%v/gpio%v", gpioPath, pin)
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := writeFile(gpioPath+"/export", fmt.Sprint(pin)); err != nil {
+			return nil, fmt.Errorf("failed to export gpio%v: %v", pin, err)
+		}
+	}
+	if err := writeFile(dir+"/direction", "in"); err != nil {
+		return nil, fmt.Errorf("failed to set direction on gpio%v: %v", pin, err)
+	}
+	if err := writeFile(dir+"/edge", edge); err != nil {
+		return nil, fmt.Errorf("failed to set edge on gpio%v: %v", pin, err)
+	}
+
+	f, err := os.Open(dir + "/value")
+	if err != nil {
+		return nil, err
+	}
+
+	epfd, err := syscall.EpollCreate1(0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ev := syscall.EpollEvent{
+		Events: syscall.EPOLLPRI | syscall.EPOLLERR,
+		Fd:     int32(f.Fd()),
+	}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, int(f.Fd()), &ev); err != nil {
+		syscall.Close(epfd)
+		f.Close()
+		return nil, err
+	}
+
+	e := &GPIOEncoder{
+		pin:  pin,
+		file: f,
+		epfd: epfd,
+		quit: make(chan struct{}),
+	}
+
+	// The first wait returns right away for the current value.
+	e.ack()
+	go e.run()
+
+	glog.Infof("Started wheel encoder on gpio%v edge %v", pin, edge)
+	return e, nil
+}
+
+// Count returns the number of edges seen.
+func (e *GPIOEncoder) Count() uint64 {
+	return atomic.LoadUint64(&e.count)
+}
+
+// Close stops counting and releases the pin.
+func (e *GPIOEncoder) Close() error {
+	close(e.quit)
+	return writeFile(gpioPath+"/unexport", fmt.Sprint(e.pin))
+}
+
+func (e *GPIOEncoder) run() {
+	events := make([]syscall.EpollEvent, 1)
+	defer func() {
+		syscall.Close(e.epfd)
+		e.file.Close()
+	}()
+
+	for {
+		select {
+		case <-e.quit:
+			return
+		default:
+		}
+
+		// Time out periodically to check for quit.
+		n, err := syscall.EpollWait(e.epfd, events, 100)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			glog.Errorf("Encoder gpio%v wait failed: %v", e.pin, err)
+			return
+		}
+		if n > 0 {
+			atomic.AddUint64(&e.count, 1)
+			e.ack()
+		}
+	}
+}
+
+// ack reads the value so the next edge raises a new interrupt.
+func (e *GPIOEncoder) ack() {
+	buf := make([]byte, 2)
+	if _, err := e.file.ReadAt(buf, 0); err != nil {
+		glog.V(2).Infof("Encoder gpio%v read failed: %v", e.pin, err)
+	}
+}
+
+func writeFile(path, data string) error {
+	return ioutil.WriteFile(path, []byte(data), 0644)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package device
+
+import "errors"
+
+// GPIOEncoder counts edges of a wheel encoder on a GPIO pin. It needs the
+// Linux sysfs GPIO interface.
+type GPIOEncoder struct{}
+
+func NewGPIOEncoder(pin int, edge string) (*GPIOEncoder, error) {
+	return nil, errors.New("gpio encoders are only supported on linux")
+}
+
+func (e *GPIOEncoder) Count() uint64 {
+	return 0
+}
+
+func (e *GPIOEncoder) Close() error {
+	return nil
+}
@@ -0,0 +1,287 @@
+package device
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Expressions of the OLED face.
+const (
+	EXPR_IDLE        = "idle"
+	EXPR_HAPPY       = "happy"
+	EXPR_DRIVING     = "driving"
+	EXPR_BLOCKED     = "blocked"
+	EXPR_LOW_BATTERY = "low_battery"
+	EXPR_LISTENING   = "listening"
+	EXPR_SLEEPING    = "sleeping"
+)
+
+// Expressions lists the face expressions, most important first. The face
+// shows the first active one, or idle if none is.
+var Expressions = []string{
+	EXPR_BLOCKED,
+	EXPR_LISTENING,
+	EXPR_LOW_BATTERY,
+	EXPR_HAPPY,
+	EXPR_DRIVING,
+	EXPR_SLEEPING,
+	EXPR_IDLE,
+}
+
+// faceTick is the interval the face checks the rover state.
+const faceTick = 500 * time.Millisecond
+
+// Face shows the expression matching what the rover is doing on the OLED.
+// Blocked, driving, low battery and sleeping follow the rover state, other
+// expressions are turned on by events with SetState and Flash. An expression
+// set with Set overrides them all.
+type Face struct {
+	oled       *OLED
+	dev        *Ubiquity
+	lowBattery float64       // Battery percent below which the face shows low battery.
+	sleepAfter time.Duration // No control messages for this long shows sleeping. 0 never sleeps.
+
+	playMu sync.Mutex // Serializes updates so animations play in order. Taken before mu.
+
+	mu          sync.Mutex
+	anims       map[string]*Animation
+	def         *Animation           // Shown for expressions without an animation, see SetDefault.
+	states      map[string]time.Time // Active expressions and when they end, zero if they don't.
+	manual      string               // Expression set with Set.
+	manualUntil time.Time            // When manual ends, zero if it doesn't.
+	curr        string               // Expression on the OLED.
+	played      *Animation           // Animation of curr.
+	active      time.Time            // Time of the last control message, see Wake.
+}
+
+// NewFace returns a face for oled following the state of dev.
+func NewFace(oled *OLED, dev *Ubiquity, lowBattery float64, sleepAfter time.Duration) *Face {
+	return &Face{
+		oled:       oled,
+		dev:        dev,
+		lowBattery: lowBattery,
+		sleepAfter: sleepAfter,
+		anims:      make(map[string]*Animation),
+		states:     make(map[string]time.Time),
+		active:     time.Now(),
+	}
+}
+
+// SetAnimation sets the animation of expression name, or removes it if a is
+// nil. Expressions without an animation show the idle one or the default.
+func (f *Face) SetAnimation(name string, a *Animation) error {
+	if !validExpression(name) {
+		return fmt.Errorf("unknown expression %q", name)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if a == nil {
+		delete(f.anims, name)
+	} else {
+		f.anims[name] = a
+	}
+	f.curr, f.played = "", nil
+	return nil
+}
+
+// SetDefault sets the animation shown when neither the expression nor idle
+// has one.
+func (f *Face) SetDefault(a *Animation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.def = a
+	f.curr, f.played = "", nil
+}
+
+// Load loads the expressions found in dir. An expression is name.gif,
+// name.png or the frames name_*.png in name order shown delay ms each.
+func (f *Face) Load(dir string, delay uint) error {
+	for _, name := range Expressions {
+		var files []string
+		for _, pattern := range []string{name + ".gif", name + ".png", name + "_*.png"} {
+			m, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return err
+			}
+			if len(m) > 0 {
+				files = m
+				break
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		a, err := LoadAnimation(delay, 0, 0, files...)
+		if err != nil {
+			return fmt.Errorf("failed to load expression %v: %v", name, err)
+		}
+		f.SetAnimation(name, a)
+		glog.V(1).Infof("Loaded expression %v from %v", name, files)
+	}
+	return nil
+}
+
+// Run starts following the rover state.
+func (f *Face) Run() {
+	f.update()
+	go func() {
+		tick := time.NewTicker(faceTick)
+		defer tick.Stop()
+		for range tick.C {
+			f.poll()
+			f.update()
+		}
+	}()
+}
+
+// Set shows expression name for d, or until cleared if d is 0. An empty
+// name goes back to following the rover.
+func (f *Face) Set(name string, d time.Duration) error {
+	if name != "" && !validExpression(name) {
+		return fmt.Errorf("unknown expression %q", name)
+	}
+	f.mu.Lock()
+	f.manual = name
+	f.manualUntil = time.Time{}
+	if d > 0 {
+		f.manualUntil = time.Now().Add(d)
+	}
+	f.mu.Unlock()
+	f.update()
+	return nil
+}
+
+// SetState turns expression name on or off, eg. listening while audio
+// plays.
+func (f *Face) SetState(name string, on bool) {
+	f.mu.Lock()
+	if on {
+		f.states[name] = time.Time{}
+	} else {
+		delete(f.states, name)
+	}
+	f.mu.Unlock()
+	f.update()
+}
+
+// Flash turns expression name on for d, eg. happy when a client connects.
+func (f *Face) Flash(name string, d time.Duration) {
+	f.mu.Lock()
+	f.states[name] = time.Now().Add(d)
+	f.mu.Unlock()
+	f.update()
+}
+
+// Wake records a control message from a user. The face sleeps when there
+// are none for the sleep time. Keep alive messages sent by idle clients
+// should not wake it.
+func (f *Face) Wake() {
+	f.mu.Lock()
+	f.active = time.Now()
+	f.mu.Unlock()
+}
+
+// Expression returns the expression shown.
+func (f *Face) Expression() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.curr
+}
+
+// poll sets the expressions that follow the rover state.
+func (f *Face) poll() {
+	batt, err := f.dev.Battery()
+	low := err == nil && batt < f.lowBattery
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sleeping := f.sleepAfter > 0 && time.Since(f.active) > f.sleepAfter
+	for name, on := range map[string]bool{
+		EXPR_BLOCKED:     f.dev.Locked(),
+		EXPR_DRIVING:     f.dev.Moving(),
+		EXPR_LOW_BATTERY: low,
+		EXPR_SLEEPING:    sleeping,
+	} {
+		if on {
+			f.states[name] = time.Time{}
+		} else {
+			delete(f.states, name)
+		}
+	}
+}
+
+// update shows the current expression if it changed. The OLED is only
+// updated on changes so animations played by others, eg. missions, stay
+// until the next one.
+func (f *Face) update() {
+	f.playMu.Lock()
+	defer f.playMu.Unlock()
+
+	// Play blocks until the OLED takes the animation so it is called
+	// without holding mu.
+	if a := f.pick(); a != nil {
+		f.oled.Play(a)
+	}
+}
+
+// pick returns the animation of the current expression if it needs to be
+// played.
+func (f *Face) pick() *Animation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if f.manual != "" && !f.manualUntil.IsZero() && now.After(f.manualUntil) {
+		f.manual = ""
+	}
+	for name, until := range f.states {
+		if !until.IsZero() && now.After(until) {
+			delete(f.states, name)
+		}
+	}
+
+	expr := f.manual
+	if expr == "" {
+		expr = EXPR_IDLE
+		for _, name := range Expressions {
+			if _, ok := f.states[name]; ok {
+				expr = name
+				break
+			}
+		}
+	}
+	if expr == f.curr {
+		return nil
+	}
+
+	a, ok := f.anims[expr]
+	if !ok {
+		a, ok = f.anims[EXPR_IDLE]
+	}
+	if !ok {
+		a = f.def
+	}
+	if a == nil {
+		return nil
+	}
+	glog.V(1).Infof("Face expression %v", expr)
+	f.curr = expr
+	if a == f.played {
+		return nil
+	}
+	f.played = a
+	return a
+}
+
+func validExpression(name string) bool {
+	for _, e := range Expressions {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package device
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeBattery reports a fixed charge.
+type fakeBattery float64
+
+func (b fakeBattery) Battery() (float64, error) {
+	return float64(b), nil
+}
+
+func TestFacePoll(t *testing.T) {
+	tests := []struct {
+		name    string
+		locked  bool
+		moving  bool
+		battery float64
+		idle    time.Duration // Since the last control message.
+		want    []string
+	}{
+		{"nothing", false, false, 80, 0, nil},
+		{"blocked", true, false, 80, 0, []string{EXPR_BLOCKED}},
+		{"driving", false, true, 80, 0, []string{EXPR_DRIVING}},
+		{"low battery", false, false, 10, 0, []string{EXPR_LOW_BATTERY}},
+		{"sleeping", false, false, 80, 2 * time.Minute, []string{EXPR_SLEEPING}},
+		{"all", true, false, 10, 2 * time.Minute, []string{EXPR_BLOCKED, EXPR_LOW_BATTERY, EXPR_SLEEPING}},
+	}
+	for _, tc := range tests {
+		u, _ := newTestRover()
+		u.SetBattery(fakeBattery(tc.battery))
+		if tc.moving {
+			if err := u.Drive(1, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tc.locked {
+			u.Lock(true)
+		}
+		f := NewFace(nil, u, 20, time.Minute)
+		f.active = time.Now().Add(-tc.idle)
+		f.poll()
+
+		if len(f.states) != len(tc.want) {
+			t.Errorf("%v: states = %v, want %v", tc.name, f.states, tc.want)
+		}
+		for _, name := range tc.want {
+			if _, ok := f.states[name]; !ok {
+				t.Errorf("%v: %v not set in %v", tc.name, name, f.states)
+			}
+		}
+		u.AllMotorStop()
+	}
+}
+
+func TestFaceWake(t *testing.T) {
+	u, _ := newTestRover()
+	f := NewFace(nil, u, 20, time.Minute)
+	f.active = time.Now().Add(-2 * time.Minute)
+
+	// Heartbeats alone don't keep the face awake.
+	u.Heartbeat()
+	f.poll()
+	if _, ok := f.states[EXPR_SLEEPING]; !ok {
+		t.Error("not sleeping after heartbeats only")
+	}
+	f.Wake()
+	f.poll()
+	if _, ok := f.states[EXPR_SLEEPING]; ok {
+		t.Error("sleeping after Wake")
+	}
+
+	// A zero sleep time never sleeps.
+	f = NewFace(nil, u, 20, 0)
+	f.active = time.Now().Add(-time.Hour)
+	f.poll()
+	if _, ok := f.states[EXPR_SLEEPING]; ok {
+		t.Error("sleeping with sleep disabled")
+	}
+}
+
+func TestFacePick(t *testing.T) {
+	idle, driving, blocked := &Animation{}, &Animation{}, &Animation{}
+	past := time.Now().Add(-time.Second)
+	tests := []struct {
+		name     string
+		states   map[string]time.Time
+		manual   string
+		wantExpr string
+		want     *Animation
+	}{
+		{"idle", nil, "", EXPR_IDLE, idle},
+		{"driving", map[string]time.Time{EXPR_DRIVING: {}}, "", EXPR_DRIVING, driving},
+		{"most important first", map[string]time.Time{EXPR_DRIVING: {}, EXPR_BLOCKED: {}}, "", EXPR_BLOCKED, blocked},
+		{"no animation shows idle", map[string]time.Time{EXPR_LISTENING: {}}, "", EXPR_LISTENING, idle},
+		{"flash over", map[string]time.Time{EXPR_DRIVING: past}, "", EXPR_IDLE, idle},
+		{"manual", map[string]time.Time{EXPR_BLOCKED: {}}, EXPR_DRIVING, EXPR_DRIVING, driving},
+	}
+	for _, tc := range tests {
+		f := NewFace(nil, nil, 20, 0)
+		f.anims = map[string]*Animation{EXPR_IDLE: idle, EXPR_DRIVING: driving, EXPR_BLOCKED: blocked}
+		for name, until := range tc.states {
+			f.states[name] = until
+		}
+		f.manual = tc.manual
+
+		if got := f.pick(); got != tc.want {
+			t.Errorf("%v: pick() = %p, want %p", tc.name, got, tc.want)
+		}
+		if got := f.Expression(); got != tc.wantExpr {
+			t.Errorf("%v: Expression() = %v, want %v", tc.name, got, tc.wantExpr)
+		}
+		// Nothing to play until the expression changes.
+		if got := f.pick(); got != nil {
+			t.Errorf("%v: second pick() = %p, want nil", tc.name, got)
+		}
+	}
+
+	f := NewFace(nil, nil, 20, 0)
+	if err := f.Set("grumpy", 0); err == nil {
+		t.Error("Set of an unknown expression succeeded")
+	}
+}
@@ -0,0 +1,122 @@
+package device
+
+import (
+	"fmt"
+
+	"gobot.io/x/gobot/drivers/gpio"
+	"gobot.io/x/gobot/sysfs"
+)
+
+// MotorChannel is a single input of the H-bridge. PwmWrite sets the duty
+// cycle of the input from 0 (off) to 255 (fully on).
+type MotorChannel interface {
+	PwmWrite(level byte) error
+}
+
+// DigitalPin is a pin that can only be switched on (1) or off (0).
+type DigitalPin interface {
+	DigitalWrite(level byte) error
+}
+
+// AngleActuator positions a mechanism to an angle in degrees (eg. servo).
+// SetAngle returns once the position is commanded and Detach stops driving
+// the mechanism so it no longer holds the position.
+type AngleActuator interface {
+	SetAngle(angle int) error
+	Detach() error
+	Lock(lock bool) error
+}
+
+// CalibratedActuator is a servo whose angle to pulse mapping can be tuned.
+// SetDutyCycle drives a raw pulse in microsecs while calibrating.
+type CalibratedActuator interface {
+	AngleActuator
+	SetCalibration(c ServoCalibration) error
+	Calibration() ServoCalibration
+	SetDutyCycle(us uint32) error
+}
+
+// BatterySensor reports the remaining battery charge in percent.
+type BatterySensor interface {
+	Battery() (float64, error)
+}
+
+// SPIBus is a full duplex SPI bus. Tx writes w and reads len(w) bytes into
+// r unless r is nil.
+type SPIBus interface {
+	Tx(w, r []byte) error
+}
+
+// Light is anything that can be switched on or off (eg. headlight).
+type Light interface {
+	On() error
+	Off() error
+}
+
+// onOffChannel drives an H-bridge input without PWM. Any non zero level
+// runs the motor at full speed.
+type onOffChannel struct {
+	pin DigitalPin
+}
+
+// NewOnOffChannel returns a MotorChannel for a pin that has no PWM support.
+func NewOnOffChannel(pin DigitalPin) MotorChannel {
+	return onOffChannel{pin}
+}
+
+func (c onOffChannel) PwmWrite(level byte) error {
+	if level > 0 {
+		return c.pin.DigitalWrite(1)
+	}
+	return c.pin.DigitalWrite(0)
+}
+
+// SysfsPWMChannel drives an H-bridge input from the kernel PWM interface
+// (/sys/class/pwm/pwmchip0).
+type SysfsPWMChannel struct {
+	pin    *sysfs.PWMPin
+	period uint32 // PWM period in ns.
+}
+
+// NewSysfsPWMChannel exports and enables PWM channel ch with a period in ns.
+func NewSysfsPWMChannel(ch int, period uint32) (*SysfsPWMChannel, error) {
+	pin := sysfs.NewPWMPin(ch)
+	if err := pin.Export(); err != nil {
+		return nil, fmt.Errorf("failed to export pwm%v: %v", ch, err)
+	}
+	if err := pin.SetPeriod(period); err != nil {
+		return nil, fmt.Errorf("failed to set period on pwm%v: %v", ch, err)
+	}
+	if err := pin.SetDutyCycle(0); err != nil {
+		return nil, fmt.Errorf("failed to set duty cycle on pwm%v: %v", ch, err)
+	}
+	if err := pin.Enable(true); err != nil {
+		return nil, fmt.Errorf("failed to enable pwm%v: %v", ch, err)
+	}
+	return &SysfsPWMChannel{
+		pin:    pin,
+		period: period,
+	}, nil
+}
+
+func (c *SysfsPWMChannel) PwmWrite(level byte) error {
+	return c.pin.SetDutyCycle(uint32(uint64(c.period) * uint64(level) / 255))
+}
+
+// Unexport disables the channel and releases it to the operating system.
+func (c *SysfsPWMChannel) Unexport() error {
+	if err := c.pin.Enable(false); err != nil {
+		return err
+	}
+	return c.pin.Unexport()
+}
+
+// The gobot drivers and Servo are the Raspberry PI implementations. The
+// DirectPinDriver does PWM through pi-blaster.
+var (
+	_ MotorChannel       = (*gpio.DirectPinDriver)(nil)
+	_ DigitalPin         = (*gpio.DirectPinDriver)(nil)
+	_ MotorChannel       = (*SysfsPWMChannel)(nil)
+	_ Light              = (*gpio.LedDriver)(nil)
+	_ CalibratedActuator = (*Servo)(nil)
+)
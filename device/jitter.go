@@ -0,0 +1,165 @@
+package device
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Concealment of audio missing from the jitter buffer.
+const (
+	CONCEAL_SILENCE = "silence" // Play silence.
+	CONCEAL_REPEAT  = "repeat"  // Repeat the last frame fading out, then silence.
+)
+
+// ConcealModes maps names to concealment modes.
+var ConcealModes = map[string]bool{
+	CONCEAL_SILENCE: true,
+	CONCEAL_REPEAT:  true,
+}
+
+// concealRepeats is how many frames CONCEAL_REPEAT repeats, halving the
+// volume each time.
+const concealRepeats = 3
+
+// JitterStats counts the problems of a jitter buffer.
+type JitterStats struct {
+	Underruns int // Times the buffer ran out of audio.
+	Overruns  int // Times the buffer grew beyond its limit.
+	Dropped   int // Chunks dropped to get back to the target latency.
+}
+
+// JitterBuffer smooths audio chunks that arrive unevenly from the network
+// into frames for the hardware. Frames are played once target samples are
+// buffered, and missing audio is concealed while it fills up again.
+type JitterBuffer struct {
+	mu      sync.Mutex
+	target  int // Samples buffered before playing.
+	max     int // Samples buffered before dropping chunks.
+	conceal string
+
+	chunks  [][]int16 // Queued audio, the first one played up to off.
+	off     int
+	fill    int     // Samples queued.
+	playing bool    // False while buffering up to target.
+	last    []int16 // Last frame played.
+	repeats int     // Frames concealed in a row.
+	stats   JitterStats
+}
+
+// NewJitterBuffer returns a JitterBuffer playing once target samples are
+// buffered and dropping the oldest chunks beyond max samples.
+func NewJitterBuffer(target, max int, conceal string) (*JitterBuffer, error) {
+	if target < 0 || max < target {
+		return nil, fmt.Errorf("jitter buffer needs 0 <= target <= max, got %v and %v", target, max)
+	}
+	if !ConcealModes[conceal] {
+		return nil, fmt.Errorf("unknown concealment %q", conceal)
+	}
+	return &JitterBuffer{
+		target:  target,
+		max:     max,
+		conceal: conceal,
+	}, nil
+}
+
+// Push queues chunk pcm.
+func (j *JitterBuffer) Push(pcm []int16) {
+	if len(pcm) == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.chunks = append(j.chunks, pcm)
+	j.fill += len(pcm)
+	if j.fill <= j.max {
+		return
+	}
+	j.stats.Overruns++
+	for j.fill > j.target && len(j.chunks) > 1 {
+		j.fill -= len(j.chunks[0]) - j.off
+		j.chunks = j.chunks[1:]
+		j.off = 0
+		j.stats.Dropped++
+	}
+}
+
+// Pop fills frame with the next audio, concealing what is missing.
+func (j *JitterBuffer) Pop(frame []int16) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.playing {
+		if j.fill < j.target || j.fill == 0 {
+			j.concealFrame(frame)
+			return
+		}
+		j.playing = true
+	}
+
+	n := j.read(frame)
+	if n < len(frame) {
+		j.stats.Underruns++
+		j.playing = false
+	}
+	if n > 0 {
+		j.repeats = 0
+		j.last = append(j.last[:0], frame[:n]...)
+	}
+	j.concealFrame(frame[n:])
+}
+
+// Reset drops the queued audio and waits for target samples again. Stats
+// are kept.
+func (j *JitterBuffer) Reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.chunks = nil
+	j.off, j.fill = 0, 0
+	j.playing = false
+	j.last = j.last[:0]
+	j.repeats = 0
+}
+
+// Stats returns the counters of j.
+func (j *JitterBuffer) Stats() JitterStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stats
+}
+
+// read copies queued audio into frame and returns the samples copied.
+// Caller must hold j.mu.
+func (j *JitterBuffer) read(frame []int16) int {
+	n := 0
+	for n < len(frame) && len(j.chunks) > 0 {
+		c := copy(frame[n:], j.chunks[0][j.off:])
+		n += c
+		j.off += c
+		if j.off == len(j.chunks[0]) {
+			j.chunks = j.chunks[1:]
+			j.off = 0
+		}
+	}
+	j.fill -= n
+	return n
+}
+
+// concealFrame fills frame in place of missing audio. Caller must hold
+// j.mu.
+func (j *JitterBuffer) concealFrame(frame []int16) {
+	if len(frame) == 0 {
+		return
+	}
+	if j.conceal != CONCEAL_REPEAT || len(j.last) == 0 || j.repeats >= concealRepeats {
+		for i := range frame {
+			frame[i] = 0
+		}
+		return
+	}
+	j.repeats++
+	for i := range frame {
+		frame[i] = j.last[i%len(j.last)] >> uint(j.repeats)
+	}
+}
@@ -0,0 +1,136 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewJitterBuffer(t *testing.T) {
+	tests := []struct {
+		target, max int
+		conceal     string
+		ok          bool
+	}{
+		{0, 0, CONCEAL_SILENCE, true},
+		{160, 800, CONCEAL_REPEAT, true},
+		{-1, 10, CONCEAL_SILENCE, false},
+		{10, 5, CONCEAL_SILENCE, false},
+		{10, 20, "noise", false},
+	}
+	for _, tc := range tests {
+		if _, err := NewJitterBuffer(tc.target, tc.max, tc.conceal); (err == nil) != tc.ok {
+			t.Errorf("NewJitterBuffer(%v, %v, %q) = %v, want ok %v", tc.target, tc.max, tc.conceal, err, tc.ok)
+		}
+	}
+}
+
+// jitterStep pushes push, or pops a frame the length of pop and expects pop.
+type jitterStep struct {
+	push, pop []int16
+}
+
+func TestJitterBuffer(t *testing.T) {
+	tests := []struct {
+		name        string
+		target, max int
+		conceal     string
+		steps       []jitterStep
+		want        JitterStats
+	}{
+		{"waits for target", 4, 8, CONCEAL_SILENCE, []jitterStep{
+			{push: []int16{1, 2}},
+			{pop: []int16{0, 0}},
+			{push: []int16{3, 4}},
+			{pop: []int16{1, 2}},
+			{pop: []int16{3, 4}},
+			{pop: []int16{0, 0}},
+		}, JitterStats{Underruns: 1}},
+		{"frame spans chunks", 1, 10, CONCEAL_SILENCE, []jitterStep{
+			{push: []int16{1, 2, 3}},
+			{push: []int16{4, 5}},
+			{pop: []int16{1, 2, 3, 4}},
+			{pop: []int16{5, 0}},
+		}, JitterStats{Underruns: 1}},
+		{"repeat fades out", 2, 10, CONCEAL_REPEAT, []jitterStep{
+			{push: []int16{8, 16}},
+			{pop: []int16{8, 16}},
+			{pop: []int16{4, 8}},
+			{pop: []int16{2, 4}},
+			{pop: []int16{1, 2}},
+			{pop: []int16{0, 0}},
+			{push: []int16{-8, 8}},
+			{pop: []int16{-8, 8}},
+			{pop: []int16{-4, 4}},
+		}, JitterStats{Underruns: 2}},
+		{"repeat fills a short frame", 2, 10, CONCEAL_REPEAT, []jitterStep{
+			{push: []int16{8, 16, 24}},
+			{pop: []int16{8, 16}},
+			{pop: []int16{24, 12}},
+		}, JitterStats{Underruns: 1}},
+		{"overrun drops oldest", 2, 4, CONCEAL_SILENCE, []jitterStep{
+			{push: []int16{1, 2}},
+			{push: []int16{3, 4}},
+			{push: []int16{5, 6}},
+			{pop: []int16{5, 6}},
+		}, JitterStats{Overruns: 1, Dropped: 2}},
+		{"overrun drops part played chunk", 2, 4, CONCEAL_SILENCE, []jitterStep{
+			{push: []int16{1, 2, 3}},
+			{pop: []int16{1}},
+			{push: []int16{4, 5, 6}},
+			{pop: []int16{4, 5, 6}},
+		}, JitterStats{Overruns: 1, Dropped: 1}},
+		{"empty push ignored", 0, 0, CONCEAL_SILENCE, []jitterStep{
+			{push: []int16{}},
+			{pop: []int16{0}},
+		}, JitterStats{}},
+	}
+	for _, tc := range tests {
+		j, err := NewJitterBuffer(tc.target, tc.max, tc.conceal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range tc.steps {
+			if s.pop == nil {
+				j.Push(s.push)
+				continue
+			}
+			frame := make([]int16, len(s.pop))
+			for k := range frame {
+				frame[k] = 99
+			}
+			if j.Pop(frame); !reflect.DeepEqual(frame, s.pop) {
+				t.Errorf("%v: step %v Pop = %v, want %v", tc.name, i, frame, s.pop)
+			}
+		}
+		if got := j.Stats(); got != tc.want {
+			t.Errorf("%v: Stats() = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestJitterBufferReset(t *testing.T) {
+	j, _ := NewJitterBuffer(2, 10, CONCEAL_REPEAT)
+	j.Push([]int16{8, 16, 24, 32, 40})
+	frame := make([]int16, 2)
+	j.Pop(frame)
+	j.Reset()
+
+	// Nothing is left to play or repeat, and it waits for target again.
+	j.Pop(frame)
+	if want := []int16{0, 0}; !reflect.DeepEqual(frame, want) {
+		t.Errorf("Pop after Reset = %v, want %v", frame, want)
+	}
+	j.Push([]int16{1})
+	j.Pop(frame)
+	if want := []int16{0, 0}; !reflect.DeepEqual(frame, want) {
+		t.Errorf("Pop below target after Reset = %v, want %v", frame, want)
+	}
+	j.Push([]int16{2})
+	j.Pop(frame)
+	if want := []int16{1, 2}; !reflect.DeepEqual(frame, want) {
+		t.Errorf("Pop at target after Reset = %v, want %v", frame, want)
+	}
+	if got := j.Stats(); got != (JitterStats{}) {
+		t.Errorf("Stats() = %+v, want none", got)
+	}
+}
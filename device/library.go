@@ -0,0 +1,293 @@
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// Limits of uploaded animations, on top of the frame limits of loading them.
+const (
+	MaxAnimationBytes = 4 << 20 // File size.
+	maxAnimationSide  = 1024    // Pixels per side of the image.
+)
+
+// animationManifest is the file in the library dir listing the animations.
+const animationManifest = "animations.yaml"
+
+// animationName restricts names so they are safe file names.
+var animationName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// animationExts maps image formats to the file extensions they are saved
+// with.
+var animationExts = map[string]string{
+	"png":  ".png",
+	"gif":  ".gif",
+	"jpeg": ".jpg",
+}
+
+// AnimationInfo describes an animation of the library. A GIF uses its own
+// frame timing, an image with FrameW and FrameH is a sprite sheet and any
+// other image is a single frame.
+type AnimationInfo struct {
+	Name    string `json:"name" yaml:"name"`
+	File    string `json:"file" yaml:"file"`                                     // In the library dir.
+	Delay   uint   `json:"delay,omitempty" yaml:"delay,omitempty"`               // ms between frames.
+	FrameW  int    `json:"frame_width,omitempty" yaml:"frame_width,omitempty"`   // Sprite sheet frame width.
+	FrameH  int    `json:"frame_height,omitempty" yaml:"frame_height,omitempty"` // Sprite sheet frame height.
+	Scale   string `json:"scale,omitempty" yaml:"scale,omitempty"`               // See ScaleModes.
+	Convert string `json:"convert,omitempty" yaml:"convert,omitempty"`           // See ConvertModes.
+	Frames  int    `json:"frames" yaml:"frames"`
+}
+
+// libraryManifest is the content of animationManifest.
+type libraryManifest struct {
+	Animations []AnimationInfo   `yaml:"animations"`
+	Active     map[string]string `yaml:"active,omitempty"` // Expression to animation name.
+}
+
+// AnimationLibrary keeps uploaded animations in a directory and which face
+// expressions they are active for.
+type AnimationLibrary struct {
+	mu  sync.Mutex
+	dir string
+	man libraryManifest
+}
+
+// OpenAnimationLibrary opens the library in dir, creating dir if needed.
+func OpenAnimationLibrary(dir string) (*AnimationLibrary, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &AnimationLibrary{
+		dir: dir,
+		man: libraryManifest{Active: make(map[string]string)},
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, animationManifest))
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, &l.man); err != nil {
+		return nil, fmt.Errorf("invalid animation manifest: %v", err)
+	}
+	if l.man.Active == nil {
+		l.man.Active = make(map[string]string)
+	}
+	return l, nil
+}
+
+// List returns the animations sorted by name.
+func (l *AnimationLibrary) List() []AnimationInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := append([]AnimationInfo(nil), l.man.Animations...)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Active returns the animation names by expression.
+func (l *AnimationLibrary) Active() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := make(map[string]string)
+	for expr, name := range l.man.Active {
+		active[expr] = name
+	}
+	return active
+}
+
+// Add validates the PNG, GIF or JPEG image in data and saves it as info,
+// replacing any animation with the same name. info.File is set by Add.
+func (l *AnimationLibrary) Add(info AnimationInfo, data []byte) error {
+	if !animationName.MatchString(info.Name) {
+		return fmt.Errorf("animation name needs to be 1 to 32 letters, digits, _ or -, got %q", info.Name)
+	}
+	if len(data) > MaxAnimationBytes {
+		return fmt.Errorf("animation is %v bytes, the limit is %v", len(data), MaxAnimationBytes)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unsupported image: %v", err)
+	}
+	ext, ok := animationExts[format]
+	if !ok {
+		return fmt.Errorf("unsupported image format %v", format)
+	}
+	if cfg.Width > maxAnimationSide || cfg.Height > maxAnimationSide {
+		return fmt.Errorf("image is %vx%v, the limit is %vx%v", cfg.Width, cfg.Height, maxAnimationSide, maxAnimationSide)
+	}
+	if _, err := ParseImageOptions(info.Scale, info.Convert); err != nil {
+		return err
+	}
+
+	// Load from a temp file so a bad upload does not replace a good one.
+	// Loading checks the frame limits before decoding.
+	tmp, err := ioutil.TempFile(l.dir, ".upload-*"+ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	info.File = filepath.Base(tmp.Name())
+	a, err := l.load(info)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info.File = info.Name + ext
+	info.Frames = a.Frames()
+	if err := os.Rename(tmp.Name(), filepath.Join(l.dir, info.File)); err != nil {
+		return err
+	}
+
+	i := l.find(info.Name)
+	if i < 0 {
+		l.man.Animations = append(l.man.Animations, info)
+	} else {
+		if old := l.man.Animations[i].File; old != info.File {
+			os.Remove(filepath.Join(l.dir, old))
+		}
+		l.man.Animations[i] = info
+	}
+	glog.Infof("Added animation %v with %v frames", info.Name, info.Frames)
+	return l.save()
+}
+
+// Load loads animation name.
+func (l *AnimationLibrary) Load(name string) (*Animation, error) {
+	l.mu.Lock()
+	i := l.find(name)
+	if i < 0 {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("unknown animation %q", name)
+	}
+	info := l.man.Animations[i]
+	l.mu.Unlock()
+
+	// Decoding is slow, others can use the library meanwhile.
+	return l.load(info)
+}
+
+// Delete removes animation name and returns the expressions it was active
+// for.
+func (l *AnimationLibrary) Delete(name string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := l.find(name)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown animation %q", name)
+	}
+	if err := os.Remove(filepath.Join(l.dir, l.man.Animations[i].File)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l.man.Animations = append(l.man.Animations[:i], l.man.Animations[i+1:]...)
+
+	var exprs []string
+	for expr, n := range l.man.Active {
+		if n == name {
+			exprs = append(exprs, expr)
+			delete(l.man.Active, expr)
+		}
+	}
+	sort.Strings(exprs)
+	glog.Infof("Deleted animation %v", name)
+	return exprs, l.save()
+}
+
+// Activate makes animation name the one shown for expression expr. An
+// empty name deactivates expr.
+func (l *AnimationLibrary) Activate(name, expr string) error {
+	if !validExpression(expr) {
+		return fmt.Errorf("unknown expression %q", expr)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if name == "" {
+		delete(l.man.Active, expr)
+		return l.save()
+	}
+	if l.find(name) < 0 {
+		return fmt.Errorf("unknown animation %q", name)
+	}
+	l.man.Active[expr] = name
+	return l.save()
+}
+
+// Apply sets the active animations on f.
+func (l *AnimationLibrary) Apply(f *Face) error {
+	var errs []string
+	for expr, name := range l.Active() {
+		a, err := l.Load(name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", expr, err))
+			continue
+		}
+		f.SetAnimation(expr, a)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to load active animations: %v", errs)
+	}
+	return nil
+}
+
+// find returns the index of animation name or -1. Caller must hold l.mu.
+func (l *AnimationLibrary) find(name string) int {
+	for i, info := range l.man.Animations {
+		if info.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// load loads the animation described by info.
+func (l *AnimationLibrary) load(info AnimationInfo) (*Animation, error) {
+	o, err := ParseImageOptions(info.Scale, info.Convert)
+	if err != nil {
+		return nil, err
+	}
+	a, err := LoadAnimation(info.Delay, info.FrameW, info.FrameH, filepath.Join(l.dir, info.File))
+	if err != nil {
+		return nil, err
+	}
+	a.SetImageOptions(o)
+	return a, nil
+}
+
+// save writes the manifest. Caller must hold l.mu.
+func (l *AnimationLibrary) save() error {
+	data, err := yaml.Marshal(l.man)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(l.dir, animationManifest), data, 0644)
+}
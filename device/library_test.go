@@ -0,0 +1,241 @@
+package device
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeGIF returns a GIF of frames 1x1 frames.
+func encodeGIF(t *testing.T, frames int) []byte {
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, filled(0, 0, 1, 1, uint8(i%2+1)))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// dirFiles returns the sorted names of the files in dir.
+func dirFiles(t *testing.T, dir string) []string {
+	fis, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLibraryAddInvalid(t *testing.T) {
+	img := encodePNG(t, filled(0, 0, 2, 2, 1))
+	tests := []struct {
+		name string
+		info AnimationInfo
+		data []byte
+	}{
+		{"empty name", AnimationInfo{}, img},
+		{"path in name", AnimationInfo{Name: "../face"}, img},
+		{"dot in name", AnimationInfo{Name: "face.png"}, img},
+		{"space in name", AnimationInfo{Name: "my face"}, img},
+		{"long name", AnimationInfo{Name: strings.Repeat("a", 33)}, img},
+		{"not an image", AnimationInfo{Name: "face"}, []byte("hello")},
+		{"too many bytes", AnimationInfo{Name: "face"}, make([]byte, MaxAnimationBytes+1)},
+		{"too wide", AnimationInfo{Name: "face"}, encodePNG(t, filled(0, 0, maxAnimationSide+1, 1, 1))},
+		{"too many frames", AnimationInfo{Name: "face"}, encodeGIF(t, maxAnimationFrames+1)},
+		{"too many sprites", AnimationInfo{Name: "face", FrameW: 1, FrameH: 1}, encodePNG(t, filled(0, 0, 300, 1, 1))},
+		{"bad sprite size", AnimationInfo{Name: "face", FrameW: 3, FrameH: 2}, img},
+		{"unknown scale", AnimationInfo{Name: "face", Scale: "huge"}, img},
+	}
+	for _, tc := range tests {
+		dir := t.TempDir()
+		l, err := OpenAnimationLibrary(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Add(tc.info, tc.data); err == nil {
+			t.Errorf("%v: Add succeeded", tc.name)
+		}
+		if files := dirFiles(t, dir); len(files) != 0 {
+			t.Errorf("%v: files %v left in the library", tc.name, files)
+		}
+		if list := l.List(); len(list) != 0 {
+			t.Errorf("%v: List() = %v, want none", tc.name, list)
+		}
+	}
+}
+
+func TestLibraryAdd(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenAnimationLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add(AnimationInfo{Name: "wink", File: "../x.png", Delay: 50}, encodePNG(t, filled(0, 0, 4, 2, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add(AnimationInfo{Name: "blink", FrameW: 2, FrameH: 2}, encodePNG(t, filled(0, 0, 4, 2, 1))); err != nil {
+		t.Fatal(err)
+	}
+	want := []AnimationInfo{
+		{Name: "blink", File: "blink.png", FrameW: 2, FrameH: 2, Frames: 2},
+		{Name: "wink", File: "wink.png", Delay: 50, Frames: 1},
+	}
+	if got := l.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	// A GIF replaces the PNG of the same name, a bad upload keeps it.
+	if err := l.Add(AnimationInfo{Name: "wink"}, encodeGIF(t, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add(AnimationInfo{Name: "wink"}, []byte("GIF89a broken")); err == nil {
+		t.Error("Add of a broken GIF succeeded")
+	}
+	want[1] = AnimationInfo{Name: "wink", File: "wink.gif", Frames: 3}
+	if got := l.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() after replacing = %v, want %v", got, want)
+	}
+	if got, exp := dirFiles(t, dir), []string{animationManifest, "blink.png", "wink.gif"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("files = %v, want %v", got, exp)
+	}
+	a, err := l.Load("wink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Frames() != 3 {
+		t.Errorf("Frames() = %v, want 3", a.Frames())
+	}
+	if _, err := l.Load("nod"); err == nil {
+		t.Error("Load of an unknown animation succeeded")
+	}
+
+	// The manifest keeps the library across restarts.
+	l2, err := OpenAnimationLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l2.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() after reopening = %v, want %v", got, want)
+	}
+}
+
+func TestLibraryActivate(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenAnimationLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"wink", "blink"} {
+		if err := l.Add(AnimationInfo{Name: name}, encodeGIF(t, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Activate("wink", "grumpy"); err == nil {
+		t.Error("Activate for an unknown expression succeeded")
+	}
+	if err := l.Activate("nod", EXPR_IDLE); err == nil {
+		t.Error("Activate of an unknown animation succeeded")
+	}
+	for _, expr := range []string{EXPR_IDLE, EXPR_DRIVING, EXPR_SLEEPING} {
+		if err := l.Activate("wink", expr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Activate("blink", EXPR_BLOCKED); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Activate("", EXPR_SLEEPING); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{EXPR_IDLE: "wink", EXPR_DRIVING: "wink", EXPR_BLOCKED: "blink"}
+	if got := l.Active(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Active() = %v, want %v", got, want)
+	}
+
+	f := NewFace(nil, nil, 20, 0)
+	if err := l.Apply(f); err != nil {
+		t.Fatal(err)
+	}
+	for expr := range want {
+		if f.anims[expr] == nil {
+			t.Errorf("no animation for %v after Apply", expr)
+		}
+	}
+
+	// Deleting returns and clears the expressions it was active for.
+	exprs, err := l.Delete("wink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{EXPR_DRIVING, EXPR_IDLE}; !reflect.DeepEqual(exprs, exp) {
+		t.Errorf("Delete = %v, want %v", exprs, exp)
+	}
+	if _, err := l.Delete("wink"); err == nil {
+		t.Error("second Delete succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wink.gif")); !os.IsNotExist(err) {
+		t.Errorf("wink.gif not removed: %v", err)
+	}
+
+	l2, err := OpenAnimationLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{EXPR_BLOCKED: "blink"}
+	if got := l2.Active(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Active() after reopening = %v, want %v", got, want)
+	}
+}
+
+func TestGIFFrames(t *testing.T) {
+	for _, frames := range []int{1, 3, maxAnimationFrames + 1} {
+		n, err := gifFrames(bytes.NewReader(encodeGIF(t, frames)))
+		if err != nil || n != frames {
+			t.Errorf("gifFrames = %v, %v, want %v", n, err, frames)
+		}
+	}
+	for _, data := range []string{"", "PNG", "GIF89a"} {
+		if _, err := gifFrames(strings.NewReader(data)); err == nil {
+			t.Errorf("gifFrames(%q) succeeded", data)
+		}
+	}
+
+	tests := []struct {
+		frames, w, h int
+		ok           bool
+	}{
+		{maxAnimationFrames, 1, 1, true},
+		{maxAnimationFrames + 1, 1, 1, false},
+		{1, 1024, 1024, true},
+		{16, 1024, 1024, false},
+	}
+	for _, tc := range tests {
+		if err := checkFrames("test", tc.frames, tc.w, tc.h); (err == nil) != tc.ok {
+			t.Errorf("checkFrames(%v, %v, %v) = %v, want ok %v", tc.frames, tc.w, tc.h, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// HeadingSensor is an absolute heading source such as an IMU. Heading is in
+// radians, counter clockwise.
+type HeadingSensor interface {
+	Heading() (float64, error)
+}
+
+// MotionCalibration is used to time moves when there is no sensor feedback.
+type MotionCalibration struct {
+	Speed    float64 // Straight line speed at full power in cm/s.
+	TurnRate float64 // Rotation in place at full power in degrees/s.
+}
+
+// Moves using sensor feedback end as stalled when progress stops for
+// stallTimeout, or when they take timeoutFactor times as long as the
+// calibration says plus timeoutMargin.
+const (
+	stallTimeout  = 2 * time.Second
+	timeoutFactor = 2
+	timeoutMargin = time.Second
+)
+
+// SetHeadingSensor sets the sensor used by Rotate. Without it the heading
+// comes from the wheel encoders.
+func (s *Ubiquity) SetHeadingSensor(h HeadingSensor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heading = h
+}
+
+// SetCalibration sets the timing used by MoveDistance and Rotate when the
+// rover has no sensors.
+func (s *Ubiquity) SetCalibration(c MotionCalibration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calib = c
+}
+
+// MoveDistance drives cm forward (negative for backward) at speed percent
+// and blocks until the move ends. It fails if the move stalls.
+func (s *Ubiquity) MoveDistance(cm float64, speed int) error {
+	m, err := s.StartMoveDistance(context.Background(), cm, speed)
+	if err != nil {
+		return err
+	}
+	return waitStalled(m)
+}
+
+// StartMoveDistance starts driving cm forward (negative for backward) at
+// speed percent and returns without waiting. The distance is measured with
+// the wheel encoders or timed from the calibration if there are none.
+func (s *Ubiquity) StartMoveDistance(ctx context.Context, cm float64, speed int) (*Motion, error) {
+	if speed <= 0 || speed > 100 {
+		return nil, fmt.Errorf("speed needs to be 1 to 100, got %v", speed)
+	}
+	if cm == 0 || math.IsNaN(cm) {
+		return nil, fmt.Errorf("distance needs to be non zero, got %v", cm)
+	}
+	v := math.Copysign(float64(speed)/100, cm)
+
+	s.mu.Lock()
+	odom := s.odom.running
+	start := s.wheelDistance()
+	calib := s.calib
+	s.mu.Unlock()
+
+	if odom {
+		glog.V(2).Infof("Moving %vcm using encoders", cm)
+		limit := moveTimeout(math.Abs(cm), calib.Speed*math.Abs(v))
+		return s.startFeedback(ctx, v, v, limit, func() (float64, error) {
+			s.mu.Lock()
+			d := s.wheelDistance()
+			s.mu.Unlock()
+			travelled := (math.Abs(d[0]-start[0]) + math.Abs(d[1]-start[1])) / 2
+			return travelled / math.Abs(cm), nil
+		})
+	}
+
+	if calib.Speed <= 0 {
+		return nil, errors.New("no wheel encoders or speed calibration")
+	}
+	dur := timedMove(math.Abs(cm), calib.Speed*math.Abs(v))
+	glog.V(2).Infof("Moving %vcm using calibration for %vms", cm, dur)
+	return s.StartDrive(ctx, v, v, dur)
+}
+
+// Rotate turns in place deg degrees counter clockwise (negative for
+// clockwise) at speed percent and blocks until the move ends. It fails if
+// the move stalls.
+func (s *Ubiquity) Rotate(deg float64, speed int) error {
+	m, err := s.StartRotate(context.Background(), deg, speed)
+	if err != nil {
+		return err
+	}
+	return waitStalled(m)
+}
+
+// StartRotate starts turning in place deg degrees counter clockwise
+// (negative for clockwise) at speed percent and returns without waiting.
+// The angle is measured with the heading sensor, the wheel encoders or timed
+// from the calibration, in that order of preference.
+func (s *Ubiquity) StartRotate(ctx context.Context, deg float64, speed int) (*Motion, error) {
+	if speed <= 0 || speed > 100 {
+		return nil, fmt.Errorf("speed needs to be 1 to 100, got %v", speed)
+	}
+	if deg == 0 || math.IsNaN(deg) {
+		return nil, fmt.Errorf("angle needs to be non zero, got %v", deg)
+	}
+	v := math.Copysign(float64(speed)/100, deg)
+
+	s.mu.Lock()
+	sensor := s.heading
+	odom := s.odom.running
+	calib := s.calib
+	s.mu.Unlock()
+
+	heading := func() (float64, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		d := s.wheelDistance()
+		return (d[1] - d[0]) / s.odom.cfg.Track, nil
+	}
+	switch {
+	case sensor != nil:
+		glog.V(2).Infof("Rotating %v' using heading sensor", deg)
+		heading = sensor.Heading
+	case odom:
+		glog.V(2).Infof("Rotating %v' using encoders", deg)
+	default:
+		if calib.TurnRate <= 0 {
+			return nil, errors.New("no heading sensor, wheel encoders or turn calibration")
+		}
+		dur := timedMove(math.Abs(deg), calib.TurnRate*math.Abs(v))
+		glog.V(2).Infof("Rotating %v' using calibration for %vms", deg, dur)
+		return s.StartDrive(ctx, -v, v, dur)
+	}
+
+	prev, err := heading()
+	if err != nil {
+		return nil, err
+	}
+	target := math.Abs(deg) * math.Pi / 180
+	limit := moveTimeout(math.Abs(deg), calib.TurnRate*math.Abs(v))
+	var (
+		mu     sync.Mutex // Feedback is read by the motion and Progress.
+		turned float64
+	)
+
+	return s.startFeedback(ctx, -v, v, limit, func() (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		h, err := heading()
+		if err != nil {
+			return 0, err
+		}
+		turned += math.Remainder(h-prev, 2*math.Pi)
+		prev = h
+		return math.Abs(turned) / target, nil
+	})
+}
+
+// waitStalled waits for m and returns an error if it stalled.
+func waitStalled(m *Motion) error {
+	res, err := m.Wait()
+	if err == nil && res == MOTION_STALLED {
+		err = errors.New("motion stalled")
+	}
+	return err
+}
+
+// timedMove returns the milliseconds a move of size at rate per second takes,
+// at least 1 as StartDrive runs 0 until preempted.
+func timedMove(size, rate float64) int {
+	return int(math.Max(math.Ceil(size/rate*1000), 1))
+}
+
+// moveTimeout returns how long a move of size at rate per second may take,
+// 0 for no limit if rate is not calibrated.
+func moveTimeout(size, rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(timeoutFactor*size/rate*float64(time.Second)) + timeoutMargin
+}
+
+// startFeedback drives the wheels until progress, the part of the move
+// covered, reaches 1. The motion is preempted if progress fails and ends as
+// stalled if progress stops or it runs longer than limit (0 for no limit).
+// progress needs to be safe for concurrent use.
+func (s *Ubiquity) startFeedback(ctx context.Context, left, right float64, limit time.Duration, progress func() (float64, error)) (*Motion, error) {
+	m, err := s.StartDrive(ctx, left, right, 0)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.feedback = progress
+	m.mu.Unlock()
+
+	go func() {
+		tick := time.NewTicker(odometryTick)
+		defer tick.Stop()
+
+		start := time.Now()
+		moved := start // Last time progress went up.
+		best := 0.0
+		for {
+			select {
+			case <-m.done:
+				return
+			case now := <-tick.C:
+				p, err := progress()
+				if err != nil {
+					glog.Errorf("Failed to read motion feedback: %v", err)
+					m.Cancel()
+					return
+				}
+				if p >= 1 {
+					m.complete()
+					return
+				}
+				if p > best {
+					best, moved = p, now
+				}
+				switch {
+				case now.Sub(moved) >= stallTimeout:
+					glog.Errorf("Motion stalled at %.0f%%, no progress for %v", best*100, stallTimeout)
+					m.stall()
+					return
+				case limit > 0 && now.Sub(start) >= limit:
+					glog.Errorf("Motion stalled at %.0f%%, took longer than %v", best*100, limit)
+					m.stall()
+					return
+				}
+			}
+		}
+	}()
+	return m, nil
+}
+
+// wheelDistance returns the signed distance in cm each wheel travelled since
+// the odometry started. Unlike the pose it is not affected by ResetOdometry,
+// so moves measure the difference of two readings. Caller must hold s.mu.
+func (s *Ubiquity) wheelDistance() [2]float64 {
+	var d [2]float64
+	if !s.odom.running {
+		return d
+	}
+	circ := math.Pi * s.odom.cfg.WheelDiameter
+	for i, t := range s.odom.travel {
+		d[i] = float64(t) / s.odom.cfg.TicksPerRev * circ
+	}
+	return d
+}
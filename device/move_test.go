@@ -0,0 +1,181 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitMotion waits up to d for m to end and returns how it ended.
+func waitMotion(t *testing.T, m *Motion, d time.Duration) MotionResult {
+	t.Helper()
+	select {
+	case <-m.Done():
+	case <-time.After(d):
+		m.Cancel()
+		t.Fatalf("motion still running after %v", d)
+	}
+	res, err := m.Wait()
+	if err != nil {
+		t.Fatalf("motion failed: %v", err)
+	}
+	return res
+}
+
+func TestTimedMove(t *testing.T) {
+	tests := []struct {
+		size, rate float64
+		want       int
+	}{
+		{10, 20, 500},
+		{1, 3, 334},
+		{0.001, 10, 1},
+		{1e-9, 100, 1},
+	}
+	for _, tc := range tests {
+		if got := timedMove(tc.size, tc.rate); got != tc.want {
+			t.Errorf("timedMove(%v, %v) = %v, want %v", tc.size, tc.rate, got, tc.want)
+		}
+	}
+}
+
+func TestMoveArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		calib  MotionCalibration
+		rotate bool
+		amount float64
+		speed  int
+	}{
+		{"distance zero", MotionCalibration{20, 90}, false, 0, 50},
+		{"distance no speed", MotionCalibration{20, 90}, false, 10, 0},
+		{"distance too fast", MotionCalibration{20, 90}, false, 10, 101},
+		{"distance uncalibrated", MotionCalibration{0, 90}, false, 10, 50},
+		{"angle zero", MotionCalibration{20, 90}, true, 0, 50},
+		{"angle no speed", MotionCalibration{20, 90}, true, 90, 0},
+		{"angle uncalibrated", MotionCalibration{20, 0}, true, 90, 50},
+	}
+	for _, tc := range tests {
+		u, ch := newTestRover()
+		u.SetCalibration(tc.calib)
+		var err error
+		if tc.rotate {
+			_, err = u.StartRotate(context.Background(), tc.amount, tc.speed)
+		} else {
+			_, err = u.StartMoveDistance(context.Background(), tc.amount, tc.speed)
+		}
+		if err == nil {
+			t.Errorf("%v: move started", tc.name)
+		}
+		if l := levels(ch); l != [4]byte{} {
+			t.Errorf("%v: levels = %v, want all off", tc.name, l)
+		}
+	}
+}
+
+func TestMoveTimed(t *testing.T) {
+	tests := []struct {
+		name   string
+		rotate bool
+		amount float64
+		want   [4]byte // Right fwd, right bwd, left fwd, left bwd.
+	}{
+		{"forward", false, 2, [4]byte{128, 0, 128, 0}},
+		{"backward", false, -2, [4]byte{0, 128, 0, 128}},
+		{"tiny distance", false, 0.001, [4]byte{128, 0, 128, 0}},
+		{"counter clockwise", true, 9, [4]byte{128, 0, 0, 128}},
+		{"clockwise", true, -9, [4]byte{0, 128, 128, 0}},
+		{"tiny angle", true, 0.01, [4]byte{128, 0, 0, 128}},
+	}
+	for _, tc := range tests {
+		u, ch := newTestRover()
+		// 50cm/s and 450'/s at half speed, so the moves take 40 and 20ms.
+		u.SetCalibration(MotionCalibration{Speed: 100, TurnRate: 900})
+		var (
+			m   *Motion
+			err error
+		)
+		if tc.rotate {
+			m, err = u.StartRotate(context.Background(), tc.amount, 50)
+		} else {
+			m, err = u.StartMoveDistance(context.Background(), tc.amount, 50)
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if l := levels(ch); l != tc.want {
+			t.Errorf("%v: levels = %v, want %v", tc.name, l, tc.want)
+		}
+		if res := waitMotion(t, m, time.Second); res != MOTION_DONE {
+			t.Errorf("%v: ended %v, want done", tc.name, res)
+		}
+		if l := levels(ch); l != [4]byte{} {
+			t.Errorf("%v: levels after the move = %v, want all off", tc.name, l)
+		}
+	}
+}
+
+func TestMoveFeedback(t *testing.T) {
+	tests := []struct {
+		name   string
+		rotate bool
+		amount float64
+		ticks  [2]uint64 // Encoder ticks counted during the move.
+		want   MotionResult
+	}{
+		{"distance reached", false, 5, [2]uint64{5, 5}, MOTION_DONE},
+		{"distance short", false, 5, [2]uint64{2, 2}, MOTION_STALLED},
+		// 90' needs the wheels to go 7.9cm each way on a 10cm track.
+		{"angle reached", true, 90, [2]uint64{8, 8}, MOTION_DONE},
+		{"angle short", true, -90, [2]uint64{4, 4}, MOTION_STALLED},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			u, l, r := newTestOdometry()
+			var (
+				m   *Motion
+				err error
+			)
+			if tc.rotate {
+				m, err = u.StartRotate(context.Background(), tc.amount, 50)
+			} else {
+				m, err = u.StartMoveDistance(context.Background(), tc.amount, 50)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Add(tc.ticks[0])
+			r.Add(tc.ticks[1])
+			u.updateOdometry()
+
+			if res := waitMotion(t, m, stallTimeout+time.Second); res != tc.want {
+				t.Errorf("ended %v, want %v", res, tc.want)
+			}
+		})
+	}
+}
+
+// TestRotateLikeDrive checks that turning left and rotating by a positive
+// angle spin the rover the same way.
+func TestRotateLikeDrive(t *testing.T) {
+	u, ch := newTestRover()
+	u.SetCalibration(MotionCalibration{Speed: 100, TurnRate: 90})
+	m, err := u.StartMotor(context.Background(), DRIVE_LEFT, 50, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := levels(ch)
+	m.Cancel()
+	waitMotion(t, m, time.Second)
+
+	if m, err = u.StartRotate(context.Background(), 90, 50); err != nil {
+		t.Fatal(err)
+	}
+	if l := levels(ch); l != left {
+		t.Errorf("levels rotating 90' = %v, turning left = %v", l, left)
+	}
+	m.Cancel()
+	waitMotion(t, m, time.Second)
+}
@@ -0,0 +1,144 @@
+package device
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// odometryTick is how often the encoders are sampled.
+const odometryTick = 50 * time.Millisecond
+
+// Pose is the position (cm) and heading (radians, counter clockwise) of the
+// rover relative to where it started.
+type Pose struct {
+	X       float64
+	Y       float64
+	Heading float64
+}
+
+// Encoder counts edges from a wheel encoder. Single channel encoders can
+// not tell direction so the count only goes up.
+type Encoder interface {
+	Count() uint64
+}
+
+// OdometryConfig describes the wheel geometry used for odometry.
+type OdometryConfig struct {
+	TicksPerRev   float64 // Encoder edges per wheel revolution.
+	WheelDiameter float64 // In cm.
+	Track         float64 // Distance between the wheels in cm.
+}
+
+// odometry is the dead reckoning state of the rover.
+type odometry struct {
+	cfg     OdometryConfig
+	enc     [2]Encoder // Left and right encoder.
+	last    [2]uint64  // Last encoder counts.
+	dir     [2]float64 // Last non zero direction of each wheel.
+	ticks   [2]int64   // Signed tick counts since reset.
+	travel  [2]int64   // Signed tick counts since start, not cleared by reset.
+	pose    Pose
+	running bool
+}
+
+// SetOdometry starts estimating the pose of the rover from the left and
+// right wheel encoders.
+func (s *Ubiquity) SetOdometry(left, right Encoder, cfg OdometryConfig) error {
+	if left == nil || right == nil {
+		return errors.New("encoders not initialized")
+	}
+	if cfg.TicksPerRev <= 0 || cfg.WheelDiameter <= 0 || cfg.Track <= 0 {
+		return errors.New("odometry needs positive ticks per rev, wheel diameter and track")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.odom.running {
+		return errors.New("odometry already running")
+	}
+	s.odom = odometry{
+		cfg:     cfg,
+		enc:     [2]Encoder{left, right},
+		last:    [2]uint64{left.Count(), right.Count()},
+		dir:     [2]float64{1, 1},
+		running: true,
+	}
+
+	glog.Infof("Starting odometry %+v", cfg)
+	go func() {
+		tick := time.NewTicker(odometryTick)
+		defer tick.Stop()
+		for range tick.C {
+			s.updateOdometry()
+		}
+	}()
+	return nil
+}
+
+// updateOdometry integrates the encoder ticks since the last update.
+func (s *Ubiquity) updateOdometry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := &s.odom
+	var dist [2]float64
+	for i, e := range o.enc {
+		// The encoders can't tell direction so assume the wheel turns the
+		// way it is being driven, or was last driven while it coasts.
+		if s.wheels[i] != 0 {
+			o.dir[i] = math.Copysign(1, s.wheels[i])
+		}
+		c := e.Count()
+		d := int64(c - o.last[i])
+		o.last[i] = c
+		if o.dir[i] < 0 {
+			d = -d
+		}
+		o.ticks[i] += d
+		o.travel[i] += d
+		dist[i] = float64(d) / o.cfg.TicksPerRev * math.Pi * o.cfg.WheelDiameter
+	}
+
+	dc := (dist[0] + dist[1]) / 2
+	dth := (dist[1] - dist[0]) / o.cfg.Track
+	th := o.pose.Heading + dth/2
+	o.pose.X += dc * math.Cos(th)
+	o.pose.Y += dc * math.Sin(th)
+	o.pose.Heading = math.Remainder(o.pose.Heading+dth, 2*math.Pi)
+}
+
+// Pose returns the pose estimated from the wheel encoders.
+func (s *Ubiquity) Pose() Pose {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.odom.pose
+}
+
+// Ticks returns the signed left and right encoder ticks since reset.
+func (s *Ubiquity) Ticks() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.odom.ticks[0], s.odom.ticks[1]
+}
+
+// HasOdometry returns true if wheel encoders are configured.
+func (s *Ubiquity) HasOdometry() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.odom.running
+}
+
+// ResetOdometry moves the estimated pose back to the origin and clears the
+// tick counts.
+func (s *Ubiquity) ResetOdometry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	glog.Info("Resetting odometry")
+	s.odom.pose = Pose{}
+	s.odom.ticks = [2]int64{}
+}
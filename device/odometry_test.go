@@ -0,0 +1,179 @@
+package device
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+// fakeEncoder is an encoder counted up by the test.
+type fakeEncoder struct {
+	mu    sync.Mutex
+	count uint64
+}
+
+func (e *fakeEncoder) Count() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.count
+}
+
+func (e *fakeEncoder) Add(n uint64) {
+	e.mu.Lock()
+	e.count += n
+	e.mu.Unlock()
+}
+
+// testOdometry has one tick per cm and the wheels 10cm apart.
+var testOdometry = OdometryConfig{
+	TicksPerRev:   40,
+	WheelDiameter: 40 / math.Pi,
+	Track:         10,
+}
+
+// newTestOdometry returns a rover with odometry on fake encoders. Unlike
+// SetOdometry it does not update in the background.
+func newTestOdometry() (*Ubiquity, *fakeEncoder, *fakeEncoder) {
+	u, _ := newTestRover()
+	l, r := &fakeEncoder{}, &fakeEncoder{}
+	u.odom = odometry{
+		cfg:     testOdometry,
+		enc:     [2]Encoder{l, r},
+		dir:     [2]float64{1, 1},
+		running: true,
+	}
+	return u, l, r
+}
+
+// odomStep drives the wheels at velocities wheels while the encoders count
+// ticks.
+type odomStep struct {
+	wheels [2]float64
+	ticks  [2]uint64
+}
+
+func TestOdometry(t *testing.T) {
+	tests := []struct {
+		name      string
+		steps     []odomStep
+		want      Pose
+		wantTicks [2]int64
+	}{
+		{
+			name:      "forward",
+			steps:     []odomStep{{[2]float64{1, 1}, [2]uint64{10, 10}}},
+			want:      Pose{X: 10},
+			wantTicks: [2]int64{10, 10},
+		},
+		{
+			name:      "backward",
+			steps:     []odomStep{{[2]float64{-0.5, -0.5}, [2]uint64{10, 10}}},
+			want:      Pose{X: -10},
+			wantTicks: [2]int64{-10, -10},
+		},
+		{
+			name:      "spin left",
+			steps:     []odomStep{{[2]float64{-1, 1}, [2]uint64{5, 5}}},
+			want:      Pose{Heading: 1},
+			wantTicks: [2]int64{-5, 5},
+		},
+		{
+			name: "coasting keeps the last direction",
+			steps: []odomStep{
+				{[2]float64{-1, -1}, [2]uint64{0, 0}},
+				{[2]float64{0, 0}, [2]uint64{4, 4}},
+			},
+			want:      Pose{X: -4},
+			wantTicks: [2]int64{-4, -4},
+		},
+		{
+			name: "forward then turn",
+			steps: []odomStep{
+				{[2]float64{1, 1}, [2]uint64{10, 10}},
+				{[2]float64{-1, 1}, [2]uint64{5, 5}},
+				{[2]float64{1, 1}, [2]uint64{10, 10}},
+			},
+			want:      Pose{X: 10 + 10*math.Cos(1), Y: 10 * math.Sin(1), Heading: 1},
+			wantTicks: [2]int64{15, 25},
+		},
+	}
+	for _, tc := range tests {
+		u, l, r := newTestOdometry()
+		for _, st := range tc.steps {
+			u.mu.Lock()
+			u.wheels = st.wheels
+			u.mu.Unlock()
+			l.Add(st.ticks[0])
+			r.Add(st.ticks[1])
+			u.updateOdometry()
+		}
+
+		p := u.Pose()
+		if math.Abs(p.X-tc.want.X) > 1e-9 || math.Abs(p.Y-tc.want.Y) > 1e-9 || math.Abs(p.Heading-tc.want.Heading) > 1e-9 {
+			t.Errorf("%v: pose = %+v, want %+v", tc.name, p, tc.want)
+		}
+		if tl, tr := u.Ticks(); [2]int64{tl, tr} != tc.wantTicks {
+			t.Errorf("%v: ticks = %v %v, want %v", tc.name, tl, tr, tc.wantTicks)
+		}
+	}
+}
+
+func TestResetOdometry(t *testing.T) {
+	u, l, r := newTestOdometry()
+	u.mu.Lock()
+	u.wheels = [2]float64{1, 1}
+	u.mu.Unlock()
+	l.Add(10)
+	r.Add(10)
+	u.updateOdometry()
+
+	u.ResetOdometry()
+	if p := u.Pose(); p != (Pose{}) {
+		t.Errorf("pose after reset = %+v", p)
+	}
+	if tl, tr := u.Ticks(); tl != 0 || tr != 0 {
+		t.Errorf("ticks after reset = %v %v", tl, tr)
+	}
+	// Moves measure distance across resets.
+	u.mu.Lock()
+	d := u.wheelDistance()
+	u.mu.Unlock()
+	if math.Abs(d[0]-10) > 1e-9 || math.Abs(d[1]-10) > 1e-9 {
+		t.Errorf("wheel distance after reset = %v, want 10cm each", d)
+	}
+}
+
+func TestSetOdometry(t *testing.T) {
+	enc := &fakeEncoder{}
+	tests := []struct {
+		name        string
+		left, right Encoder
+		cfg         OdometryConfig
+	}{
+		{"no left encoder", nil, enc, testOdometry},
+		{"no right encoder", enc, nil, testOdometry},
+		{"no ticks", enc, enc, OdometryConfig{0, 6.5, 10}},
+		{"no diameter", enc, enc, OdometryConfig{40, 0, 10}},
+		{"no track", enc, enc, OdometryConfig{40, 6.5, -1}},
+	}
+	for _, tc := range tests {
+		u, _ := newTestRover()
+		if err := u.SetOdometry(tc.left, tc.right, tc.cfg); err == nil {
+			t.Errorf("%v: SetOdometry succeeded", tc.name)
+		}
+		if u.HasOdometry() {
+			t.Errorf("%v: HasOdometry after failed SetOdometry", tc.name)
+		}
+	}
+
+	u, _ := newTestRover()
+	if err := u.SetOdometry(enc, enc, testOdometry); err != nil {
+		t.Fatal(err)
+	}
+	if !u.HasOdometry() {
+		t.Error("HasOdometry = false after SetOdometry")
+	}
+	if err := u.SetOdometry(enc, enc, testOdometry); err == nil {
+		t.Error("second SetOdometry succeeded")
+	}
+}
@@ -2,8 +2,11 @@ package device
 
 import (
 	"errors"
+	"fmt"
 	"image"
-	"image/png"
+	_ "image/gif"  // Registers the GIF decoder.
+	_ "image/jpeg" // Registers the JPEG decoder.
+	_ "image/png"  // Registers the PNG decoder.
 	"os"
 	"sync"
 	"time"
@@ -23,16 +26,80 @@ type imageData struct {
 type Animation struct {
 	images []image.Image // List of images to cycle through.
 	d      uint          // Time ms to cycle between each image.
+	delays []uint        // Time ms to show each image, overrides d.
+	opts   ImageOptions  // How images are converted for the display.
 }
 
+// NewAnimation returns an animation showing each image for its delay in ms.
+func NewAnimation(imgs []image.Image, delays []uint) (*Animation, error) {
+	if len(imgs) == 0 {
+		return nil, errors.New("animation has no frames")
+	}
+	if len(delays) != len(imgs) {
+		return nil, fmt.Errorf("animation has %v frames but %v delays", len(imgs), len(delays))
+	}
+	return &Animation{
+		images: imgs,
+		delays: delays,
+	}, nil
+}
+
+// Frames returns the number of frames.
+func (a *Animation) Frames() int {
+	return len(a.images)
+}
+
+// SetImageOptions sets how the frames are scaled and converted to on/off
+// pixels for the display.
+func (a *Animation) SetImageOptions(o ImageOptions) {
+	a.opts = o
+}
+
+// delay returns how long frame i is shown.
+func (a *Animation) delay(i int) time.Duration {
+	d := a.d
+	if i < len(a.delays) {
+		d = a.delays[i]
+	}
+	if d == 0 {
+		d = defaultFrameDelay
+	}
+	return time.Duration(d) * time.Millisecond
+}
+
+// ssd1306 adapts the gobot SSD1306 driver to Display.
+type ssd1306 struct {
+	*i2c.SSD1306Driver
+}
+
+func (d ssd1306) Size() (int, int) {
+	return d.Buffer.Width, d.Buffer.Height
+}
+
+// defaultFrameDelay is used for frames without a delay in ms.
+const defaultFrameDelay = 100
+
 type OLED struct {
-	quitLoop chan struct{}
-	curr     uint
-	images   []imageData
-	tick     *time.Ticker
-	updateCh chan *Animation
-	oled     *i2c.SSD1306Driver
-	lock     *sync.Mutex // See Init() doc.
+	quitLoop  chan struct{}
+	curr      uint
+	images    []imageData
+	anim      *Animation // Frame timing of images.
+	tick      *time.Ticker
+	updateCh  chan *Animation
+	textCh    chan []Text
+	texts     []Text    // Drawn over the images.
+	textStart time.Time // When texts were shown, for scrolling.
+
+	dashSrcCh  chan func() []string
+	dashModeCh chan dashboardMode
+	dashboard  func() []string // Dashboard lines, see SetDashboard.
+	dashMode   dashboardMode
+	showDash   bool      // Dashboard is on screen.
+	dashSince  time.Time // When the screen last rotated.
+
+	oled   Display
+	mirror *frameMirror // Copy of the pixels of oled for previews.
+	lock   *sync.Mutex  // See Init() doc.
 }
 
 // New returns an initialized OLED.
@@ -42,6 +109,10 @@ func NewOLED() *OLED {
 		tick:     time.NewTicker(100 * time.Millisecond),
 		curr:     0,
 		updateCh: make(chan *Animation),
+		textCh:   make(chan []Text),
+
+		dashSrcCh:  make(chan func() []string),
+		dashModeCh: make(chan dashboardMode),
 	}
 }
 
@@ -49,20 +120,45 @@ func NewOLED() *OLED {
 // mutex is to sync writes when there are multiple OLED displays on the same bus. It looks
 // like data meant for one address ends up on another. Possible bit corruption?
 func (s *OLED) InitOLED(r *raspi.Adaptor, bus int, i2cAddress int, mutex *sync.Mutex, name string) error {
+	d, err := NewSSD1306(r, bus, i2cAddress, 128, 64)
+	if err != nil {
+		return err
+	}
+	return s.InitDisplay(d, mutex)
+}
 
-	// Initialize I2C display.
-	oled := i2c.NewSSD1306Driver(r, i2c.WithBus(bus), i2c.WithAddress(i2cAddress))
+// NewSSD1306 starts a w x h SSD1306 OLED on I2C.
+func NewSSD1306(c i2c.Connector, bus int, i2cAddress int, w, h int) (Display, error) {
+	oled := i2c.NewSSD1306Driver(c, i2c.WithBus(bus), i2c.WithAddress(i2cAddress),
+		i2c.WithDisplayWidth(w), i2c.WithDisplayHeight(h))
 	if err := oled.Start(); err != nil {
-		return err
+		return nil, err
+	}
+	oled.SetContrast(10)
+	return ssd1306{oled}, nil
+}
+
+// InitDisplay initializes the OLED with an already started display.
+func (s *OLED) InitDisplay(d Display, mutex *sync.Mutex) error {
+	if d == nil {
+		return errors.New("display not initialized")
 	}
 	s.lock = mutex
-	s.oled = oled
-	s.oled.SetContrast(10)
+	s.mirror = newFrameMirror(d)
+	s.oled = s.mirror.display()
 	s.oled.Reset()
 	s.oled.Clear()
 	return nil
 }
 
+// Size returns the size of the display, 0x0 if it is not initialized.
+func (s *OLED) Size() (int, int) {
+	if s.oled == nil {
+		return 0, 0
+	}
+	return s.oled.Size()
+}
+
 // Animate sends image data to the main processing loop. This is done
 // in the main loop to avoid race conditions; updating image data while
 // its being displayed by draw func.
@@ -74,57 +170,78 @@ func (s *OLED) Animate(imgs []image.Image, d uint) {
 	}
 }
 
-// processImage processes the image data and loads it. It currently only
-// processes the A of rgbA of a monochrome image. 'A' indicates the opacity
-// of the pixel.
-func (s *OLED) processImages(imgs []image.Image) {
+// Play is like Animate with the frame timing of a.
+func (s *OLED) Play(a *Animation) {
+	s.updateCh <- a
+}
 
-	s.images = nil
+// ShowImage shows a single image, like a camera thumbnail, converted with o.
+func (s *OLED) ShowImage(img image.Image, o ImageOptions) {
+	s.updateCh <- &Animation{
+		images: []image.Image{img},
+		opts:   o,
+	}
+}
 
-	for _, img := range imgs {
+// ShowText draws texts over the animation replacing any text shown before.
+// Like Animate it is handed to the main loop.
+func (s *OLED) ShowText(texts ...Text) {
+	s.textCh <- texts
+}
 
-		// Allocate Array.
-		data := make([][]bool, img.Bounds().Max.Y)
-		for j := range data {
-			data[j] = make([]bool, img.Bounds().Max.X)
-		}
+// ClearText removes all text leaving the animation.
+func (s *OLED) ClearText() {
+	s.ShowText()
+}
 
-		// Mark X,Y coordinates which are opaque
-		for y := 0; y < img.Bounds().Max.Y; y++ {
-			for x := 0; x < img.Bounds().Max.X; x++ {
-				_, _, _, a := img.At(x, y).RGBA()
-				if a > 0 {
-					data[y][x] = true
-					continue
-				}
-				data[y][x] = false
-			}
-		}
+// processImages converts the images of a to display frames as set by its
+// ImageOptions.
+func (s *OLED) processImages(a *Animation) {
 
+	s.images = nil
+	w, h := s.oled.Size()
+
+	for _, img := range a.images {
 		s.images = append(s.images, imageData{
-			Xmax: img.Bounds().Max.X,
-			Ymax: img.Bounds().Max.Y,
-			data: data,
+			Xmax: w,
+			Ymax: h,
+			data: convertImage(img, w, h, a.opts),
 		})
 	}
 }
 
-// draw displays image on OLED display.
+// draw displays image and text, or the dashboard, on OLED display.
 func (s *OLED) draw() {
-	if len(s.images) == 0 {
+	if s.showDash {
+		s.drawDashboard()
+		return
+	}
+	if len(s.images) == 0 && len(s.texts) == 0 {
 		return
 	}
 	s.oled.Clear()
-	w := s.oled.Buffer.Width
-	h := s.oled.Buffer.Height
+	w, h := s.oled.Size()
 
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			if s.images[s.curr].Ymax > y && s.images[s.curr].Xmax > x && s.images[s.curr].data[y][x] {
-				s.oled.Set(x, y, 1)
+	// Text and scroll redraws can come before the tick picks a frame of new
+	// images.
+	if int(s.curr) < len(s.images) {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if s.images[s.curr].Ymax > y && s.images[s.curr].Xmax > x && s.images[s.curr].data[y][x] {
+					s.oled.Set(x, y, 1)
+				}
 			}
 		}
 	}
+	elapsed := time.Since(s.textStart)
+	for i := range s.texts {
+		s.texts[i].draw(s.oled, elapsed)
+	}
+	s.display()
+}
+
+// display sends the buffer to the OLED display.
+func (s *OLED) display() {
 	if err := s.oled.Display(); err != nil {
 		glog.Errorf("Failed to display:%v", err)
 		s.oled.Clear()
@@ -138,21 +255,94 @@ func (s *OLED) Run() error {
 	}
 
 	i := 0
+	var scrollTick *time.Ticker
+	var scroll <-chan time.Time // Redraws scrolling text, nil if none.
+	var dashTick *time.Ticker
+	var dash <-chan time.Time // Refreshes the dashboard, nil if off.
 
 	go func() {
 		for {
 			select {
 			case upd := <-s.updateCh:
 				i = 0
-				s.tick = time.NewTicker(time.Duration(upd.d) * time.Millisecond)
-				s.processImages(upd.images)
+				s.anim = upd
+				s.tick.Reset(upd.delay(0))
+				s.processImages(upd)
+
+			case texts := <-s.textCh:
+				s.texts = texts
+				s.textStart = time.Now()
+				if scrollTick != nil {
+					scrollTick.Stop()
+					scrollTick, scroll = nil, nil
+				}
+				if scrolls(texts) {
+					scrollTick = time.NewTicker(textTick)
+					scroll = scrollTick.C
+				}
+				s.lock.Lock()
+				s.draw()
+				s.lock.Unlock()
+
+			case <-scroll:
+				if s.showDash {
+					continue
+				}
+				s.lock.Lock()
+				s.draw()
+				s.lock.Unlock()
+
+			case src := <-s.dashSrcCh:
+				s.dashboard = src
+
+			case m := <-s.dashModeCh:
+				if m.rotate <= 0 {
+					m.rotate = s.dashMode.rotate
+				}
+				if m.rotate <= 0 {
+					m.rotate = dashboardRotate
+				}
+				s.dashMode = m
+				s.dashSince = time.Now()
+				s.showDash = m.mode != DASHBOARD_OFF
+				if dashTick != nil {
+					dashTick.Stop()
+					dashTick, dash = nil, nil
+				}
+				if s.showDash {
+					dashTick = time.NewTicker(dashboardTick)
+					dash = dashTick.C
+				}
+				s.lock.Lock()
+				s.draw()
+				s.lock.Unlock()
+
+			case <-dash:
+				rotated := false
+				if s.dashMode.mode == DASHBOARD_ROTATE && time.Since(s.dashSince) >= s.dashMode.rotate {
+					s.showDash = !s.showDash
+					s.dashSince = time.Now()
+					rotated = true
+				}
+				if !s.showDash && !rotated {
+					continue
+				}
+				s.lock.Lock()
+				s.draw()
+				s.lock.Unlock()
 
 			case <-s.tick.C:
 				if i == len(s.images) {
 					i = 0
 				}
 				s.curr = uint(i)
+				if s.anim != nil {
+					s.tick.Reset(s.anim.delay(i))
+				}
 				i++
+				if s.showDash {
+					continue
+				}
 				s.lock.Lock()
 				s.draw()
 				s.lock.Unlock()
@@ -172,18 +362,13 @@ func (s *OLED) Quit() {
 	s.quitLoop <- struct{}{}
 }
 
-// LoadImages loads images as a image struct and returns a list.
+// LoadImages loads PNG or GIF (first frame) images as a image struct and
+// returns a list.
 func LoadImages(images ...string) ([]image.Image, error) {
 	imageList := []image.Image{}
 
 	for _, imgFile := range images {
-		f, err := os.Open(imgFile)
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
-
-		img, err := png.Decode(f)
+		img, err := loadImage(imgFile)
 		if err != nil {
 			return nil, err
 		}
@@ -191,3 +376,17 @@ func LoadImages(images ...string) ([]image.Image, error) {
 	}
 	return imageList, nil
 }
+
+func loadImage(file string) (image.Image, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", file, err)
+	}
+	return img, nil
+}
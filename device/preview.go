@@ -0,0 +1,150 @@
+package device
+
+import (
+	"image"
+	"image/color"
+	"sync"
+)
+
+// frameMirror wraps the display of an OLED keeping a copy of its pixels for
+// previews. Drawing happens in the OLED loop, frames are read from others.
+type frameMirror struct {
+	d   Display
+	buf *MonoBuffer // Pixels being drawn.
+
+	mu    sync.Mutex
+	shown *MonoBuffer // Pixels last displayed.
+	subs  map[chan struct{}]struct{}
+}
+
+func newFrameMirror(d Display) *frameMirror {
+	w, h := d.Size()
+	return &frameMirror{
+		d:     d,
+		buf:   NewMonoBuffer(w, h),
+		shown: NewMonoBuffer(w, h),
+		subs:  make(map[chan struct{}]struct{}),
+	}
+}
+
+// display returns m as the Display drawn on, a ColorDisplay if the wrapped
+// display is one.
+func (m *frameMirror) display() Display {
+	if c, ok := m.d.(ColorDisplay); ok {
+		return colorMirror{m, c}
+	}
+	return m
+}
+
+func (m *frameMirror) Size() (int, int) {
+	return m.d.Size()
+}
+
+func (m *frameMirror) Clear() {
+	m.d.Clear()
+	m.buf.Clear()
+}
+
+func (m *frameMirror) Set(x, y, c int) {
+	m.d.Set(x, y, c)
+	m.buf.Set(x, y, c)
+}
+
+func (m *frameMirror) Display() error {
+	if err := m.d.Display(); err != nil {
+		return err
+	}
+	m.publish()
+	return nil
+}
+
+func (m *frameMirror) Reset() error {
+	m.buf.Clear()
+	if err := m.d.Reset(); err != nil {
+		return err
+	}
+	m.publish()
+	return nil
+}
+
+func (m *frameMirror) Off() error {
+	return m.d.Off()
+}
+
+// colorMirror is a frameMirror of a ColorDisplay. Colour pixels are
+// mirrored on if they are brighter than half.
+type colorMirror struct {
+	*frameMirror
+	c ColorDisplay
+}
+
+func (m colorMirror) SetRGB(x, y int, c color.Color) {
+	m.c.SetRGB(x, y, c)
+	on := 0
+	if color.GrayModel.Convert(c).(color.Gray).Y >= 0x80 {
+		on = 1
+	}
+	m.buf.Set(x, y, on)
+}
+
+// publish copies the drawn pixels to the shown frame and tells the
+// subscribers.
+func (m *frameMirror) publish() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copy(m.shown.Pix, m.buf.Pix)
+	for ch := range m.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// frame returns the shown frame white on black.
+func (m *frameMirror) frame() *image.Gray {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	img := image.NewGray(image.Rect(0, 0, m.shown.Width, m.shown.Height))
+	for y := 0; y < m.shown.Height; y++ {
+		for x := 0; x < m.shown.Width; x++ {
+			if m.shown.At(x, y) {
+				img.Pix[y*img.Stride+x] = 0xff
+			}
+		}
+	}
+	return img
+}
+
+func (m *frameMirror) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	m.mu.Lock()
+	m.subs[ch] = struct{}{}
+	m.mu.Unlock()
+
+	return ch, func() {
+		m.mu.Lock()
+		delete(m.subs, ch)
+		m.mu.Unlock()
+	}
+}
+
+// Frame returns the pixels last sent to the display, white on black, or
+// nil if the display is not initialized.
+func (s *OLED) Frame() *image.Gray {
+	if s.mirror == nil {
+		return nil
+	}
+	return s.mirror.frame()
+}
+
+// SubscribeFrames returns a channel that receives after every display
+// update and a func to unsubscribe. Updates are dropped while the receiver
+// is busy, Frame returns the latest.
+func (s *OLED) SubscribeFrames() (<-chan struct{}, func()) {
+	if s.mirror == nil {
+		return nil, func() {}
+	}
+	return s.mirror.subscribe()
+}
@@ -0,0 +1,164 @@
+package device
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "Update the golden images in testdata")
+
+// newTestOLED returns an OLED on a 128x64 simulated display without the
+// main loop running.
+func newTestOLED(t *testing.T) *OLED {
+	o := NewOLED()
+	if err := o.InitDisplay(NewSimDisplay(128, 64), &sync.Mutex{}); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// checkGolden compares img to testdata/name, or writes it there with
+// -update.
+func checkGolden(t *testing.T, name string, img *image.Gray) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("failed to decode %v: %v", file, err)
+	}
+	if want.Bounds() != img.Bounds() {
+		t.Fatalf("%v: size %v, want %v", name, img.Bounds(), want.Bounds())
+	}
+	diff := 0
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.GrayModel.Convert(want.At(x, y)).(color.Gray)
+			if g.Y != img.GrayAt(x, y).Y {
+				diff++
+			}
+		}
+	}
+	if diff > 0 {
+		t.Errorf("%v: %v pixels differ from the golden image", name, diff)
+	}
+}
+
+// writeTestGIF writes a 32x16 GIF of a square moving over a gradient.
+func writeTestGIF(t *testing.T) string {
+	pal := color.Palette{}
+	for i := 0; i < 16; i++ {
+		pal = append(pal, color.Gray{uint8(i * 17)})
+	}
+	g := &gif.GIF{}
+	for f := 0; f < 3; f++ {
+		img := image.NewPaletted(image.Rect(0, 0, 32, 16), pal)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 32; x++ {
+				img.SetColorIndex(x, y, uint8(x/4))
+			}
+		}
+		for y := 4; y < 12; y++ {
+			for x := 4 + f*8; x < 12+f*8; x++ {
+				img.SetColorIndex(x, y, 15)
+			}
+		}
+		g.Image = append(g.Image, img)
+		g.Delay = append(g.Delay, 10*(f+1))
+	}
+	file := filepath.Join(t.TempDir(), "square.gif")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, g); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestAnimationGolden(t *testing.T) {
+	a, err := LoadAnimation(0, 0, 0, writeTestGIF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetImageOptions(ImageOptions{Scale: SCALE_FIT, Center: true, Convert: CONVERT_FLOYD_STEINBERG})
+	if a.Frames() != 3 {
+		t.Fatalf("Frames() = %v, want 3", a.Frames())
+	}
+
+	o := newTestOLED(t)
+	o.processImages(a)
+	for i, name := range []string{"anim_0.png", "anim_1.png", "anim_2.png"} {
+		o.curr = uint(i)
+		o.draw()
+		checkGolden(t, name, o.Frame())
+	}
+}
+
+func TestTextGolden(t *testing.T) {
+	tests := []struct {
+		name  string
+		texts []Text
+	}{
+		{"text_left.png", []Text{{Text: "Hello\nUbiquity!"}}},
+		{"text_center.png", []Text{{Text: "Hi", Scale: 3, Align: ALIGN_CENTER, Y: 8}, {Text: "~ubiquity~", Align: ALIGN_CENTER, Y: 40}}},
+		{"text_box.png", []Text{{Text: "clipped to a box", Align: ALIGN_RIGHT, X: 10, Y: 20, W: 60, H: 8}}},
+	}
+	for _, tc := range tests {
+		o := newTestOLED(t)
+		o.texts = tc.texts
+		o.textStart = time.Now()
+		o.draw()
+		checkGolden(t, tc.name, o.Frame())
+	}
+}
+
+func TestPreviewFrames(t *testing.T) {
+	o := NewOLED()
+	if o.Frame() != nil {
+		t.Error("Frame() before InitDisplay is not nil")
+	}
+	o = newTestOLED(t)
+	if err := o.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Quit()
+	frames, unsubscribe := o.SubscribeFrames()
+	defer unsubscribe()
+
+	// The loop shows text right away and publishes the frame.
+	o.ShowText(Text{Text: "Hello\nUbiquity!"})
+	select {
+	case <-frames:
+	case <-time.After(time.Second):
+		t.Fatal("no frame update after ShowText")
+	}
+	checkGolden(t, "text_left.png", o.Frame())
+}
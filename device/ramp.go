@@ -0,0 +1,84 @@
+package device
+
+import (
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// rampTick is how often wheel velocities are updated while ramping.
+const rampTick = 20 * time.Millisecond
+
+// SetRamp sets the time for a wheel to go from stop to full speed. Speed
+// and direction changes are spread over this time to avoid current spikes.
+// Zero applies changes immediately. Brake and AllMotorStop never ramp.
+func (s *Ubiquity) SetRamp(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	glog.Infof("Setting motor ramp to %v", d)
+	s.ramp = d
+	if s.rampKick == nil {
+		s.rampKick = make(chan struct{}, 1)
+		go s.rampLoop()
+	}
+}
+
+// kickRamp wakes up the ramp loop. Caller must hold s.mu.
+func (s *Ubiquity) kickRamp() {
+	select {
+	case s.rampKick <- struct{}{}:
+	default:
+	}
+}
+
+// rampLoop moves the wheels towards their target velocities. The ticker
+// only runs while ramping so the first step comes a tick after the kick.
+func (s *Ubiquity) rampLoop() {
+	for range s.rampKick {
+		tick := time.NewTicker(rampTick)
+		for done := false; !done; {
+			<-tick.C
+			done = s.rampStep()
+		}
+		tick.Stop()
+	}
+}
+
+// rampStep moves the wheels one tick towards their target velocities and
+// returns true once they are there.
+func (s *Ubiquity) rampStep() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wheels == s.target {
+		return true
+	}
+
+	next := s.target
+	if s.ramp > 0 {
+		step := float64(rampTick) / float64(s.ramp)
+		for i := range next {
+			next[i] = approach(s.wheels[i], s.target[i], step)
+		}
+	}
+
+	if err := s.writeWheels(next[0], next[1]); err != nil {
+		glog.Errorf("Failed to ramp motors: %v", err)
+		s.target = s.wheels
+		return true
+	}
+	return s.wheels == s.target
+}
+
+// approach moves v towards target by at most step.
+func approach(v, target, step float64) float64 {
+	if math.Abs(target-v) <= step {
+		return target
+	}
+	if target > v {
+		return v + step
+	}
+	return v - step
+}
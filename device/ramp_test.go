@@ -0,0 +1,147 @@
+package device
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestApproach(t *testing.T) {
+	tests := []struct {
+		v, target, step, want float64
+	}{
+		{0, 1, 0.2, 0.2},
+		{0.9, 1, 0.2, 1},
+		{1, 1, 0.2, 1},
+		{0, -1, 0.2, -0.2},
+		{-0.1, 0, 0.2, 0},
+		{0.5, -0.5, 0.2, 0.3},
+	}
+	for _, tc := range tests {
+		if got := approach(tc.v, tc.target, tc.step); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("approach(%v, %v, %v) = %v, want %v", tc.v, tc.target, tc.step, got, tc.want)
+		}
+	}
+}
+
+func TestRampStep(t *testing.T) {
+	tests := []struct {
+		ramp      time.Duration
+		from, to  [2]float64
+		wantSteps int
+	}{
+		{0, [2]float64{0, 0}, [2]float64{1, 1}, 1},
+		{5 * rampTick, [2]float64{0, 0}, [2]float64{1, 1}, 5},
+		{5 * rampTick, [2]float64{0, 0}, [2]float64{0.5, -0.5}, 3},
+		{5 * rampTick, [2]float64{1, 1}, [2]float64{-1, -1}, 10},
+		{5 * rampTick, [2]float64{1, -1}, [2]float64{1, -1}, 0},
+	}
+	for _, tc := range tests {
+		u, _ := newTestRover()
+		u.ramp = tc.ramp
+		if err := u.writeWheels(tc.from[0], tc.from[1]); err != nil {
+			t.Fatal(err)
+		}
+		u.target = tc.to
+
+		// Count the steps that moved the wheels until the target is reached.
+		steps := 0
+		for done := false; !done && steps <= 100; {
+			before := u.wheels
+			done = u.rampStep()
+			if u.wheels != before {
+				steps++
+			}
+		}
+		if u.wheels != tc.to {
+			t.Errorf("ramp %v from %v to %v ended at %v", tc.ramp, tc.from, tc.to, u.wheels)
+		}
+		if steps != tc.wantSteps {
+			t.Errorf("ramp %v from %v to %v took %v steps, want %v", tc.ramp, tc.from, tc.to, steps, tc.wantSteps)
+		}
+	}
+}
+
+func TestRampDrive(t *testing.T) {
+	u, ch := newTestRover()
+	u.SetRamp(10 * rampTick)
+	if err := u.Drive(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The wheels speed up over the ramp instead of jumping.
+	if l := levels(ch); l[0] == 255 || l[2] == 255 {
+		t.Errorf("levels right after Drive = %v, want ramping", l)
+	}
+	time.Sleep(20 * rampTick)
+	if l := levels(ch); l != [4]byte{255, 0, 255, 0} {
+		t.Errorf("levels after the ramp = %v, want full forward", l)
+	}
+
+	// Stopping is immediate.
+	if err := u.AllMotorStop(); err != nil {
+		t.Fatal(err)
+	}
+	if l := levels(ch); l != [4]byte{} {
+		t.Errorf("levels after AllMotorStop = %v, want all off", l)
+	}
+}
+
+func TestRampFirstStep(t *testing.T) {
+	u, ch := newTestRover()
+	u.SetRamp(10 * rampTick)
+	// Idle long enough for a free running ticker to have a tick waiting.
+	time.Sleep(3 * rampTick)
+
+	start := time.Now()
+	if err := u.Drive(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for levels(ch) == [4]byte{} && time.Since(start) < time.Second {
+		time.Sleep(time.Millisecond)
+	}
+	if d := time.Since(start); d < rampTick*3/4 {
+		t.Errorf("first ramp step after %v, want a tick of %v", d, rampTick)
+	}
+	u.AllMotorStop()
+}
+
+func TestRampDown(t *testing.T) {
+	u, ch := newTestRover()
+	u.SetRamp(10 * rampTick)
+	u.wdTimeout = time.Second
+	m, err := u.StartDrive(context.Background(), 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * rampTick)
+	m.Cancel()
+	m.Wait()
+
+	// The motion ended but the wheels are still slowing down.
+	if l := levels(ch); l[0] == 0 || l[2] == 0 {
+		t.Fatalf("levels right after the motion = %v, want ramping down", l)
+	}
+	u.mu.Lock()
+	moving := u.moving()
+	u.mu.Unlock()
+	if !moving {
+		t.Error("not moving while ramping down")
+	}
+	u.mu.Lock()
+	u.lastBeat = time.Now().Add(-2 * time.Second)
+	u.mu.Unlock()
+	u.checkWatchdog()
+	if !u.WatchdogTripped() {
+		t.Error("watchdog ignored a rover ramping down")
+	}
+	if l := levels(ch); l != [4]byte{} {
+		t.Errorf("levels after the watchdog = %v, want all off", l)
+	}
+	u.mu.Lock()
+	moving = u.moving()
+	u.mu.Unlock()
+	if moving {
+		t.Error("still moving after the watchdog stopped the rover")
+	}
+}
@@ -0,0 +1,91 @@
+package device
+
+import "fmt"
+
+// Limits of audio sample rates in Hz.
+const (
+	MinSampleRate = 4000
+	MaxSampleRate = 192000
+)
+
+// Resampler converts a stream of mono int16 audio between sample rates with
+// linear interpolation. Downsampling averages over the rate ratio first so
+// frequencies above the new Nyquist rate are damped instead of aliased.
+// State is kept between chunks so they join up without clicks.
+type Resampler struct {
+	from, to int
+	step     float64 // Input samples per output sample.
+	pos      float64 // Position of the next output sample from last.
+	last     int16   // Last input sample of the previous chunk.
+	hist     []int   // Previous input samples for the average.
+}
+
+// NewResampler returns a Resampler from rate from to rate to in Hz.
+func NewResampler(from, to int) (*Resampler, error) {
+	for _, r := range []int{from, to} {
+		if r < MinSampleRate || r > MaxSampleRate {
+			return nil, fmt.Errorf("sample rate needs to be %v to %vHz, got %v", MinSampleRate, MaxSampleRate, r)
+		}
+	}
+	r := &Resampler{
+		from: from,
+		to:   to,
+		step: float64(from) / float64(to),
+		pos:  1,
+	}
+	if w := from / to; w > 1 {
+		r.hist = make([]int, w-1)
+	}
+	return r, nil
+}
+
+// Rates returns the input and output sample rates.
+func (r *Resampler) Rates() (from, to int) {
+	return r.from, r.to
+}
+
+// Resample returns pcm at the output rate. Output lengths vary by a sample
+// between calls as the ratio rarely divides the chunk size.
+func (r *Resampler) Resample(pcm []int16) []int16 {
+	if r.from == r.to || len(pcm) == 0 {
+		return pcm
+	}
+	in := r.average(pcm)
+
+	out := make([]int16, 0, int(float64(len(in))/r.step)+1)
+	n := float64(len(in))
+	for ; r.pos < n; r.pos += r.step {
+		i := int(r.pos)
+		a := r.last
+		if i > 0 {
+			a = in[i-1]
+		}
+		b := in[i]
+		out = append(out, int16(int(a)+int(float64(int(b)-int(a))*(r.pos-float64(i)))))
+	}
+	r.pos -= n
+	r.last = in[len(in)-1]
+	return out
+}
+
+// average returns the moving average of pcm over len(hist)+1 samples.
+func (r *Resampler) average(pcm []int16) []int16 {
+	if len(r.hist) == 0 {
+		return pcm
+	}
+	w := len(r.hist) + 1
+	sum := 0
+	for _, v := range r.hist {
+		sum += v
+	}
+
+	out := make([]int16, len(pcm))
+	for i, v := range pcm {
+		sum += int(v)
+		out[i] = int16(sum / w)
+		sum -= r.hist[0]
+		copy(r.hist, r.hist[1:])
+		r.hist[len(r.hist)-1] = int(v)
+	}
+	return out
+}
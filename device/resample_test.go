@@ -0,0 +1,112 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewResampler(t *testing.T) {
+	tests := []struct {
+		from, to int
+		ok       bool
+	}{
+		{8000, 8000, true},
+		{MinSampleRate, MaxSampleRate, true},
+		{44100, 8000, true},
+		{MinSampleRate - 1, 8000, false},
+		{8000, MaxSampleRate + 1, false},
+		{0, 8000, false},
+	}
+	for _, tc := range tests {
+		r, err := NewResampler(tc.from, tc.to)
+		if (err == nil) != tc.ok {
+			t.Errorf("NewResampler(%v, %v) = %v, want ok %v", tc.from, tc.to, err, tc.ok)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		if from, to := r.Rates(); from != tc.from || to != tc.to {
+			t.Errorf("Rates() = %v, %v, want %v, %v", from, to, tc.from, tc.to)
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int
+		chunks   [][]int16
+		want     [][]int16
+	}{
+		{"same rate", 8000, 8000,
+			[][]int16{{1, 2, 3}},
+			[][]int16{{1, 2, 3}}},
+		{"up lags a sample", 4000, 8000,
+			[][]int16{{10, 20, 30}, {40}},
+			[][]int16{{10, 15, 20, 25}, {30, 35}}},
+		{"down averages", 8000, 4000,
+			[][]int16{{10, 20, 30, 40}, {50, 60}},
+			[][]int16{{5, 25}, {45}}},
+		{"empty chunk", 4000, 8000,
+			[][]int16{{}, {10}, {20}},
+			[][]int16{{}, {}, {10, 15}}},
+	}
+	for _, tc := range tests {
+		r, err := NewResampler(tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, c := range tc.chunks {
+			if got := r.Resample(c); !reflect.DeepEqual(got, tc.want[i]) {
+				t.Errorf("%v: chunk %v Resample(%v) = %v, want %v", tc.name, i, c, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestResampleStream(t *testing.T) {
+	tests := []struct {
+		from, to int
+	}{
+		{8000, 16000},
+		{16000, 8000},
+		{44100, 8000},
+		{8000, 44100},
+		{48000, 4000},
+	}
+	in := make([]int16, 4410)
+	for i := range in {
+		in[i] = 1000
+	}
+	for _, tc := range tests {
+		whole, _ := NewResampler(tc.from, tc.to)
+		want := whole.Resample(in)
+
+		// Chunks of any size join up to the same stream.
+		chunked, _ := NewResampler(tc.from, tc.to)
+		var got []int16
+		for i := 0; i < len(in); i += 137 {
+			end := i + 137
+			if end > len(in) {
+				end = len(in)
+			}
+			got = append(got, chunked.Resample(in[i:end])...)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v to %v: chunked output differs from whole", tc.from, tc.to)
+		}
+
+		// Upsampling holds back the output for the last input sample.
+		if n, exp := len(want), len(in)*tc.to/tc.from; n < exp-tc.to/tc.from-1 || n > exp+1 {
+			t.Errorf("%v to %v: %v samples out, want about %v", tc.from, tc.to, n, exp)
+		}
+		// A constant level passes through once the history fills.
+		for i, v := range want[len(want)/2:] {
+			if v != 1000 {
+				t.Errorf("%v to %v: sample %v = %v, want 1000", tc.from, tc.to, len(want)/2+i, v)
+				break
+			}
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package device
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
-	"gobot.io/x/gobot/drivers/gpio"
 )
 
 const (
@@ -17,33 +20,168 @@ const (
 	DRIVE_RIGHT_ONLY
 )
 
+// wheelDirs is the left and right wheel velocity of each direction. Left
+// and right spin in place counter clockwise and clockwise, the same way as
+// positive and negative angles of Rotate.
+var wheelDirs = map[int][2]float64{
+	DRIVE_FWD:        {1, 1},
+	DRIVE_BWD:        {-1, -1},
+	DRIVE_LEFT:       {-1, 1},
+	DRIVE_RIGHT:      {1, -1},
+	DRIVE_LEFT_ONLY:  {1, 0},
+	DRIVE_RIGHT_ONLY: {0, 1},
+}
+
+// Motion results.
+const (
+	MOTION_DONE      MotionResult = iota // Ran for the full duration.
+	MOTION_PREEMPTED                     // Cancelled by a newer command, stop or brake.
+	MOTION_STALLED                       // Sensor feedback stopped or the move took too long.
+)
+
+// MotionResult is how a motor command ended.
+type MotionResult int
+
+func (r MotionResult) String() string {
+	switch r {
+	case MOTION_DONE:
+		return "done"
+	case MOTION_PREEMPTED:
+		return "preempted"
+	case MOTION_STALLED:
+		return "stalled"
+	}
+	return fmt.Sprintf("MotionResult(%d)", int(r))
+}
+
+// Motion is a motor command running in the background.
+type Motion struct {
+	left    float64
+	right   float64
+	cancel  context.CancelFunc
+	reached chan struct{} // Closed when a feedback target is reached or stalls.
+	stalled bool          // Set before reached is closed by stall.
+	once    sync.Once
+	done    chan struct{}
+	result  MotionResult
+	err     error // Error stopping the motors at the end of the motion.
+
+	// Progress of timed motions, or feedback for motions to a target.
+	dur      time.Duration
+	feedback func() (float64, error)
+
+	mu    sync.Mutex
+	start time.Time
+	end   time.Time // Zero while running.
+	last  float64   // Last feedback progress.
+}
+
+// Wait blocks until the motion ends and returns how it ended.
+func (m *Motion) Wait() (MotionResult, error) {
+	<-m.done
+	return m.result, m.err
+}
+
+// Done returns a channel that is closed when the motion ends.
+func (m *Motion) Done() <-chan struct{} {
+	return m.done
+}
+
+// Cancel preempts the motion and stops the motors if it is still running.
+func (m *Motion) Cancel() {
+	m.cancel()
+}
+
+// Progress returns how much of the motion ran from 0 to 1: the distance or
+// angle covered of moves to a target, the time of timed ones. Motions that
+// run until preempted report 0. It keeps counting while the rover coasts to
+// a stop.
+func (m *Motion) Progress() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.feedback != nil {
+		if p, err := m.feedback(); err == nil {
+			m.last = p
+		}
+		return math.Min(m.last, 1)
+	}
+	if m.dur <= 0 {
+		return 0
+	}
+	end := m.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return math.Min(float64(end.Sub(m.start))/float64(m.dur), 1)
+}
+
+// complete ends the motion as done, eg. when a sensor says it has reached
+// its target.
+func (m *Motion) complete() {
+	m.once.Do(func() {
+		close(m.reached)
+	})
+}
+
+// stall ends the motion as stalled, eg. when a sensor says it is not getting
+// any closer to its target.
+func (m *Motion) stall() {
+	m.once.Do(func() {
+		m.stalled = true
+		close(m.reached)
+	})
+}
+
 type Ubiquity struct {
-	motorRightFwd *gpio.DirectPinDriver
-	motorRightBwd *gpio.DirectPinDriver
-	motorLeftFwd  *gpio.DirectPinDriver
-	motorLeftBwd  *gpio.DirectPinDriver
-	Servo         *Servo
-	lock          bool // Handbrake.
-	Headlight     *gpio.LedDriver
+	motorRightFwd MotorChannel
+	motorRightBwd MotorChannel
+	motorLeftFwd  MotorChannel
+	motorLeftBwd  MotorChannel
+	Servos        *Servos
+	lock          bool          // Handbrake.
+	brake         chan struct{} // Closed when the handbrake is engaged.
+	Headlight     Light
+
+	mu     sync.Mutex // Guards motors, lock, motion, odometry and watchdog.
+	motion *Motion    // Currently running motion.
+
+	wheels   [2]float64    // Current left and right wheel velocity.
+	target   [2]float64    // Wheel velocity being ramped to.
+	ramp     time.Duration // Time from stop to full speed, see SetRamp.
+	rampKick chan struct{} // Wakes up the ramp loop.
+
+	odom    odometry          // Pose estimate from wheel encoders.
+	heading HeadingSensor     // Optional IMU, see SetHeadingSensor.
+	calib   MotionCalibration // Timing of moves without sensors.
+	battery BatterySensor     // Optional, see SetBattery.
+
+	wdTimeout time.Duration // Watchdog timeout, see StartWatchdog.
+	wdStarted bool          // Watchdog loop is running.
+	lastBeat  time.Time     // Time of the last heartbeat.
+	wdTripped bool          // Watchdog engaged the brake.
+	wdRetry   bool          // Watchdog failed to stop the motors.
 }
 
 // Return a New initializaed ubiquity device.
 func New(
-	mRF *gpio.DirectPinDriver,
-	mRB *gpio.DirectPinDriver,
-	mLF *gpio.DirectPinDriver,
-	mLB *gpio.DirectPinDriver,
-	servo *Servo,
-	hl *gpio.LedDriver,
+	mRF MotorChannel,
+	mRB MotorChannel,
+	mLF MotorChannel,
+	mLB MotorChannel,
+	servos *Servos,
+	hl Light,
 ) *Ubiquity {
 	return &Ubiquity{
 		motorRightFwd: mRF,
 		motorRightBwd: mRB,
 		motorLeftFwd:  mLF,
 		motorLeftBwd:  mLB,
-		Servo:         servo,
+		Servos:        servos,
 		lock:          false,
 		Headlight:     hl,
+		brake:         make(chan struct{}),
+		lastBeat:      time.Now(),
 	}
 }
 
@@ -51,101 +189,289 @@ func New(
 func (s *Ubiquity) Lock(lock bool) error {
 	glog.Infof("Setting device lock: %v", lock)
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if lock {
-		if err := s.AllMotorStop(); err != nil {
+		s.preempt()
+		// Wake up everything waiting on the brake.
+		close(s.brake)
+		s.brake = make(chan struct{})
+		if err := s.motorStop(); err != nil {
 			return err
 		}
 	}
 
-	s.Servo.Lock(lock)
+	if s.Servos != nil {
+		s.Servos.Lock(lock)
+	}
 	s.lock = lock
+	if !lock {
+		s.wdTripped = false
+		s.wdRetry = false
+	}
 	return nil
 }
 
-// AllMotorStop stops all motors.
-func (s *Ubiquity) AllMotorStop() error {
-	if s.motorRightFwd == nil || s.motorRightBwd == nil ||
-		s.motorLeftFwd == nil || s.motorLeftBwd == nil {
-		return fmt.Errorf("motors not initialized")
-	}
+// BrakeEngaged returns a channel that is closed the next time the handbrake
+// is engaged.
+func (s *Ubiquity) BrakeEngaged() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.brake
+}
 
-	if err := s.motorLeftBwd.DigitalWrite(0); err != nil {
-		return err
-	}
-	if err := s.motorLeftFwd.DigitalWrite(0); err != nil {
-		return err
+// Locked returns true if the handbrake is engaged.
+func (s *Ubiquity) Locked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lock
+}
+
+// Moving returns true if a motion is running or the wheels are turning,
+// including while they ramp down after it ended.
+func (s *Ubiquity) Moving() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.moving()
+}
+
+// moving is Moving for callers holding s.mu.
+func (s *Ubiquity) moving() bool {
+	return s.motion != nil || s.wheels != [2]float64{} || s.target != [2]float64{}
+}
+
+// SetBattery sets the sensor reporting the battery charge.
+func (s *Ubiquity) SetBattery(b BatterySensor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.battery = b
+}
+
+// Battery returns the battery charge in percent.
+func (s *Ubiquity) Battery() (float64, error) {
+	s.mu.Lock()
+	b := s.battery
+	s.mu.Unlock()
+
+	if b == nil {
+		return 0, errors.New("no battery sensor")
 	}
+	return b.Battery()
+}
+
+// AllMotorStop preempts any running motion and stops all motors.
+func (s *Ubiquity) AllMotorStop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.preempt()
+	return s.motorStop()
+}
 
-	if err := s.motorRightBwd.DigitalWrite(0); err != nil {
+// MotorControl moves the rover for dur milliseconds in a specific direction
+// at speed percent (0-100) and blocks until the move ends.
+// dir 0 = fwd, 1 = bwd, 2 = left, 3 = right, 4 = left_only, 5= right_only
+func (s *Ubiquity) MotorControl(dir int, speed int, dur int) error {
+	m, err := s.StartMotor(context.Background(), dir, speed, dur)
+	if err != nil {
 		return err
 	}
+	_, err = m.Wait()
+	return err
+}
 
-	if err := s.motorRightFwd.DigitalWrite(0); err != nil {
-		return err
+// StartMotor starts moving the rover for dur milliseconds in a specific
+// direction at speed percent (0-100) and returns without waiting. Any
+// running motion is preempted. The motion is also preempted when ctx is done.
+func (s *Ubiquity) StartMotor(ctx context.Context, dir int, speed int, dur int) (*Motion, error) {
+	if speed < 0 || speed > 100 {
+		return nil, fmt.Errorf("speed needs to be 0 to 100, got %v", speed)
 	}
-	return nil
+	w, ok := wheelDirs[dir]
+	if !ok {
+		return nil, fmt.Errorf("invalid direction %v", dir)
+	}
+	if dur <= 0 {
+		return nil, fmt.Errorf("duration needs to be positive, got %v", dur)
+	}
+	v := float64(speed) / 100
+	return s.StartDrive(ctx, w[0]*v, w[1]*v, dur)
 }
 
-// Move moves the rover for dur milliseconds in a specific direction.
-// dir 0 = fwd, 1 = bwd, 2 = left, 3 = right, 4 = left_only, 5= right_only
-func (s *Ubiquity) MotorControl(dir int, dur int) error {
+// Drive sets the left and right wheel velocities from -1 (full reverse) to
+// 1 (full forward). The rover keeps moving until the next drive command,
+// stop or brake.
+func (s *Ubiquity) Drive(left, right float64) error {
+	_, err := s.StartDrive(context.Background(), left, right, 0)
+	return err
+}
 
-	if s.motorRightFwd == nil || s.motorRightBwd == nil ||
-		s.motorLeftFwd == nil || s.motorLeftBwd == nil {
-		return fmt.Errorf("motors not initialized")
+// StartDrive starts moving the left and right wheels at velocities from -1
+// (full reverse) to 1 (full forward) for dur milliseconds and returns without
+// waiting. If dur is 0 the motion runs until it is preempted. Any running
+// motion is preempted. The motion is also preempted when ctx is done.
+func (s *Ubiquity) StartDrive(ctx context.Context, left, right float64, dur int) (*Motion, error) {
+	if left < -1 || left > 1 || right < -1 || right > 1 {
+		return nil, fmt.Errorf("wheel velocity needs to be -1 to 1, got %v %v", left, right)
+	}
+	if dur < 0 {
+		return nil, fmt.Errorf("duration needs to be positive, got %v", dur)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.motorsInitialized() {
+		return nil, fmt.Errorf("motors not initialized")
 	}
 
 	if s.lock {
-		return fmt.Errorf("brake engaged")
+		return nil, fmt.Errorf("brake engaged")
 	}
 
-	glog.V(2).Infof("Running motors direction %v dur %v", dir, time.Duration(dur)*time.Millisecond)
+	s.preempt()
+	s.lastBeat = time.Now()
 
-	switch dir {
-	case DRIVE_FWD:
-		if err := s.motorRightFwd.DigitalWrite(1); err != nil {
-			return err
-		}
-		if err := s.motorLeftFwd.DigitalWrite(1); err != nil {
-			return err
-		}
+	glog.V(2).Infof("Running motors left %v right %v dur %v", left, right, time.Duration(dur)*time.Millisecond)
 
-	case DRIVE_BWD:
-		if err := s.motorRightBwd.DigitalWrite(1); err != nil {
-			return err
-		}
-		if err := s.motorLeftBwd.DigitalWrite(1); err != nil {
-			return err
-		}
+	if err := s.setWheels(left, right); err != nil {
+		s.motorStop()
+		return nil, err
+	}
 
-	case DRIVE_LEFT:
-		if err := s.motorLeftFwd.DigitalWrite(1); err != nil {
-			return err
-		}
-		if err := s.motorRightBwd.DigitalWrite(1); err != nil {
-			return err
-		}
+	ctx, cancel := context.WithCancel(ctx)
+	m := &Motion{
+		left:    left,
+		right:   right,
+		cancel:  cancel,
+		reached: make(chan struct{}),
+		done:    make(chan struct{}),
+		dur:     time.Duration(dur) * time.Millisecond,
+		start:   time.Now(),
+	}
+	s.motion = m
+	go s.runMotion(ctx, m, time.Duration(dur)*time.Millisecond)
 
-	case DRIVE_RIGHT:
-		if err := s.motorRightFwd.DigitalWrite(1); err != nil {
-			return err
-		}
-		if err := s.motorLeftBwd.DigitalWrite(1); err != nil {
-			return err
-		}
+	return m, nil
+}
 
-	case DRIVE_LEFT_ONLY:
-		if err := s.motorLeftFwd.DigitalWrite(1); err != nil {
-			return err
-		}
+// runMotion waits for the motion to finish or be cancelled and stops the
+// motors unless another motion has taken over. A zero d runs until cancelled.
+func (s *Ubiquity) runMotion(ctx context.Context, m *Motion, d time.Duration) {
+	var timeout <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
 
-	case DRIVE_RIGHT_ONLY:
-		if err := s.motorRightFwd.DigitalWrite(1); err != nil {
-			return err
+	res := MOTION_DONE
+	select {
+	case <-timeout:
+	case <-m.reached:
+		if m.stalled {
+			res = MOTION_STALLED
 		}
+	case <-ctx.Done():
+		res = MOTION_PREEMPTED
+	}
 
+	// Stop (with deceleration ramp) unless another motion took over.
+	var err error
+	s.mu.Lock()
+	if s.motion == m {
+		s.motion = nil
+		err = s.setWheels(0, 0)
 	}
+	s.mu.Unlock()
+	m.cancel()
+
+	glog.V(2).Infof("Motion left %v right %v ended: %v", m.left, m.right, res)
+	m.mu.Lock()
+	m.end = time.Now()
+	m.mu.Unlock()
+	m.result = res
+	m.err = err
+	close(m.done)
+}
+
+// preempt cancels the running motion. Caller must hold s.mu.
+func (s *Ubiquity) preempt() {
+	if s.motion == nil {
+		return
+	}
+	s.motion.cancel()
+	s.motion = nil
+}
+
+func (s *Ubiquity) motorsInitialized() bool {
+	return s.motorRightFwd != nil && s.motorRightBwd != nil &&
+		s.motorLeftFwd != nil && s.motorLeftBwd != nil
+}
+
+// motorStop releases all H-bridge inputs right away without a ramp.
+// Caller must hold s.mu.
+func (s *Ubiquity) motorStop() error {
+	if !s.motorsInitialized() {
+		return fmt.Errorf("motors not initialized")
+	}
+	s.wheels = [2]float64{}
+	s.target = [2]float64{}
+
+	if err := s.motorLeftBwd.PwmWrite(0); err != nil {
+		return err
+	}
+	if err := s.motorLeftFwd.PwmWrite(0); err != nil {
+		return err
+	}
+
+	if err := s.motorRightBwd.PwmWrite(0); err != nil {
+		return err
+	}
+
+	if err := s.motorRightFwd.PwmWrite(0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// setWheels sets the left and right wheel velocities. With a ramp the
+// wheels are moved to the new velocities by the ramp loop.
+// Caller must hold s.mu.
+func (s *Ubiquity) setWheels(left, right float64) error {
+	s.target = [2]float64{left, right}
+	if s.ramp > 0 {
+		s.kickRamp()
+		return nil
+	}
+	return s.writeWheels(left, right)
+}
+
+// writeWheels drives the H-bridge inputs for left and right wheel
+// velocities. Caller must hold s.mu.
+func (s *Ubiquity) writeWheels(left, right float64) error {
+	s.wheels = [2]float64{left, right}
+	if err := setSide(s.motorLeftFwd, s.motorLeftBwd, left); err != nil {
+		return err
+	}
+	return setSide(s.motorRightFwd, s.motorRightBwd, right)
+}
+
+// setSide drives one side of the H-bridge at velocity v. The opposing input
+// is released first so both are never on together.
+func setSide(fwd, bwd MotorChannel, v float64) error {
+	on, off := fwd, bwd
+	if v < 0 {
+		on, off, v = bwd, fwd, -v
+	}
+	if err := off.PwmWrite(0); err != nil {
+		return err
+	}
+	return on.PwmWrite(velocityToLevel(v))
+}
 
-	time.Sleep(time.Duration(dur) * time.Millisecond)
-	return s.AllMotorStop()
+// velocityToLevel converts a wheel velocity from 0 to 1 to a PWM level.
+func velocityToLevel(v float64) byte {
+	return byte(v*255 + 0.5)
 }
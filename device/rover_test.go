@@ -0,0 +1,159 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeChannel records the last level written to an H-bridge input.
+type fakeChannel struct {
+	mu    sync.Mutex
+	level byte
+}
+
+func (c *fakeChannel) PwmWrite(level byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.level = level
+	return nil
+}
+
+func (c *fakeChannel) Level() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.level
+}
+
+// newTestRover returns a rover on fake channels: right fwd, right bwd, left
+// fwd and left bwd.
+func newTestRover() (*Ubiquity, [4]*fakeChannel) {
+	var ch [4]*fakeChannel
+	for i := range ch {
+		ch[i] = &fakeChannel{}
+	}
+	return New(ch[0], ch[1], ch[2], ch[3], nil, nil), ch
+}
+
+func levels(ch [4]*fakeChannel) [4]byte {
+	var l [4]byte
+	for i, c := range ch {
+		l[i] = c.Level()
+	}
+	return l
+}
+
+func TestStartMotor(t *testing.T) {
+	tests := []struct {
+		dir   int
+		speed int
+		want  [4]byte // Right fwd, right bwd, left fwd, left bwd.
+	}{
+		{DRIVE_FWD, 100, [4]byte{255, 0, 255, 0}},
+		{DRIVE_BWD, 100, [4]byte{0, 255, 0, 255}},
+		{DRIVE_LEFT, 100, [4]byte{255, 0, 0, 255}},
+		{DRIVE_RIGHT, 100, [4]byte{0, 255, 255, 0}},
+		{DRIVE_LEFT_ONLY, 100, [4]byte{0, 0, 255, 0}},
+		{DRIVE_RIGHT_ONLY, 100, [4]byte{255, 0, 0, 0}},
+		{DRIVE_FWD, 50, [4]byte{128, 0, 128, 0}},
+		{DRIVE_FWD, 0, [4]byte{0, 0, 0, 0}},
+	}
+	for _, tc := range tests {
+		u, ch := newTestRover()
+		m, err := u.StartMotor(context.Background(), tc.dir, tc.speed, 10000)
+		if err != nil {
+			t.Fatalf("StartMotor(%v, %v): %v", tc.dir, tc.speed, err)
+		}
+		if got := levels(ch); got != tc.want {
+			t.Errorf("StartMotor(%v, %v) levels = %v, want %v", tc.dir, tc.speed, got, tc.want)
+		}
+		m.Cancel()
+		if res, err := m.Wait(); res != MOTION_PREEMPTED || err != nil {
+			t.Errorf("StartMotor(%v, %v) ended %v %v, want preempted", tc.dir, tc.speed, res, err)
+		}
+		if got := levels(ch); got != [4]byte{} {
+			t.Errorf("StartMotor(%v, %v) levels after cancel = %v, want all off", tc.dir, tc.speed, got)
+		}
+	}
+}
+
+func TestMotorControl(t *testing.T) {
+	tests := []struct {
+		dir, speed, dur int
+		ok              bool
+	}{
+		{DRIVE_FWD, 100, 20, true},
+		{DRIVE_RIGHT_ONLY, 30, 20, true},
+		{-1, 100, 20, false},
+		{DRIVE_RIGHT_ONLY + 1, 100, 20, false},
+		{DRIVE_FWD, 101, 20, false},
+		{DRIVE_FWD, -1, 20, false},
+		{DRIVE_FWD, 100, 0, false},
+	}
+	for _, tc := range tests {
+		u, ch := newTestRover()
+		start := time.Now()
+		err := u.MotorControl(tc.dir, tc.speed, tc.dur)
+		if (err == nil) != tc.ok {
+			t.Errorf("MotorControl(%v, %v, %v) = %v, want ok %v", tc.dir, tc.speed, tc.dur, err, tc.ok)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		if d := time.Since(start); d < time.Duration(tc.dur)*time.Millisecond {
+			t.Errorf("MotorControl(%v, %v, %v) returned after %v", tc.dir, tc.speed, tc.dur, d)
+		}
+		if got := levels(ch); got != [4]byte{} {
+			t.Errorf("MotorControl(%v, %v, %v) levels after = %v, want all off", tc.dir, tc.speed, tc.dur, got)
+		}
+	}
+}
+
+func TestMotorControlNoMotors(t *testing.T) {
+	u := New(nil, nil, nil, nil, nil, nil)
+	if err := u.MotorControl(DRIVE_FWD, 100, 10); err == nil {
+		t.Error("MotorControl without motors succeeded")
+	}
+}
+
+func TestLock(t *testing.T) {
+	u, ch := newTestRover()
+	m, err := u.StartMotor(context.Background(), DRIVE_FWD, 100, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brake := u.BrakeEngaged()
+
+	if err := u.Lock(true); err != nil {
+		t.Fatalf("Lock(true): %v", err)
+	}
+	if got := levels(ch); got != [4]byte{} {
+		t.Errorf("levels after Lock(true) = %v, want all off", got)
+	}
+	if res, _ := m.Wait(); res != MOTION_PREEMPTED {
+		t.Errorf("motion ended %v, want preempted", res)
+	}
+	select {
+	case <-brake:
+	default:
+		t.Error("BrakeEngaged not closed by Lock(true)")
+	}
+	if !u.Locked() {
+		t.Error("Locked() = false after Lock(true)")
+	}
+	if err := u.MotorControl(DRIVE_FWD, 100, 10); err == nil {
+		t.Error("MotorControl with brake engaged succeeded")
+	}
+
+	if err := u.Lock(false); err != nil {
+		t.Fatalf("Lock(false): %v", err)
+	}
+	if u.Locked() {
+		t.Error("Locked() = true after Lock(false)")
+	}
+	if err := u.MotorControl(DRIVE_FWD, 100, 10); err != nil {
+		t.Errorf("MotorControl after releasing brake: %v", err)
+	}
+}
@@ -5,33 +5,96 @@ import (
 	"fmt"
 	"os"
 	"sync"
-	"time"
 
 	"github.com/golang/glog"
 
 	"gobot.io/x/gobot"
+	"gobot.io/x/gobot/drivers/i2c"
 	"gobot.io/x/gobot/sysfs"
 )
 
+// ServoOutput generates the control pulse for a servo.
+type ServoOutput interface {
+	// SetPulse sets the pulse width in microsecs. 0 turns the pulse off.
+	SetPulse(us uint32) error
+	// Release releases the output back to the operating system.
+	Release() error
+}
+
+// ServoCalibration maps servo angles to pulse widths.
+type ServoCalibration struct {
+	MinPulse uint32 `yaml:"min_pulse"` // Pulse at 0' in microsecs.
+	MaxPulse uint32 `yaml:"max_pulse"` // Pulse at 180' in microsecs.
+	Trim     int    `yaml:"trim"`      // Microsecs added to every pulse to center the horn.
+	Invert   bool   `yaml:"invert"`    // Servo is mounted reversed.
+}
+
+// DefaultServoCalibration fits most SG90 class servos.
+var DefaultServoCalibration = ServoCalibration{
+	MinPulse: 500,
+	MaxPulse: 2000,
+}
+
+// Validate checks the pulse range is usable.
+func (c ServoCalibration) Validate() error {
+	if c.MinPulse == 0 || c.MinPulse >= c.MaxPulse {
+		return fmt.Errorf("servo pulse range needs 0 < min < max, got %v to %v", c.MinPulse, c.MaxPulse)
+	}
+	if int(c.MinPulse)+c.Trim <= 0 {
+		return fmt.Errorf("servo trim %v makes the pulse negative", c.Trim)
+	}
+	return nil
+}
+
+// Pulse returns the pulse width in microsecs for angle (0 - 180).
+func (c ServoCalibration) Pulse(angle int) uint32 {
+	if c.Invert {
+		angle = 180 - angle
+	}
+	return uint32(int(c.MinPulse) + int(c.MaxPulse-c.MinPulse)*angle/180 + c.Trim)
+}
+
 type Servo struct {
-	pin       string
-	pwmPeriod uint32 // PWM period in ms.
-	adaptor   gobot.Adaptor
-	lock      bool
-	mut       sync.Mutex
+	out  ServoOutput
+	cal  ServoCalibration
+	lock bool
+	mut  sync.Mutex
 }
 
-// NewServo returns a new servo. note: pin is the BCMxx number not actual number.
-func NewServo(piBlasterPeriod uint32, pin string, a gobot.Adaptor) *Servo {
+// NewServo returns a new servo driven by out with the default calibration.
+func NewServo(out ServoOutput) *Servo {
 	return &Servo{
-		pin:       pin,
-		pwmPeriod: piBlasterPeriod,
-		adaptor:   a,
-		lock:      false,
+		out:  out,
+		cal:  DefaultServoCalibration,
+		lock: false,
 	}
 }
 
+// SetCalibration sets the angle to pulse mapping of the servo.
+func (p *Servo) SetCalibration(c ServoCalibration) error {
+	if p == nil {
+		return errors.New("servo not initialized")
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.cal = c
+	return nil
+}
+
+// Calibration returns the angle to pulse mapping of the servo.
+func (p *Servo) Calibration() ServoCalibration {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.cal
+}
+
 func (p *Servo) Lock(lock bool) error {
+	if p == nil {
+		return errors.New("servo not initialized")
+	}
 	p.lock = lock
 	return nil
 }
@@ -41,10 +104,11 @@ func (p *Servo) Unexport() error {
 	if p == nil {
 		return errors.New("servo not initialized")
 	}
-	return piBlaster(fmt.Sprintf("release %v\n", p.pin))
+	return p.out.Release()
 }
 
-// SetAngle moves the servo to the appropriate angle.
+// SetAngle sends the pulse for angle. It returns right away and the pulse
+// stays on until Detach.
 func (p *Servo) SetAngle(angle int) error {
 	if p == nil {
 		return errors.New("servo not initialized")
@@ -56,21 +120,24 @@ func (p *Servo) SetAngle(angle int) error {
 		return fmt.Errorf("Angle needs to be 0 to 180, got %v", angle)
 	}
 
-	val := 500 + 1500*angle/180
-
-	glog.V(2).Infof("Setting angle:%v -> duty cycle:%v microsecs ", angle, val)
-
 	p.mut.Lock()
-	p.SetDutyCycle(uint32(val))
-	// Turning off servo prevent jitter. 0.1s for 60deg. so 0.3 for 180.
-	time.Sleep(300 * time.Millisecond)
-	p.SetDutyCycle(0)
+	val := p.cal.Pulse(angle)
 	p.mut.Unlock()
 
-	return nil
+	glog.V(2).Infof("Setting angle:%v -> duty cycle:%v microsecs ", angle, val)
+	return p.SetDutyCycle(val)
 }
 
-// SetDutyCycle sets the duty cycle of the PWM.
+// Detach turns the pulse off so the servo stops holding its position. This
+// prevents jitter and works even when the servo is locked.
+func (p *Servo) Detach() error {
+	if p == nil {
+		return errors.New("servo not initialized")
+	}
+	return p.out.SetPulse(0)
+}
+
+// SetDutyCycle sets the pulse width of the PWM in microsecs.
 func (p *Servo) SetDutyCycle(duty uint32) error {
 	if p == nil {
 		return errors.New("servo not initialized")
@@ -78,24 +145,158 @@ func (p *Servo) SetDutyCycle(duty uint32) error {
 	if p.lock {
 		return errors.New("servo locked")
 	}
-	if duty > p.pwmPeriod {
+	return p.out.SetPulse(duty)
+}
+
+// PiBlasterOutput drives a servo through pi-blaster (/dev/pi-blaster).
+type PiBlasterOutput struct {
+	pin    string // BCMxx number.
+	period uint32 // pi-blaster PWM period in microsecs.
+}
+
+// NewPiBlasterOutput returns a servo output on pi-blaster. note: pin is the
+// BCMxx number not actual number.
+func NewPiBlasterOutput(pin string, period uint32) *PiBlasterOutput {
+	return &PiBlasterOutput{
+		pin:    pin,
+		period: period,
+	}
+}
+
+func (o *PiBlasterOutput) SetPulse(us uint32) error {
+	if us > o.period {
 		return errors.New("Duty cycle exceeds period.")
 	}
 
-	val := gobot.FromScale(float64(duty), 0, float64(p.pwmPeriod))
+	val := gobot.FromScale(float64(us), 0, float64(o.period))
 
-	glog.V(2).Infof("Setting PWM duty cycle:%v, period:%v, piBlasterDuty:%v pin:%v", duty, p.pwmPeriod, val, p.pin)
-	return piBlaster(fmt.Sprintf("%v=%v\n", p.pin, val))
+	glog.V(2).Infof("Setting PWM duty cycle:%v, period:%v, piBlasterDuty:%v pin:%v", us, o.period, val, o.pin)
+	return piBlaster(fmt.Sprintf("%v=%v\n", o.pin, val))
 }
 
-func piBlaster(data string) (err error) {
-	fi, err := sysfs.OpenFile("/dev/pi-blaster", os.O_WRONLY|os.O_APPEND, 0644)
-	defer fi.Close()
+func (o *PiBlasterOutput) Release() error {
+	return piBlaster(fmt.Sprintf("release %v\n", o.pin))
+}
 
+func piBlaster(data string) error {
+	fi, err := sysfs.OpenFile("/dev/pi-blaster", os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return err
 	}
+	defer fi.Close()
 
 	_, err = fi.WriteString(data)
-	return
+	return err
+}
+
+// SysfsPWMOutput drives a servo from the kernel PWM interface
+// (/sys/class/pwm/pwmchip0).
+type SysfsPWMOutput struct {
+	pin    *sysfs.PWMPin
+	period uint32 // PWM period in microsecs.
+}
+
+// NewSysfsPWMOutput exports and enables PWM channel ch with a period in
+// microsecs.
+func NewSysfsPWMOutput(ch int, period uint32) (*SysfsPWMOutput, error) {
+	pin := sysfs.NewPWMPin(ch)
+	if err := pin.Export(); err != nil {
+		return nil, fmt.Errorf("failed to export pwm%v: %v", ch, err)
+	}
+	if err := pin.SetPeriod(period * 1000); err != nil {
+		return nil, fmt.Errorf("failed to set period on pwm%v: %v", ch, err)
+	}
+	if err := pin.SetDutyCycle(0); err != nil {
+		return nil, fmt.Errorf("failed to set duty cycle on pwm%v: %v", ch, err)
+	}
+	if err := pin.Enable(true); err != nil {
+		return nil, fmt.Errorf("failed to enable pwm%v: %v", ch, err)
+	}
+	return &SysfsPWMOutput{
+		pin:    pin,
+		period: period,
+	}, nil
+}
+
+func (o *SysfsPWMOutput) SetPulse(us uint32) error {
+	if us > o.period {
+		return errors.New("Duty cycle exceeds period.")
+	}
+	return o.pin.SetDutyCycle(us * 1000)
+}
+
+func (o *SysfsPWMOutput) Release() error {
+	if err := o.pin.Enable(false); err != nil {
+		return err
+	}
+	return o.pin.Unexport()
 }
+
+// PCA9685 is a 16 channel I2C PWM board.
+type PCA9685 struct {
+	drv    *i2c.PCA9685Driver
+	period uint32 // PWM period in microsecs.
+}
+
+// NewPCA9685 starts the PCA9685 board at i2cAddress on bus with a PWM
+// frequency of freq Hz (50 for most servos).
+func NewPCA9685(c i2c.Connector, bus int, i2cAddress int, freq float32) (*PCA9685, error) {
+	drv := i2c.NewPCA9685Driver(c, i2c.WithBus(bus), i2c.WithAddress(i2cAddress))
+	if err := drv.Start(); err != nil {
+		return nil, err
+	}
+	if err := drv.SetPWMFreq(freq); err != nil {
+		return nil, err
+	}
+	return &PCA9685{
+		drv:    drv,
+		period: uint32(1e6 / freq),
+	}, nil
+}
+
+// Output returns a servo output on channel ch (0-15) of the board.
+func (b *PCA9685) Output(ch int) (*PCA9685Output, error) {
+	if ch < 0 || ch > 15 {
+		return nil, fmt.Errorf("PCA9685 channel needs to be 0 to 15, got %v", ch)
+	}
+	return &PCA9685Output{
+		board: b,
+		ch:    ch,
+	}, nil
+}
+
+// PCA9685Output drives a servo from one channel of a PCA9685 board.
+type PCA9685Output struct {
+	board *PCA9685
+	ch    int
+}
+
+func (o *PCA9685Output) SetPulse(us uint32) error {
+	if us > o.board.period {
+		return errors.New("Duty cycle exceeds period.")
+	}
+	off := pca9685Off(us, o.board.period)
+	glog.V(2).Infof("Setting PCA9685 channel:%v pulse:%v microsecs off:%v", o.ch, us, off)
+	return o.board.drv.SetPWM(o.ch, 0, off)
+}
+
+func (o *PCA9685Output) Release() error {
+	return o.board.drv.SetPWM(o.ch, 0, 0)
+}
+
+// pca9685Off returns the off count of a pulse of us microsecs in period.
+// The board counts 4096 steps per period, the off register holds 12 bits so
+// a full period is the last step.
+func pca9685Off(us, period uint32) uint16 {
+	off := uint64(us) * 4096 / uint64(period)
+	if off > 4095 {
+		off = 4095
+	}
+	return uint16(off)
+}
+
+var (
+	_ ServoOutput = (*PiBlasterOutput)(nil)
+	_ ServoOutput = (*SysfsPWMOutput)(nil)
+	_ ServoOutput = (*PCA9685Output)(nil)
+)
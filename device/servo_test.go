@@ -0,0 +1,93 @@
+package device
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeOutput records the pulses sent to a servo.
+type fakeOutput struct {
+	mu       sync.Mutex
+	pulses   []uint32
+	released bool
+}
+
+func (o *fakeOutput) SetPulse(us uint32) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pulses = append(o.pulses, us)
+	return nil
+}
+
+func (o *fakeOutput) Release() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.released = true
+	return nil
+}
+
+func (o *fakeOutput) Pulses() []uint32 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]uint32(nil), o.pulses...)
+}
+
+func TestPCA9685Off(t *testing.T) {
+	tests := []struct {
+		us, period uint32
+		want       uint16
+	}{
+		{0, 20000, 0},
+		{500, 20000, 102},
+		{1500, 20000, 307},
+		{2500, 20000, 512},
+		{10000, 20000, 2048},
+		{20000, 20000, 4095},
+	}
+	for _, tc := range tests {
+		if got := pca9685Off(tc.us, tc.period); got != tc.want {
+			t.Errorf("pca9685Off(%v, %v) = %v, want %v", tc.us, tc.period, got, tc.want)
+		}
+	}
+}
+
+func TestServo(t *testing.T) {
+	tests := []struct {
+		name   string
+		lock   bool
+		duty   uint32
+		want   []uint32
+		wantOK bool
+	}{
+		{"duty cycle", false, 1500, []uint32{1500}, true},
+		{"off", false, 0, []uint32{0}, true},
+		{"locked", true, 1500, nil, false},
+	}
+	for _, tc := range tests {
+		out := &fakeOutput{}
+		s := NewServo(out)
+		s.Lock(tc.lock)
+		if err := s.SetDutyCycle(tc.duty); (err == nil) != tc.wantOK {
+			t.Errorf("%v: SetDutyCycle(%v) = %v, want ok %v", tc.name, tc.duty, err, tc.wantOK)
+		}
+		if got := out.Pulses(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: pulses = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	out := &fakeOutput{}
+	s := NewServo(out)
+	for _, angle := range []int{-1, 181} {
+		if err := s.SetAngle(angle); err == nil {
+			t.Errorf("SetAngle(%v) succeeded", angle)
+		}
+	}
+	if err := s.Unexport(); err != nil || !out.released {
+		t.Errorf("Unexport = %v, released %v", err, out.released)
+	}
+	var nilServo *Servo
+	if err := nilServo.SetAngle(90); err == nil {
+		t.Error("SetAngle on a nil servo succeeded")
+	}
+}
@@ -0,0 +1,189 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	servoTick   = 20 * time.Millisecond  // Interval between the steps of smooth moves.
+	servoSettle = 300 * time.Millisecond // Time a servo takes to travel 180' at full speed.
+)
+
+// ErrServoPreempted is returned by servo moves interrupted by a newer move.
+var ErrServoPreempted = errors.New("servo move preempted")
+
+// MoveTo moves the servo to angle at degPerSec and blocks until it gets
+// there. A degPerSec of 0 jumps to the angle at full speed. Unless the servo
+// holds, the pulse is turned off once the servo has settled.
+func (n *NamedServo) MoveTo(angle, degPerSec int) error {
+	return n.moveTo(context.Background(), angle, degPerSec, true)
+}
+
+// Sweep scans the servo back and forth between from and to at degPerSec
+// until ctx is done or another move preempts it.
+func (n *NamedServo) Sweep(ctx context.Context, from, to, degPerSec int) error {
+	if degPerSec <= 0 {
+		return fmt.Errorf("sweep speed needs to be positive, got %v", degPerSec)
+	}
+	if from == to {
+		return fmt.Errorf("sweep needs two different angles, got %v", from)
+	}
+	n.mu.Lock()
+	err := n.checkAngle(from)
+	if err == nil {
+		err = n.checkAngle(to)
+	}
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	// All legs are one move, so a Stop between them ends the sweep.
+	n.seq++
+	seq := n.seq
+	n.mu.Unlock()
+
+	glog.V(1).Infof("Sweeping servo %v between %v' and %v' at %v'/s", n.cfg.Name, from, to, degPerSec)
+	for {
+		for _, angle := range []int{from, to} {
+			if err := ctx.Err(); err != nil {
+				n.detach(seq)
+				return err
+			}
+			if err := n.move(ctx, seq, angle, degPerSec, false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Stop preempts any move or sweep of the servo.
+func (n *NamedServo) Stop() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	if n.cfg.Hold {
+		return nil
+	}
+	return n.act.Detach()
+}
+
+// SetHold sets if the servo keeps the pulse on after moves. Holding resists
+// loads but the servo may jitter and draws more current.
+func (n *NamedServo) SetHold(hold bool) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cfg.Hold = hold
+	if hold {
+		return nil
+	}
+	return n.act.Detach()
+}
+
+// moveTo starts a new move preempting the running one and steps the servo
+// to angle, see move.
+func (n *NamedServo) moveTo(ctx context.Context, angle, degPerSec int, settle bool) error {
+	n.mu.Lock()
+	if err := n.checkAngle(angle); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	n.seq++
+	seq := n.seq
+	n.mu.Unlock()
+	return n.move(ctx, seq, angle, degPerSec, settle)
+}
+
+// move steps the servo to angle every servoTick so it travels at degPerSec
+// unless a move newer than seq started. With settle it waits for the servo
+// to get there and turns the pulse off unless the servo holds. Without it
+// waits a tick after the last step, so even one step moves take servoTick.
+// n.mu is only held while a step is sent.
+func (n *NamedServo) move(ctx context.Context, seq uint64, angle, degPerSec int, settle bool) error {
+	n.mu.Lock()
+	if seq != n.seq {
+		n.mu.Unlock()
+		return ErrServoPreempted
+	}
+	from := n.angle
+	n.mu.Unlock()
+
+	delta := angle - from
+	if delta < 0 {
+		delta = -delta
+	}
+	steps := 1
+	if degPerSec > 0 {
+		steps = int(time.Duration(delta) * time.Second / time.Duration(degPerSec) / servoTick)
+		if steps < 1 {
+			steps = 1
+		}
+	}
+
+	tick := time.NewTicker(servoTick)
+	defer tick.Stop()
+
+	for i := 1; i <= steps; i++ {
+		if err := n.step(seq, from+(angle-from)*i/steps); err != nil {
+			if err != ErrServoPreempted {
+				n.detach(seq)
+			}
+			return err
+		}
+		if i == steps && settle {
+			break
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			n.detach(seq)
+			return ctx.Err()
+		}
+	}
+	if !settle {
+		return nil
+	}
+
+	// Give the servo time to travel the last step before letting go.
+	t := time.NewTimer(servoSettle*time.Duration(delta/steps)/180 + servoTick)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	n.detach(seq)
+	return ctx.Err()
+}
+
+// step sends angle to the servo unless a newer move started.
+func (n *NamedServo) step(seq uint64, angle int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if seq != n.seq {
+		return ErrServoPreempted
+	}
+	if err := n.act.SetAngle(angle); err != nil {
+		return err
+	}
+	n.angle = angle
+	return nil
+}
+
+// detach turns the pulse off after the move seq unless a newer move started
+// or the servo holds.
+func (n *NamedServo) detach(seq uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if seq != n.seq || n.cfg.Hold {
+		return
+	}
+	if err := n.act.Detach(); err != nil {
+		glog.Errorf("Failed to detach servo %v: %v", n.cfg.Name, err)
+	}
+}
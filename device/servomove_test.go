@@ -0,0 +1,141 @@
+package device
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestServo returns a servo at 90' with 0 to 180' travel.
+func newTestServo(t *testing.T, hold bool) (*NamedServo, *fakeActuator) {
+	act := &fakeActuator{}
+	n, err := NewServos().Add(ServoConfig{Name: "tilt", Min: 0, Max: 180, Step: 10, Hold: hold}, act)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, act
+}
+
+func TestMoveTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		angle     int
+		speed     int
+		hold      bool
+		want      []int
+		wantAngle int
+	}{
+		{"jump", 100, 0, false, []int{100}, 100},
+		{"one step", 100, 500, false, []int{100}, 100},
+		{"up", 100, 100, false, []int{92, 94, 96, 98, 100}, 100},
+		{"down", 80, 100, false, []int{88, 86, 84, 82, 80}, 80},
+		{"hold", 100, 0, true, []int{100}, 100},
+		{"same angle", 90, 100, false, []int{90}, 90},
+	}
+	for _, tc := range tests {
+		n, act := newTestServo(t, tc.hold)
+		if err := n.MoveTo(tc.angle, tc.speed); err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if got := act.Angles(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: angles = %v, want %v", tc.name, got, tc.want)
+		}
+		if got := n.Angle(); got != tc.wantAngle {
+			t.Errorf("%v: Angle() = %v, want %v", tc.name, got, tc.wantAngle)
+		}
+		if detached := act.detached > 0; detached == tc.hold {
+			t.Errorf("%v: detached %v with hold %v", tc.name, detached, tc.hold)
+		}
+	}
+
+	n, _ := newTestServo(t, false)
+	if err := n.MoveTo(181, 0); err == nil {
+		t.Error("MoveTo beyond the limits succeeded")
+	}
+}
+
+func TestSweepArgs(t *testing.T) {
+	tests := []struct {
+		name            string
+		from, to, speed int
+	}{
+		{"no speed", 0, 180, 0},
+		{"same angle", 90, 90, 30},
+		{"from beyond limits", -1, 90, 30},
+		{"to beyond limits", 90, 181, 30},
+	}
+	for _, tc := range tests {
+		n, act := newTestServo(t, false)
+		if err := n.Sweep(context.Background(), tc.from, tc.to, tc.speed); err == nil {
+			t.Errorf("%v: Sweep(%v, %v, %v) succeeded", tc.name, tc.from, tc.to, tc.speed)
+		}
+		if got := act.Angles(); len(got) != 0 {
+			t.Errorf("%v: angles = %v, want none", tc.name, got)
+		}
+	}
+}
+
+func TestSweepCancel(t *testing.T) {
+	tests := []struct {
+		name            string
+		from, to, speed int
+		timeout         time.Duration
+	}{
+		{"cancelled", 0, 180, 90, 0},
+		{"one step legs", 80, 100, 1000, 100 * time.Millisecond},
+		{"long legs", 0, 180, 90, 100 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		n, act := newTestServo(t, false)
+		ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- n.Sweep(ctx, tc.from, tc.to, tc.speed)
+		}()
+
+		select {
+		case err := <-errCh:
+			if err != ctx.Err() {
+				t.Errorf("%v: Sweep = %v, want %v", tc.name, err, ctx.Err())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: Sweep still running a second after ctx is done", tc.name)
+		}
+		cancel()
+
+		// Short legs still take a tick each.
+		if got := len(act.Angles()); tc.timeout > 0 && got > int(tc.timeout/servoTick)+1 {
+			t.Errorf("%v: %v steps in %v, want at most one per %v", tc.name, got, tc.timeout, servoTick)
+		}
+		if tc.timeout > 0 && act.detached == 0 {
+			t.Errorf("%v: servo not detached after the sweep", tc.name)
+		}
+	}
+}
+
+func TestSweepStop(t *testing.T) {
+	n, act := newTestServo(t, false)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- n.Sweep(context.Background(), 80, 100, 1000)
+	}()
+	time.Sleep(5 * servoTick)
+	if err := n.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != ErrServoPreempted {
+			t.Errorf("Sweep = %v, want %v", err, ErrServoPreempted)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Sweep still running a second after Stop")
+	}
+	// The sweep went back and forth between both ends.
+	got := act.Angles()
+	if len(got) < 2 || got[0] != 80 || got[1] != 100 {
+		t.Errorf("angles = %v, want 80 and 100 alternating", got)
+	}
+}
@@ -0,0 +1,475 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// ServoConfig is the wiring, travel and calibration of a named servo.
+type ServoConfig struct {
+	Name        string           `yaml:"name"`
+	Pin         string           `yaml:"pin"`   // BCMxx pin for pi-blaster, channel for sysfs PWM and PCA9685.
+	Min         int              `yaml:"min"`   // Lowest angle in degrees.
+	Max         int              `yaml:"max"`   // Highest angle in degrees.
+	Step        int              `yaml:"step"`  // Degrees moved by one step.
+	Speed       int              `yaml:"speed"` // Degrees per second of SetAngle moves. 0 jumps to the angle.
+	Hold        bool             `yaml:"hold"`  // Keep the pulse on after moves for holding torque.
+	Calibration ServoCalibration `yaml:",inline"`
+}
+
+// validate checks the limits and calibration of the servo. A zero pulse
+// range is replaced with the default calibration.
+func (c *ServoConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("servo needs a name")
+	}
+	if c.Min < 0 || c.Max > 180 || c.Min > c.Max {
+		return fmt.Errorf("servo %v limits need to be within 0 to 180, got %v to %v", c.Name, c.Min, c.Max)
+	}
+	if c.Step <= 0 {
+		return fmt.Errorf("servo %v step needs to be positive, got %v", c.Name, c.Step)
+	}
+	if c.Speed < 0 {
+		return fmt.Errorf("servo %v speed needs to be 0 or more, got %v", c.Name, c.Speed)
+	}
+	if c.Calibration.MinPulse == 0 && c.Calibration.MaxPulse == 0 {
+		c.Calibration.MinPulse = DefaultServoCalibration.MinPulse
+		c.Calibration.MaxPulse = DefaultServoCalibration.MaxPulse
+	}
+	if err := c.Calibration.Validate(); err != nil {
+		return fmt.Errorf("servo %v: %v", c.Name, err)
+	}
+	return nil
+}
+
+// LoadServoConfigs reads a YAML list of servo configs eg.
+//   - name: tilt
+//     pin: 23
+//     min: 30
+//     max: 150
+//     step: 30
+//     speed: 90
+//     hold: false
+//     min_pulse: 600
+//     max_pulse: 2400
+//     trim: -20
+//     invert: false
+func LoadServoConfigs(path string) ([]ServoConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []ServoConfig
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("invalid servo config %v: %v", path, err)
+	}
+	return cfgs, nil
+}
+
+// ParseServoConfigs parses a comma separated list of name:pin:min:max:step
+// eg. "tilt:23:0:180:30,pan:24:20:160:15".
+func ParseServoConfigs(s string) ([]ServoConfig, error) {
+	var cfgs []ServoConfig
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		f := strings.Split(item, ":")
+		if len(f) != 5 {
+			return nil, fmt.Errorf("servo needs name:pin:min:max:step, got %q", item)
+		}
+		var v [3]int
+		for i := range v {
+			n, err := strconv.Atoi(f[i+2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid servo %q: %v", item, err)
+			}
+			v[i] = n
+		}
+		cfgs = append(cfgs, ServoConfig{
+			Name: f[0],
+			Pin:  f[1],
+			Min:  v[0],
+			Max:  v[1],
+			Step: v[2],
+		})
+	}
+	return cfgs, nil
+}
+
+// ServoState is the position of a named servo as reported to clients.
+type ServoState struct {
+	Name        string
+	Angle       int
+	Min         int
+	Max         int
+	Step        int
+	Hold        bool
+	Calibrating bool
+}
+
+// NamedServo is a servo limited to its configured travel which remembers
+// its angle.
+type NamedServo struct {
+	act AngleActuator
+
+	mu          sync.Mutex
+	cfg         ServoConfig
+	angle       int    // Last angle sent to the servo.
+	seq         uint64 // Bumped by every move so newer moves preempt older ones.
+	calibrating bool   // Raw pulses only, see StartCalibration.
+}
+
+// Name returns the name of the servo.
+func (n *NamedServo) Name() string {
+	return n.cfg.Name
+}
+
+// Angle returns the last angle the servo was moved to.
+func (n *NamedServo) Angle() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.angle
+}
+
+// State returns the angle and limits of the servo.
+func (n *NamedServo) State() ServoState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return ServoState{
+		Name:        n.cfg.Name,
+		Angle:       n.angle,
+		Min:         n.cfg.Min,
+		Max:         n.cfg.Max,
+		Step:        n.cfg.Step,
+		Hold:        n.cfg.Hold,
+		Calibrating: n.calibrating,
+	}
+}
+
+// Config returns the limits and calibration of the servo.
+func (n *NamedServo) Config() ServoConfig {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cfg
+}
+
+// SetStep sets the degrees moved by one step.
+func (n *NamedServo) SetStep(deg int) error {
+	if deg <= 0 {
+		return fmt.Errorf("servo step needs to be positive, got %v", deg)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cfg.Step = deg
+	return nil
+}
+
+// SetAngle moves the servo to angle within its limits at the configured
+// speed.
+func (n *NamedServo) SetAngle(angle int) error {
+	n.mu.Lock()
+	speed := n.cfg.Speed
+	n.mu.Unlock()
+	return n.MoveTo(angle, speed)
+}
+
+// StepBy moves the servo by steps steps (negative for down) and returns the
+// new angle. The move stops at the servo limits.
+func (n *NamedServo) StepBy(steps int) (int, error) {
+	n.mu.Lock()
+	angle := n.angle + steps*n.cfg.Step
+	if angle < n.cfg.Min {
+		angle = n.cfg.Min
+	}
+	if angle > n.cfg.Max {
+		angle = n.cfg.Max
+	}
+	speed := n.cfg.Speed
+	n.mu.Unlock()
+
+	err := n.MoveTo(angle, speed)
+	return n.Angle(), err
+}
+
+// Center moves the servo to the middle of its travel.
+func (n *NamedServo) Center() error {
+	n.mu.Lock()
+	angle, speed := (n.cfg.Min+n.cfg.Max)/2, n.cfg.Speed
+	n.mu.Unlock()
+	return n.MoveTo(angle, speed)
+}
+
+// Lock stops the servo from moving.
+func (n *NamedServo) Lock(lock bool) error {
+	return n.act.Lock(lock)
+}
+
+// StartCalibration puts the servo in calibration mode. Angle moves are
+// refused and SetPulse drives raw pulses until the calibration is finished
+// with Calibrate or CancelCalibration.
+func (n *NamedServo) StartCalibration() error {
+	if _, ok := n.act.(CalibratedActuator); !ok {
+		return fmt.Errorf("servo %v does not support calibration", n.cfg.Name)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++ // Preempt moves and sweeps.
+	n.calibrating = true
+	glog.Infof("Calibrating servo %v", n.cfg.Name)
+	return nil
+}
+
+// SetPulse drives a raw pulse in microsecs to find the pulse range of the
+// servo. It only works in calibration mode.
+func (n *NamedServo) SetPulse(us uint32) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.calibrating {
+		return fmt.Errorf("servo %v not calibrating", n.cfg.Name)
+	}
+	return n.act.(CalibratedActuator).SetDutyCycle(us)
+}
+
+// Calibrate sets the limits, step and calibration of cfg (the name and pin
+// are kept), ends calibration mode and moves the servo within its new limits.
+func (n *NamedServo) Calibrate(cfg ServoConfig) error {
+	n.mu.Lock()
+	cfg.Name, cfg.Pin = n.cfg.Name, n.cfg.Pin
+	if err := cfg.validate(); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	if err := n.setCalibration(cfg.Calibration); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	n.cfg = cfg
+	n.calibrating = false
+	glog.Infof("Calibrated servo %+v", cfg)
+
+	angle := n.angle
+	if angle < cfg.Min {
+		angle = cfg.Min
+	}
+	if angle > cfg.Max {
+		angle = cfg.Max
+	}
+	n.mu.Unlock()
+	return n.MoveTo(angle, 0)
+}
+
+// CancelCalibration ends calibration mode and moves the servo back to its
+// angle.
+func (n *NamedServo) CancelCalibration() error {
+	n.mu.Lock()
+	if !n.calibrating {
+		n.mu.Unlock()
+		return nil
+	}
+	n.calibrating = false
+	angle := n.angle
+	n.mu.Unlock()
+	return n.MoveTo(angle, 0)
+}
+
+// setCalibration applies c to the actuator if it supports calibration.
+func (n *NamedServo) setCalibration(c ServoCalibration) error {
+	if ca, ok := n.act.(CalibratedActuator); ok {
+		return ca.SetCalibration(c)
+	}
+	return nil
+}
+
+// checkAngle returns an error if the servo cannot move to angle. Caller
+// must hold n.mu.
+func (n *NamedServo) checkAngle(angle int) error {
+	if n.calibrating {
+		return fmt.Errorf("servo %v calibrating", n.cfg.Name)
+	}
+	if angle < n.cfg.Min || angle > n.cfg.Max {
+		return fmt.Errorf("servo %v angle needs to be %v to %v, got %v", n.cfg.Name, n.cfg.Min, n.cfg.Max, angle)
+	}
+	return nil
+}
+
+// Servos is a registry of named servos. The first servo added is the
+// default one.
+type Servos struct {
+	mu      sync.Mutex
+	servos  map[string]*NamedServo
+	names   []string // In the order added.
+	panTilt *PanTilt
+	file    string // Servo configs are saved here, see SetConfigFile.
+}
+
+// NewServos returns an empty servo registry.
+func NewServos() *Servos {
+	return &Servos{
+		servos: make(map[string]*NamedServo),
+	}
+}
+
+// Add registers act under cfg.Name.
+func (s *Servos) Add(cfg ServoConfig, act AngleActuator) (*NamedServo, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.servos[cfg.Name]; ok {
+		return nil, fmt.Errorf("servo %v already exists", cfg.Name)
+	}
+	n := &NamedServo{
+		act:   act,
+		cfg:   cfg,
+		angle: (cfg.Min + cfg.Max) / 2,
+	}
+	if err := n.setCalibration(cfg.Calibration); err != nil {
+		return nil, err
+	}
+	s.servos[cfg.Name] = n
+	s.names = append(s.names, cfg.Name)
+	glog.V(1).Infof("Added servo %+v", cfg)
+	return n, nil
+}
+
+// Get returns the servo called name or the default servo if name is empty.
+func (s *Servos) Get(name string) (*NamedServo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "" {
+		if len(s.names) == 0 {
+			return nil, errors.New("servo not initialized")
+		}
+		name = s.names[0]
+	}
+	n, ok := s.servos[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown servo %q", name)
+	}
+	return n, nil
+}
+
+// All returns the servos in the order they were added.
+func (s *Servos) All() []*NamedServo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make([]*NamedServo, len(s.names))
+	for i, name := range s.names {
+		all[i] = s.servos[name]
+	}
+	return all
+}
+
+// Lock locks or unlocks all servos.
+func (s *Servos) Lock(lock bool) error {
+	var err error
+	for _, n := range s.All() {
+		if e := n.Lock(lock); e != nil {
+			glog.Errorf("Failed to lock servo %v: %v", n.Name(), e)
+			err = e
+		}
+	}
+	return err
+}
+
+// SetConfigFile sets the file Save writes the servo configs to.
+func (s *Servos) SetConfigFile(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file = path
+}
+
+// Save writes the configs of all servos to the config file so calibrations
+// survive a restart.
+func (s *Servos) Save() error {
+	s.mu.Lock()
+	file := s.file
+	s.mu.Unlock()
+	if file == "" {
+		return errors.New("no servo config file")
+	}
+
+	var cfgs []ServoConfig
+	for _, n := range s.All() {
+		cfgs = append(cfgs, n.Config())
+	}
+	data, err := yaml.Marshal(cfgs)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Saving servo configs to %v", file)
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// SetPanTilt makes the servos called pan and tilt a pan/tilt head.
+func (s *Servos) SetPanTilt(pan, tilt string) error {
+	p, err := s.Get(pan)
+	if err != nil {
+		return err
+	}
+	t, err := s.Get(tilt)
+	if err != nil {
+		return err
+	}
+	if p == t {
+		return errors.New("pan and tilt need to be different servos")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panTilt = &PanTilt{Pan: p, Tilt: t}
+	return nil
+}
+
+// PanTilt returns the pan/tilt head or nil if there is none.
+func (s *Servos) PanTilt() *PanTilt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.panTilt
+}
+
+// PanTilt is a camera head moved by two servos.
+type PanTilt struct {
+	Pan  *NamedServo
+	Tilt *NamedServo
+}
+
+// Look moves the head to the pan and tilt angles. Both servos move at the
+// same time.
+func (p *PanTilt) Look(pan, tilt int) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Pan.SetAngle(pan)
+	}()
+	err := p.Tilt.SetAngle(tilt)
+	if e := <-errCh; e != nil {
+		err = e
+	}
+	return err
+}
+
+// Center moves the head to the middle of both servos travel.
+func (p *PanTilt) Center() error {
+	pan, tilt := p.Pan.State(), p.Tilt.State()
+	return p.Look((pan.Min+pan.Max)/2, (tilt.Min+tilt.Max)/2)
+}
+
+// Angles returns the pan and tilt angles.
+func (p *PanTilt) Angles() (int, int) {
+	return p.Pan.Angle(), p.Tilt.Angle()
+}
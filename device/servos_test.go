@@ -0,0 +1,382 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeActuator records the angles a servo is moved to.
+type fakeActuator struct {
+	mu       sync.Mutex
+	angles   []int
+	detached int // Detach calls.
+	locked   bool
+}
+
+func (a *fakeActuator) SetAngle(angle int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errors.New("servo locked")
+	}
+	a.angles = append(a.angles, angle)
+	return nil
+}
+
+func (a *fakeActuator) Detach() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.detached++
+	return nil
+}
+
+func (a *fakeActuator) Lock(lock bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.locked = lock
+	return nil
+}
+
+func (a *fakeActuator) Angles() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]int(nil), a.angles...)
+}
+
+func (a *fakeActuator) Last() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.angles) == 0 {
+		return -1
+	}
+	return a.angles[len(a.angles)-1]
+}
+
+func TestParseServoConfigs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []ServoConfig
+		ok   bool
+	}{
+		{"", nil, true},
+		{"tilt:23:0:180:30", []ServoConfig{{Name: "tilt", Pin: "23", Min: 0, Max: 180, Step: 30}}, true},
+		{" tilt:23:0:180:30 , pan:24:20:160:15,", []ServoConfig{
+			{Name: "tilt", Pin: "23", Min: 0, Max: 180, Step: 30},
+			{Name: "pan", Pin: "24", Min: 20, Max: 160, Step: 15},
+		}, true},
+		{"tilt:23:0:180", nil, false},
+		{"tilt:23:0:180:30:1", nil, false},
+		{"tilt:23:zero:180:30", nil, false},
+	}
+	for _, tc := range tests {
+		got, err := ParseServoConfigs(tc.in)
+		if (err == nil) != tc.ok {
+			t.Errorf("ParseServoConfigs(%q) = %v, want ok %v", tc.in, err, tc.ok)
+			continue
+		}
+		if tc.ok && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseServoConfigs(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestServosAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ServoConfig
+	}{
+		{"no name", ServoConfig{Min: 0, Max: 180, Step: 10}},
+		{"below 0", ServoConfig{Name: "s", Min: -1, Max: 180, Step: 10}},
+		{"above 180", ServoConfig{Name: "s", Min: 0, Max: 181, Step: 10}},
+		{"min above max", ServoConfig{Name: "s", Min: 100, Max: 90, Step: 10}},
+		{"no step", ServoConfig{Name: "s", Min: 0, Max: 180}},
+	}
+	for _, tc := range tests {
+		if _, err := NewServos().Add(tc.cfg, &fakeActuator{}); err == nil {
+			t.Errorf("%v: Add(%+v) succeeded", tc.name, tc.cfg)
+		}
+	}
+
+	s := NewServos()
+	if _, err := s.Get(""); err == nil {
+		t.Error("Get of the default servo succeeded without servos")
+	}
+	tilt, err := s.Add(ServoConfig{Name: "tilt", Min: 20, Max: 160, Step: 10}, &fakeActuator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(ServoConfig{Name: "pan", Min: 0, Max: 180, Step: 10}, &fakeActuator{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(ServoConfig{Name: "tilt", Min: 0, Max: 180, Step: 10}, &fakeActuator{}); err == nil {
+		t.Error("Add of a duplicate name succeeded")
+	}
+	if n, err := s.Get(""); err != nil || n != tilt {
+		t.Errorf("Get default = %v, %v, want the first servo", n, err)
+	}
+	if _, err := s.Get("zoom"); err == nil {
+		t.Error("Get of an unknown servo succeeded")
+	}
+	if got := tilt.Angle(); got != 90 {
+		t.Errorf("angle after Add = %v, want the middle 90", got)
+	}
+	var names []string
+	for _, n := range s.All() {
+		names = append(names, n.Name())
+	}
+	if want := []string{"tilt", "pan"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("All = %v, want %v", names, want)
+	}
+}
+
+func TestStepBy(t *testing.T) {
+	tests := []struct {
+		start, steps int
+		want         int
+	}{
+		{90, 1, 100},
+		{90, -2, 70},
+		{150, 2, 160},
+		{30, -3, 20},
+	}
+	for _, tc := range tests {
+		act := &fakeActuator{}
+		n, err := NewServos().Add(ServoConfig{Name: "tilt", Min: 20, Max: 160, Step: 10}, act)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.angle = tc.start
+		got, err := n.StepBy(tc.steps)
+		if err != nil || got != tc.want {
+			t.Errorf("StepBy(%v) from %v = %v, %v, want %v", tc.steps, tc.start, got, err, tc.want)
+		}
+		if last := act.Last(); last != tc.want {
+			t.Errorf("StepBy(%v) from %v sent %v, want %v", tc.steps, tc.start, last, tc.want)
+		}
+	}
+}
+
+func TestServosLock(t *testing.T) {
+	s := NewServos()
+	act := &fakeActuator{}
+	n, err := s.Add(ServoConfig{Name: "tilt", Min: 0, Max: 180, Step: 10}, act)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Lock(true)
+	if err := n.SetAngle(10); err == nil {
+		t.Error("SetAngle of a locked servo succeeded")
+	}
+	s.Lock(false)
+	if err := n.SetAngle(10); err != nil || act.Last() != 10 {
+		t.Errorf("SetAngle after unlock = %v, sent %v", err, act.Last())
+	}
+	if err := n.SetAngle(181); err == nil {
+		t.Error("SetAngle beyond the limits succeeded")
+	}
+}
+
+func TestPanTilt(t *testing.T) {
+	s := NewServos()
+	pan, tilt := &fakeActuator{}, &fakeActuator{}
+	s.Add(ServoConfig{Name: "pan", Min: 0, Max: 180, Step: 10}, pan)
+	s.Add(ServoConfig{Name: "tilt", Min: 40, Max: 120, Step: 10}, tilt)
+
+	if s.PanTilt() != nil {
+		t.Error("PanTilt before SetPanTilt is not nil")
+	}
+	for _, names := range [][2]string{{"pan", "pan"}, {"pan", "zoom"}, {"zoom", "tilt"}} {
+		if err := s.SetPanTilt(names[0], names[1]); err == nil {
+			t.Errorf("SetPanTilt(%q, %q) succeeded", names[0], names[1])
+		}
+	}
+	if err := s.SetPanTilt("pan", "tilt"); err != nil {
+		t.Fatal(err)
+	}
+	p := s.PanTilt()
+	if err := p.Look(30, 60); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := p.Angles(); a != 30 || b != 60 {
+		t.Errorf("Angles after Look = %v, %v, want 30, 60", a, b)
+	}
+	if err := p.Center(); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := p.Angles(); a != 90 || b != 80 {
+		t.Errorf("Angles after Center = %v, %v, want 90, 80", a, b)
+	}
+}
+
+func TestServoCalibration(t *testing.T) {
+	tests := []struct {
+		cal   ServoCalibration
+		angle int
+		want  uint32
+	}{
+		{DefaultServoCalibration, 0, 500},
+		{DefaultServoCalibration, 90, 1250},
+		{DefaultServoCalibration, 180, 2000},
+		{ServoCalibration{MinPulse: 600, MaxPulse: 2400}, 90, 1500},
+		{ServoCalibration{MinPulse: 600, MaxPulse: 2400, Trim: -20}, 90, 1480},
+		{ServoCalibration{MinPulse: 600, MaxPulse: 2400, Invert: true}, 0, 2400},
+		{ServoCalibration{MinPulse: 600, MaxPulse: 2400, Invert: true}, 45, 1950},
+	}
+	for _, tc := range tests {
+		if got := tc.cal.Pulse(tc.angle); got != tc.want {
+			t.Errorf("%+v Pulse(%v) = %v, want %v", tc.cal, tc.angle, got, tc.want)
+		}
+	}
+
+	invalid := []ServoCalibration{
+		{},
+		{MinPulse: 2000, MaxPulse: 500},
+		{MinPulse: 500, MaxPulse: 500},
+		{MinPulse: 500, MaxPulse: 2000, Trim: -500},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v Validate succeeded", c)
+		}
+	}
+}
+
+func TestLoadServoConfigs(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []ServoConfig
+		ok   bool
+	}{
+		{"full", "- name: tilt\n  pin: \"23\"\n  min: 30\n  max: 150\n  step: 10\n  min_pulse: 600\n  max_pulse: 2400\n  trim: -20\n  invert: true\n",
+			[]ServoConfig{{Name: "tilt", Pin: "23", Min: 30, Max: 150, Step: 10,
+				Calibration: ServoCalibration{MinPulse: 600, MaxPulse: 2400, Trim: -20, Invert: true}}}, true},
+		{"unknown field", "- name: tilt\n  pulse: 600\n", nil, false},
+		{"not a list", "name: tilt\n", nil, false},
+	}
+	for _, tc := range tests {
+		file := filepath.Join(t.TempDir(), "servos.yaml")
+		if err := os.WriteFile(file, []byte(tc.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadServoConfigs(file)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: LoadServoConfigs = %v, want ok %v", tc.name, err, tc.ok)
+			continue
+		}
+		if tc.ok && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: LoadServoConfigs = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+	if _, err := LoadServoConfigs(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadServoConfigs of a missing file succeeded")
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	s := NewServos()
+	out := &fakeOutput{}
+	n, err := s.Add(ServoConfig{Name: "tilt", Pin: "23", Min: 0, Max: 180, Step: 10}, NewServo(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := s.Add(ServoConfig{Name: "pan", Min: 0, Max: 180, Step: 10}, &fakeActuator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.StartCalibration(); err == nil {
+		t.Error("StartCalibration of a servo without pulses succeeded")
+	}
+
+	if err := n.SetPulse(1000); err == nil {
+		t.Error("SetPulse before StartCalibration succeeded")
+	}
+	if err := n.StartCalibration(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetAngle(10); err == nil {
+		t.Error("SetAngle while calibrating succeeded")
+	}
+	if err := n.SetPulse(1000); err != nil {
+		t.Fatal(err)
+	}
+	if p := out.Pulses(); p[len(p)-1] != 1000 {
+		t.Errorf("pulses = %v, want 1000 last", p)
+	}
+
+	bad := ServoConfig{Min: 0, Max: 180, Step: 10, Calibration: ServoCalibration{MinPulse: 2400, MaxPulse: 600}}
+	if err := n.Calibrate(bad); err == nil {
+		t.Error("Calibrate with an invalid pulse range succeeded")
+	}
+	if !n.State().Calibrating {
+		t.Error("not calibrating after a failed Calibrate")
+	}
+
+	cfg := ServoConfig{Name: "other", Pin: "1", Min: 100, Max: 150, Step: 5,
+		Calibration: ServoCalibration{MinPulse: 600, MaxPulse: 2400}}
+	if err := n.Calibrate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	got := n.Config()
+	if got.Name != "tilt" || got.Pin != "23" || got.Min != 100 || got.Max != 150 || got.Calibration != cfg.Calibration {
+		t.Errorf("Config after Calibrate = %+v", got)
+	}
+	if n.State().Calibrating {
+		t.Error("still calibrating after Calibrate")
+	}
+	// The servo moves within its new limits with the new pulse range.
+	if a := n.Angle(); a != 100 {
+		t.Errorf("angle after Calibrate = %v, want 100", a)
+	}
+	if p := out.Pulses(); !containsPulse(p, 1600) {
+		t.Errorf("pulses after Calibrate = %v, want 1600 for 100'", p)
+	}
+
+	if err := n.StartCalibration(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.CancelCalibration(); err != nil || n.State().Calibrating {
+		t.Errorf("CancelCalibration = %v, calibrating %v", err, n.State().Calibrating)
+	}
+}
+
+func containsPulse(pulses []uint32, us uint32) bool {
+	for _, p := range pulses {
+		if p == us {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServosSave(t *testing.T) {
+	s := NewServos()
+	if err := s.Save(); err == nil {
+		t.Error("Save without a config file succeeded")
+	}
+	cfgs := []ServoConfig{
+		{Name: "tilt", Pin: "23", Min: 30, Max: 150, Step: 10, Calibration: ServoCalibration{MinPulse: 600, MaxPulse: 2400, Trim: 5}},
+		{Name: "pan", Pin: "24", Min: 0, Max: 180, Step: 15, Calibration: DefaultServoCalibration},
+	}
+	for _, c := range cfgs {
+		if _, err := s.Add(c, NewServo(&fakeOutput{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(t.TempDir(), "servos.yaml")
+	s.SetConfigFile(file)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadServoConfigs(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cfgs) {
+		t.Errorf("saved configs = %+v, want %+v", got, cfgs)
+	}
+}
@@ -0,0 +1,123 @@
+package device
+
+import (
+	"fmt"
+
+	"gobot.io/x/gobot/drivers/i2c"
+)
+
+// SH1106 control bytes.
+const (
+	sh1106Command = 0x00
+	sh1106Data    = 0x40
+)
+
+// sh1106RAMWidth is the number of columns of SH1106 display RAM.
+const sh1106RAMWidth = 132
+
+// SH1106 drives an SH1106 OLED over I2C. It has 132 columns of RAM with the
+// panel in the middle and only supports page addressing, which is why
+// SSD1306 drivers render it garbled.
+type SH1106 struct {
+	conn     i2c.Connection
+	buf      *MonoBuffer
+	offset   int  // RAM column of the first panel column.
+	contrast byte // 0 to 255.
+}
+
+// NewSH1106 connects to an SH1106 at i2cAddress and initializes a w x h
+// panel, usually 128x64.
+func NewSH1106(c i2c.Connector, bus int, i2cAddress int, w, h int) (*SH1106, error) {
+	if w <= 0 || w > sh1106RAMWidth || h <= 0 || h > 64 {
+		return nil, fmt.Errorf("SH1106 size needs to be up to %vx64, got %vx%v", sh1106RAMWidth, w, h)
+	}
+	conn, err := c.GetConnection(i2cAddress, bus)
+	if err != nil {
+		return nil, err
+	}
+	d := &SH1106{
+		conn:     conn,
+		buf:      NewMonoBuffer(w, h),
+		offset:   (sh1106RAMWidth - w) / 2,
+		contrast: 0x80,
+	}
+	if err := d.init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize SH1106: %v", err)
+	}
+	return d, nil
+}
+
+// init sends the power up sequence from the datasheet.
+func (d *SH1106) init() error {
+	// Display off while setting the panel height and contrast.
+	if err := d.command(0xae, 0xa8, byte(d.buf.Height-1), 0x81, d.contrast); err != nil {
+		return err
+	}
+	return d.command(
+		0xd5, 0x80, // Clock divide ratio.
+		0xd3, 0x00, // Display offset.
+		0x40,       // Start line 0.
+		0xad, 0x8b, // DC-DC converter on.
+		0xa1,       // Segments remapped, column 0 on the left.
+		0xc8,       // COM scan from the bottom, row 0 on the top.
+		0xda, 0x12, // Alternative COM pins.
+		0xd9, 0x22, // Pre-charge period.
+		0xdb, 0x35, // VCOM deselect level.
+		0xa4, // Show the RAM.
+		0xa6, // Not inverted.
+		0xaf, // Display on.
+	)
+}
+
+func (d *SH1106) command(cmds ...byte) error {
+	_, err := d.conn.Write(append([]byte{sh1106Command}, cmds...))
+	return err
+}
+
+func (d *SH1106) Size() (int, int) {
+	return d.buf.Size()
+}
+
+func (d *SH1106) Clear() {
+	d.buf.Clear()
+}
+
+func (d *SH1106) Set(x, y, c int) {
+	d.buf.Set(x, y, c)
+}
+
+// Display sends the buffer to the display a page at a time.
+func (d *SH1106) Display() error {
+	for p := 0; p < d.buf.Pages(); p++ {
+		if err := d.command(0xb0|byte(p), byte(d.offset&0x0f), 0x10|byte(d.offset>>4)); err != nil {
+			return err
+		}
+		if _, err := d.conn.Write(append([]byte{sh1106Data}, d.buf.Page(p)...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset reinitializes the display and clears it.
+func (d *SH1106) Reset() error {
+	if err := d.init(); err != nil {
+		return err
+	}
+	d.buf.Clear()
+	return d.Display()
+}
+
+// SetContrast sets the brightness from 0 to 255.
+func (d *SH1106) SetContrast(contrast byte) error {
+	d.contrast = contrast
+	return d.command(0x81, contrast)
+}
+
+func (d *SH1106) On() error {
+	return d.command(0xaf)
+}
+
+func (d *SH1106) Off() error {
+	return d.command(0xae)
+}
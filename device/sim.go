@@ -0,0 +1,387 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// SimRover is a kinematic model of a differential drive rover. The motor
+// pins it hands out drive the model instead of real GPIO.
+type SimRover struct {
+	RightFwd *SimPin
+	RightBwd *SimPin
+	LeftFwd  *SimPin
+	LeftBwd  *SimPin
+
+	speed float64 // Wheel speed at full power in cm/s.
+	track float64 // Distance between the wheels in cm.
+
+	mu   sync.Mutex
+	pose Pose
+	last time.Time  // Time the pose was last integrated.
+	vl   float64    // Current left wheel velocity in cm/s.
+	vr   float64    // Current right wheel velocity in cm/s.
+	dist [2]float64 // Distance travelled by the left and right wheel in cm.
+}
+
+// NewSimRover returns a simulated rover whose wheels move at speed cm/s
+// at full power and are track cm apart.
+func NewSimRover(speed, track float64) *SimRover {
+	s := &SimRover{
+		speed: speed,
+		track: track,
+		last:  time.Now(),
+	}
+	s.RightFwd = &SimPin{name: "right_fwd", rover: s}
+	s.RightBwd = &SimPin{name: "right_bwd", rover: s}
+	s.LeftFwd = &SimPin{name: "left_fwd", rover: s}
+	s.LeftBwd = &SimPin{name: "left_bwd", rover: s}
+	return s
+}
+
+// Pose returns the actual pose of the simulated rover.
+func (s *SimRover) Pose() Pose {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.integrate(time.Now())
+	return s.pose
+}
+
+// Reset moves the simulated rover back to the origin.
+func (s *SimRover) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pose = Pose{}
+	s.last = time.Now()
+}
+
+// pinChanged is called by the pins after a write to update wheel velocities.
+func (s *SimRover) pinChanged() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.integrate(time.Now())
+	s.vl = s.speed * (s.LeftFwd.Duty() - s.LeftBwd.Duty())
+	s.vr = s.speed * (s.RightFwd.Duty() - s.RightBwd.Duty())
+	glog.V(2).Infof("Sim rover wheels left:%v right:%v pose:%+v", s.vl, s.vr, s.pose)
+}
+
+// integrate advances the pose to time t using the current wheel velocities.
+func (s *SimRover) integrate(t time.Time) {
+	dt := t.Sub(s.last).Seconds()
+	s.last = t
+	if dt <= 0 {
+		return
+	}
+
+	s.dist[0] += math.Abs(s.vl) * dt
+	s.dist[1] += math.Abs(s.vr) * dt
+
+	v := (s.vl + s.vr) / 2
+	w := (s.vr - s.vl) / s.track
+	th := s.pose.Heading
+
+	if math.Abs(w) < 1e-9 {
+		s.pose.X += v * math.Cos(th) * dt
+		s.pose.Y += v * math.Sin(th) * dt
+		return
+	}
+	s.pose.X += v / w * (math.Sin(th+w*dt) - math.Sin(th))
+	s.pose.Y -= v / w * (math.Cos(th+w*dt) - math.Cos(th))
+	s.pose.Heading = math.Remainder(th+w*dt, 2*math.Pi)
+}
+
+// Encoders returns simulated left and right wheel encoders with
+// ticksPerRev edges per revolution of a wheel with diameter cm.
+func (s *SimRover) Encoders(ticksPerRev, diameter float64) (Encoder, Encoder) {
+	return &simEncoder{s, 0, ticksPerRev, diameter}, &simEncoder{s, 1, ticksPerRev, diameter}
+}
+
+// simEncoder counts the edges a real encoder would see on a simulated wheel.
+type simEncoder struct {
+	rover       *SimRover
+	wheel       int
+	ticksPerRev float64
+	diameter    float64
+}
+
+func (e *simEncoder) Count() uint64 {
+	e.rover.mu.Lock()
+	defer e.rover.mu.Unlock()
+	e.rover.integrate(time.Now())
+	return uint64(e.rover.dist[e.wheel] / (math.Pi * e.diameter) * e.ticksPerRev)
+}
+
+// SimPin is a virtual GPIO pin with PWM support.
+type SimPin struct {
+	name  string
+	rover *SimRover
+	mu    sync.Mutex
+	level byte // PWM level 0-255.
+}
+
+// NewSimPin returns a standalone virtual pin.
+func NewSimPin(name string) *SimPin {
+	return &SimPin{name: name}
+}
+
+// DigitalWrite switches the pin on (1) or off (0).
+func (p *SimPin) DigitalWrite(level byte) error {
+	if level > 1 {
+		return fmt.Errorf("invalid level %v for pin %v", level, p.name)
+	}
+	return p.PwmWrite(level * 255)
+}
+
+// PwmWrite sets the duty cycle of the pin from 0 to 255.
+func (p *SimPin) PwmWrite(level byte) error {
+	p.mu.Lock()
+	p.level = level
+	p.mu.Unlock()
+
+	glog.V(3).Infof("Sim pin %v -> %v", p.name, level)
+	if p.rover != nil {
+		p.rover.pinChanged()
+	}
+	return nil
+}
+
+// Duty returns the current duty cycle of the pin from 0 to 1.
+func (p *SimPin) Duty() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return float64(p.level) / 255
+}
+
+// SimServo is a virtual servo.
+type SimServo struct {
+	mu    sync.Mutex
+	angle int
+	pulse uint32 // Last pulse in microsecs.
+	cal   ServoCalibration
+	lock  bool
+}
+
+func NewSimServo() *SimServo {
+	return &SimServo{
+		angle: 90,
+		cal:   DefaultServoCalibration,
+	}
+}
+
+func (p *SimServo) SetCalibration(c ServoCalibration) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.cal = c
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *SimServo) Calibration() ServoCalibration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cal
+}
+
+// SetDutyCycle drives the virtual servo with a raw pulse in microsecs.
+func (p *SimServo) SetDutyCycle(us uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lock {
+		return errors.New("servo locked")
+	}
+	glog.V(2).Infof("Sim servo pulse:%v", us)
+	p.pulse = us
+	return nil
+}
+
+// Detach turns off the pulse of the virtual servo.
+func (p *SimServo) Detach() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pulse = 0
+	return nil
+}
+
+// Pulse returns the last pulse width of the virtual servo in microsecs.
+func (p *SimServo) Pulse() uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pulse
+}
+
+func (p *SimServo) Lock(lock bool) error {
+	p.mu.Lock()
+	p.lock = lock
+	p.mu.Unlock()
+	return nil
+}
+
+// SetAngle moves the virtual servo to angle.
+func (p *SimServo) SetAngle(angle int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lock {
+		return errors.New("servo locked")
+	}
+	if angle < 0 || angle > 180 {
+		return fmt.Errorf("Angle needs to be 0 to 180, got %v", angle)
+	}
+	glog.V(2).Infof("Sim servo angle:%v", angle)
+	p.angle = angle
+	p.pulse = p.cal.Pulse(angle)
+	return nil
+}
+
+// Angle returns the current angle of the virtual servo.
+func (p *SimServo) Angle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.angle
+}
+
+// SimBattery is a virtual battery.
+type SimBattery struct {
+	mu      sync.Mutex
+	percent float64
+}
+
+func NewSimBattery(percent float64) *SimBattery {
+	return &SimBattery{percent: percent}
+}
+
+// Battery returns the charge of the virtual battery.
+func (b *SimBattery) Battery() (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.percent, nil
+}
+
+// Set sets the charge of the virtual battery.
+func (b *SimBattery) Set(percent float64) {
+	b.mu.Lock()
+	b.percent = percent
+	b.mu.Unlock()
+}
+
+// SimLight is a virtual light.
+type SimLight struct {
+	mu sync.Mutex
+	on bool
+}
+
+func NewSimLight() *SimLight {
+	return &SimLight{}
+}
+
+func (l *SimLight) On() error {
+	l.set(true)
+	return nil
+}
+
+func (l *SimLight) Off() error {
+	l.set(false)
+	return nil
+}
+
+func (l *SimLight) set(on bool) {
+	l.mu.Lock()
+	l.on = on
+	l.mu.Unlock()
+	glog.V(2).Infof("Sim light on:%v", on)
+}
+
+// State returns true if the light is on.
+func (l *SimLight) State() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.on
+}
+
+// SimDisplay is a virtual monochrome display backed by a framebuffer.
+type SimDisplay struct {
+	mu     sync.Mutex
+	width  int
+	height int
+	buf    []byte // Pixels being drawn.
+	shown  []byte // Pixels last sent with Display.
+}
+
+func NewSimDisplay(w, h int) *SimDisplay {
+	return &SimDisplay{
+		width:  w,
+		height: h,
+		buf:    make([]byte, w*h),
+		shown:  make([]byte, w*h),
+	}
+}
+
+func (d *SimDisplay) Size() (int, int) {
+	return d.width, d.height
+}
+
+func (d *SimDisplay) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.buf {
+		d.buf[i] = 0
+	}
+}
+
+func (d *SimDisplay) Set(x, y, c int) {
+	if x < 0 || x >= d.width || y < 0 || y >= d.height {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buf[y*d.width+x] = byte(c)
+}
+
+// Display copies the drawing buffer to the visible framebuffer.
+func (d *SimDisplay) Display() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copy(d.shown, d.buf)
+	return nil
+}
+
+func (d *SimDisplay) Reset() error {
+	d.Clear()
+	return d.Display()
+}
+
+func (d *SimDisplay) Off() error {
+	return d.Reset()
+}
+
+// Image returns a snapshot of the visible framebuffer.
+func (d *SimDisplay) Image() image.Image {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	img := image.NewGray(image.Rect(0, 0, d.width, d.height))
+	for i, px := range d.shown {
+		if px != 0 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img
+}
+
+var (
+	_ MotorChannel       = (*SimPin)(nil)
+	_ DigitalPin         = (*SimPin)(nil)
+	_ CalibratedActuator = (*SimServo)(nil)
+	_ Light              = (*SimLight)(nil)
+	_ BatterySensor      = (*SimBattery)(nil)
+	_ Display            = (*SimDisplay)(nil)
+)
@@ -0,0 +1,157 @@
+package device
+
+import (
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSimRover(t *testing.T) {
+	tests := []struct {
+		name                 string
+		lf, lb, rf, rb       byte // Pin levels.
+		wantX, wantY, wantTh float64
+	}{
+		{"stopped", 0, 0, 0, 0, 0, 0, 0},
+		{"forward", 255, 0, 255, 0, 20, 0, 0},
+		{"backward", 0, 255, 0, 255, -20, 0, 0},
+		{"half forward", 128, 0, 128, 0, 20 * 128 / 255.0, 0, 0},
+		{"spin left", 0, 255, 255, 0, 0, 0, math.Remainder(4, 2*math.Pi)},
+		{"right only", 0, 0, 255, 0, 5 * math.Sin(2), 5 * (1 - math.Cos(2)), 2},
+	}
+	for _, tc := range tests {
+		r := NewSimRover(20, 10)
+		r.LeftFwd.PwmWrite(tc.lf)
+		r.LeftBwd.PwmWrite(tc.lb)
+		r.RightFwd.PwmWrite(tc.rf)
+		r.RightBwd.PwmWrite(tc.rb)
+
+		// Run for exactly one second from the origin.
+		r.mu.Lock()
+		r.pose = Pose{}
+		r.integrate(r.last.Add(time.Second))
+		p := r.pose
+		r.mu.Unlock()
+
+		if math.Abs(p.X-tc.wantX) > 1e-6 || math.Abs(p.Y-tc.wantY) > 1e-6 || math.Abs(p.Heading-tc.wantTh) > 1e-6 {
+			t.Errorf("%v: pose = %+v, want {X:%v Y:%v Heading:%v}", tc.name, p, tc.wantX, tc.wantY, tc.wantTh)
+		}
+	}
+}
+
+func TestSimRoverEncoders(t *testing.T) {
+	r := NewSimRover(20, 10)
+	l, rr := r.Encoders(40, 6.5)
+	r.LeftFwd.PwmWrite(255)
+	r.RightBwd.PwmWrite(255)
+
+	r.mu.Lock()
+	r.dist = [2]float64{}
+	r.integrate(r.last.Add(time.Second))
+	r.mu.Unlock()
+
+	// 20cm is 0.98 turns of a 6.5cm wheel, encoders count up either way.
+	const want = 39
+	if got := l.Count(); got != want {
+		t.Errorf("left count = %v, want %v", got, want)
+	}
+	if got := rr.Count(); got != want {
+		t.Errorf("right count = %v, want %v", got, want)
+	}
+
+	r.LeftFwd.PwmWrite(0)
+	r.RightBwd.PwmWrite(0)
+	r.Reset()
+	if p := r.Pose(); p.X != 0 || p.Y != 0 || p.Heading != 0 {
+		t.Errorf("pose after Reset = %+v", p)
+	}
+}
+
+func TestSimPin(t *testing.T) {
+	tests := []struct {
+		level    byte
+		wantDuty float64
+		ok       bool
+	}{
+		{0, 0, true},
+		{1, 1, true},
+		{2, 0, false},
+	}
+	for _, tc := range tests {
+		p := NewSimPin("test")
+		err := p.DigitalWrite(tc.level)
+		if (err == nil) != tc.ok {
+			t.Errorf("DigitalWrite(%v) = %v, want ok %v", tc.level, err, tc.ok)
+		}
+		if got := p.Duty(); got != tc.wantDuty {
+			t.Errorf("DigitalWrite(%v) duty = %v, want %v", tc.level, got, tc.wantDuty)
+		}
+	}
+}
+
+func TestSimServo(t *testing.T) {
+	tests := []struct {
+		angle     int
+		lock      bool
+		ok        bool
+		wantAngle int
+	}{
+		{0, false, true, 0},
+		{180, false, true, 180},
+		{181, false, false, 90},
+		{-1, false, false, 90},
+		{45, true, false, 90},
+	}
+	for _, tc := range tests {
+		s := NewSimServo()
+		s.Lock(tc.lock)
+		err := s.SetAngle(tc.angle)
+		if (err == nil) != tc.ok {
+			t.Errorf("SetAngle(%v) locked %v = %v, want ok %v", tc.angle, tc.lock, err, tc.ok)
+		}
+		if got := s.Angle(); got != tc.wantAngle {
+			t.Errorf("SetAngle(%v) locked %v angle = %v, want %v", tc.angle, tc.lock, got, tc.wantAngle)
+		}
+		if tc.ok && s.Pulse() != DefaultServoCalibration.Pulse(tc.angle) {
+			t.Errorf("SetAngle(%v) pulse = %v, want %v", tc.angle, s.Pulse(), DefaultServoCalibration.Pulse(tc.angle))
+		}
+	}
+}
+
+func TestSimDisplay(t *testing.T) {
+	d := NewSimDisplay(4, 3)
+	d.Set(1, 1, 1)
+	d.Set(3, 2, 1)
+	d.Set(4, 0, 1)  // Off screen.
+	d.Set(-1, 0, 1) // Off screen.
+	if !grayEqual(d.Image(), nil) {
+		t.Error("pixels visible before Display")
+	}
+
+	d.Display()
+	if !grayEqual(d.Image(), []image.Point{{1, 1}, {3, 2}}) {
+		t.Errorf("Image after Display = %v", d.Image().(*image.Gray).Pix)
+	}
+
+	d.Set(1, 1, 0)
+	d.Display()
+	if !grayEqual(d.Image(), []image.Point{{3, 2}}) {
+		t.Errorf("Image after clearing a pixel = %v", d.Image().(*image.Gray).Pix)
+	}
+
+	d.Reset()
+	if !grayEqual(d.Image(), nil) {
+		t.Errorf("Image after Reset = %v", d.Image().(*image.Gray).Pix)
+	}
+}
+
+// grayEqual returns true if img is white at on and black elsewhere.
+func grayEqual(img image.Image, on []image.Point) bool {
+	g := img.(*image.Gray)
+	want := make([]byte, len(g.Pix))
+	for _, p := range on {
+		want[p.Y*g.Stride+p.X] = 0xff
+	}
+	return string(g.Pix) == string(want)
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// spidev ioctls from linux/spi/spidev.h.
+const (
+	spiIOCWrMode        = 0x40016b01
+	spiIOCWrBitsPerWord = 0x40016b03
+	spiIOCWrMaxSpeedHz  = 0x40046b04
+	spiIOCMessage1      = 0x40206b00
+)
+
+// spiIOCTransfer is struct spi_ioc_transfer.
+type spiIOCTransfer struct {
+	txBuf       uint64
+	rxBuf       uint64
+	len         uint32
+	speedHz     uint32
+	delayUsecs  uint16
+	bitsPerWord uint8
+	csChange    uint8
+	txNbits     uint8
+	rxNbits     uint8
+	wordDelay   uint8
+	pad         uint8
+}
+
+// SPIDev is an SPI bus opened through the Linux spidev interface in mode 0
+// with 8 bit words.
+type SPIDev struct {
+	file  *os.File
+	speed uint32 // Hz.
+}
+
+// OpenSPIDev opens path, eg. /dev/spidev0.0, clocked at speed Hz.
+func OpenSPIDev(path string, speed uint32) (*SPIDev, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mode uint8
+		bits uint8 = 8
+	)
+	for _, c := range []struct {
+		req uintptr
+		arg unsafe.Pointer
+	}{
+		{spiIOCWrMode, unsafe.Pointer(&mode)},
+		{spiIOCWrBitsPerWord, unsafe.Pointer(&bits)},
+		{spiIOCWrMaxSpeedHz, unsafe.Pointer(&speed)},
+	} {
+		if err := ioctl(f.Fd(), c.req, uintptr(c.arg)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to setup %v: %v", path, err)
+		}
+	}
+	return &SPIDev{
+		file:  f,
+		speed: speed,
+	}, nil
+}
+
+// Tx writes w and reads as many bytes into r if it is not nil.
+func (s *SPIDev) Tx(w, r []byte) error {
+	if len(w) == 0 {
+		return nil
+	}
+	if r != nil && len(r) != len(w) {
+		return fmt.Errorf("spi read buffer needs %v bytes, got %v", len(w), len(r))
+	}
+	tr := spiIOCTransfer{
+		txBuf:       uint64(uintptr(unsafe.Pointer(&w[0]))),
+		len:         uint32(len(w)),
+		speedHz:     s.speed,
+		bitsPerWord: 8,
+	}
+	if r != nil {
+		tr.rxBuf = uint64(uintptr(unsafe.Pointer(&r[0])))
+	}
+	err := ioctl(s.file.Fd(), spiIOCMessage1, uintptr(unsafe.Pointer(&tr)))
+	runtime.KeepAlive(w)
+	runtime.KeepAlive(r)
+	return err
+}
+
+func (s *SPIDev) Close() error {
+	return s.file.Close()
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package device
+
+import "errors"
+
+// SPIDev is an SPI bus opened through the Linux spidev interface.
+type SPIDev struct{}
+
+func OpenSPIDev(path string, speed uint32) (*SPIDev, error) {
+	return nil, errors.New("spidev is only supported on linux")
+}
+
+func (s *SPIDev) Tx(w, r []byte) error {
+	return errors.New("spidev is only supported on linux")
+}
+
+func (s *SPIDev) Close() error {
+	return nil
+}
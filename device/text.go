@@ -0,0 +1,113 @@
+package device
+
+import (
+	"strings"
+	"time"
+)
+
+// Text alignments.
+const (
+	ALIGN_LEFT = iota
+	ALIGN_CENTER
+	ALIGN_RIGHT
+)
+
+// textTick is the redraw interval of scrolling text.
+const textTick = 50 * time.Millisecond
+
+// Text is a block of text drawn on the OLED over the animation.
+type Text struct {
+	Text   string // Lines are separated by "\n".
+	Font   *Font  // nil uses Font5x7.
+	Scale  int    // Pixel size of the font. 0 is 1.
+	Align  int    // ALIGN_* of lines within the box.
+	X, Y   int    // Top left of the text box.
+	W, H   int    // Size of the text box. 0 extends it to the display edge.
+	Scroll int    // Pixels per second to scroll text that does not fit the box. 0 clips it.
+}
+
+// draw draws the text on d as it is elapsed after it was shown. Lines
+// wider than the box scroll sideways and text taller than the box scrolls
+// up.
+func (t *Text) draw(d Display, elapsed time.Duration) {
+	font := t.Font
+	if font == nil {
+		font = Font5x7
+	}
+	scale := t.Scale
+	if scale < 1 {
+		scale = 1
+	}
+
+	dw, dh := d.Size()
+	bw, bh := t.W, t.H
+	if bw <= 0 {
+		bw = dw - t.X
+	}
+	if bh <= 0 {
+		bh = dh - t.Y
+	}
+	set := func(x, y int) {
+		if x < t.X || x >= t.X+bw || y < t.Y || y >= t.Y+bh {
+			return
+		}
+		if x < 0 || x >= dw || y < 0 || y >= dh {
+			return
+		}
+		d.Set(x, y, 1)
+	}
+
+	shift := int(elapsed * time.Duration(t.Scroll) / time.Second)
+	lines := strings.Split(t.Text, "\n")
+	lh := font.LineHeight() * scale
+
+	// Text taller than the box wraps around with a blank line between.
+	tops := []int{t.Y}
+	if total := len(lines) * lh; t.Scroll > 0 && total > bh {
+		period := total + lh
+		top := t.Y - shift%period
+		tops = []int{top, top + period}
+	}
+
+	for _, top := range tops {
+		for i, line := range lines {
+			y := top + i*lh
+			if y+lh <= t.Y || y >= t.Y+bh {
+				continue
+			}
+			lw := font.TextWidth(line) * scale
+			x := t.X
+
+			switch {
+			case lw <= bw:
+				switch t.Align {
+				case ALIGN_CENTER:
+					x += (bw - lw) / 2
+				case ALIGN_RIGHT:
+					x += bw - lw
+				}
+				font.drawString(line, x, y, scale, set)
+
+			case t.Scroll > 0:
+				// Marquee with a two glyph gap.
+				period := lw + 2*font.Advance()*scale
+				x -= shift % period
+				font.drawString(line, x, y, scale, set)
+				font.drawString(line, x+period, y, scale, set)
+
+			default:
+				font.drawString(line, x, y, scale, set)
+			}
+		}
+	}
+}
+
+// scrolls returns true if any of texts needs redrawing to scroll.
+func scrolls(texts []Text) bool {
+	for _, t := range texts {
+		if t.Scroll > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package device
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFont is a 2x3 font small enough to draw expectations by hand.
+var testFont = &Font{
+	Width:  2,
+	Height: 3,
+	First:  '?',
+	Glyphs: [][]byte{
+		{0x1, 0x5}, // '?' top left, right column top and bottom.
+		{0x7, 0x0}, // '@' left column.
+		{0x7, 0x7}, // 'A' block.
+	},
+}
+
+// render returns w x h pixels drawn by draw as rows of '#' (on) and '.'.
+func render(w, h int, draw func(set func(x, y int))) []string {
+	rows := make([][]byte, h)
+	for y := range rows {
+		rows[y] = []byte(strings.Repeat(".", w))
+	}
+	draw(func(x, y int) {
+		if x >= 0 && x < w && y >= 0 && y < h {
+			rows[y][x] = '#'
+		}
+	})
+	out := make([]string, h)
+	for y, r := range rows {
+		out[y] = string(r)
+	}
+	return out
+}
+
+// imageRows returns img as rows of '#' (on) and '.'.
+func imageRows(img image.Image) []string {
+	b := img.Bounds()
+	return render(b.Dx(), b.Dy(), func(set func(x, y int)) {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if r, _, _, _ := img.At(x, y).RGBA(); r != 0 {
+					set(x-b.Min.X, y-b.Min.Y)
+				}
+			}
+		}
+	})
+}
+
+func TestDrawString(t *testing.T) {
+	tests := []struct {
+		line  string
+		x, y  int
+		scale int
+		w, h  int
+		want  []string
+	}{
+		{"A", 0, 0, 1, 5, 3, []string{
+			"##...",
+			"##...",
+			"##...",
+		}},
+		{"@A", 0, 0, 1, 5, 3, []string{
+			"#..##",
+			"#..##",
+			"#..##",
+		}},
+		{"z", 1, 1, 1, 4, 4, []string{
+			"....",
+			".##.",
+			"....",
+			"..#.",
+		}},
+		{"A", 1, 0, 2, 6, 6, []string{
+			".####.",
+			".####.",
+			".####.",
+			".####.",
+			".####.",
+			".####.",
+		}},
+		{"@", -1, 0, 1, 3, 3, []string{
+			"...",
+			"...",
+			"...",
+		}},
+	}
+	for _, tc := range tests {
+		got := render(tc.w, tc.h, func(set func(x, y int)) {
+			testFont.drawString(tc.line, tc.x, tc.y, tc.scale, set)
+		})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("drawString(%q, %v, %v, %v) =\n%v\nwant\n%v", tc.line, tc.x, tc.y, tc.scale,
+				strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		line string
+		want int
+	}{
+		{"", 0},
+		{"A", 2},
+		{"AA", 5},
+		{"µA", 5},
+	}
+	for _, tc := range tests {
+		if got := testFont.TextWidth(tc.line); got != tc.want {
+			t.Errorf("TextWidth(%q) = %v, want %v", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestTextDraw(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    Text
+		w, h    int
+		elapsed time.Duration
+		want    []string
+	}{
+		{"left", Text{Text: "A"}, 7, 3, 0, []string{
+			"##.....",
+			"##.....",
+			"##.....",
+		}},
+		{"center", Text{Text: "A", Align: ALIGN_CENTER}, 7, 3, 0, []string{
+			"..##...",
+			"..##...",
+			"..##...",
+		}},
+		{"right", Text{Text: "A", Align: ALIGN_RIGHT}, 7, 3, 0, []string{
+			".....##",
+			".....##",
+			".....##",
+		}},
+		{"box", Text{Text: "A", Align: ALIGN_RIGHT, X: 1, Y: 1, W: 4, H: 2}, 7, 4, 0, []string{
+			".......",
+			"...##..",
+			"...##..",
+			".......",
+		}},
+		{"clipped", Text{Text: "AAA", W: 4}, 7, 3, 0, []string{
+			"##.#...",
+			"##.#...",
+			"##.#...",
+		}},
+		{"scroll sideways", Text{Text: "AA", W: 4, Scroll: 20}, 7, 4, 50 * time.Millisecond, []string{
+			"#.##...",
+			"#.##...",
+			"#.##...",
+			".......",
+		}},
+		{"scroll sideways wraps", Text{Text: "AA", W: 4, Scroll: 20}, 7, 4, 550 * time.Millisecond, []string{
+			"##.#...",
+			"##.#...",
+			"##.#...",
+			".......",
+		}},
+		{"scroll up", Text{Text: "A\n@", Scroll: 10}, 2, 4, 200 * time.Millisecond, []string{
+			"##",
+			"..",
+			"#.",
+			"#.",
+		}},
+		{"scale", Text{Text: "@", Scale: 2, X: 1}, 4, 7, 0, []string{
+			".##.",
+			".##.",
+			".##.",
+			".##.",
+			".##.",
+			".##.",
+			"....",
+		}},
+	}
+	for _, tc := range tests {
+		tc.text.Font = testFont
+		d := NewSimDisplay(tc.w, tc.h)
+		tc.text.draw(d, tc.elapsed)
+		d.Display()
+		if got := imageRows(d.Image()); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: draw =\n%v\nwant\n%v", tc.name, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package device
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+)
+
+// TFT controllers.
+const (
+	TFT_ST7735  = iota // 160x128.
+	TFT_ILI9341        // 320x240.
+)
+
+// TFT commands shared by the ST7735 and ILI9341.
+const (
+	tftSWReset  = 0x01
+	tftSleepOut = 0x11
+	tftNormalOn = 0x13
+	tftInvOff   = 0x20
+	tftDispOff  = 0x28
+	tftDispOn   = 0x29
+	tftCASet    = 0x2a
+	tftRASet    = 0x2b
+	tftRAMWr    = 0x2c
+	tftMADCtl   = 0x36
+	tftColMod   = 0x3a
+)
+
+// tftChunk is the largest SPI transfer, the default spidev buffer size.
+const tftChunk = 4096
+
+// tftModel is the setup of a TFT controller in landscape.
+type tftModel struct {
+	name   string
+	w, h   int
+	madctl byte // Memory access control for landscape.
+}
+
+var tftModels = map[int]tftModel{
+	TFT_ST7735:  {"ST7735", 160, 128, 0x60},
+	TFT_ILI9341: {"ILI9341", 320, 240, 0x28},
+}
+
+// TFT drives an ST7735 or ILI9341 colour TFT over SPI in landscape.
+// Monochrome frames are drawn in the foreground colour on the background
+// colour of its RGBBuffer.
+type TFT struct {
+	spi   SPIBus
+	dc    DigitalPin // Low for commands, high for data.
+	rst   DigitalPin // Optional hardware reset.
+	model tftModel
+	buf   *RGBBuffer
+}
+
+// NewTFT initializes a TFT of model (TFT_*) on spi. rst may be nil if the
+// reset line is not wired. A w or h of 0 uses the size of the model.
+func NewTFT(model int, spi SPIBus, dc, rst DigitalPin, w, h int) (*TFT, error) {
+	m, ok := tftModels[model]
+	if !ok {
+		return nil, fmt.Errorf("unknown TFT model %v", model)
+	}
+	if w == 0 || h == 0 {
+		w, h = m.w, m.h
+	}
+	if w < 0 || w > m.w || h < 0 || h > m.h {
+		return nil, fmt.Errorf("%v size needs to be up to %vx%v, got %vx%v", m.name, m.w, m.h, w, h)
+	}
+	d := &TFT{
+		spi:   spi,
+		dc:    dc,
+		rst:   rst,
+		model: m,
+		buf:   NewRGBBuffer(w, h),
+	}
+	if err := d.init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize %v: %v", m.name, err)
+	}
+	return d, nil
+}
+
+// init resets the controller and sets it up for 16 bit colour.
+func (d *TFT) init() error {
+	if d.rst != nil {
+		for _, l := range []byte{1, 0, 1} {
+			if err := d.rst.DigitalWrite(l); err != nil {
+				return err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(110 * time.Millisecond)
+	}
+
+	steps := []struct {
+		cmd   byte
+		data  []byte
+		sleep time.Duration
+	}{
+		{tftSWReset, nil, 150 * time.Millisecond},
+		{tftSleepOut, nil, 120 * time.Millisecond},
+		{tftColMod, []byte{0x05}, 10 * time.Millisecond}, // RGB565.
+		{tftMADCtl, []byte{d.model.madctl}, 0},
+		{tftInvOff, nil, 0},
+		{tftNormalOn, nil, 10 * time.Millisecond},
+		{tftDispOn, nil, 100 * time.Millisecond},
+	}
+	for _, st := range steps {
+		if err := d.command(st.cmd, st.data...); err != nil {
+			return err
+		}
+		time.Sleep(st.sleep)
+	}
+	return nil
+}
+
+// command sends cmd followed by its data.
+func (d *TFT) command(cmd byte, data ...byte) error {
+	if err := d.dc.DigitalWrite(0); err != nil {
+		return err
+	}
+	if err := d.spi.Tx([]byte{cmd}, nil); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return d.data(data)
+}
+
+// data sends b in chunks the SPI bus takes.
+func (d *TFT) data(b []byte) error {
+	if err := d.dc.DigitalWrite(1); err != nil {
+		return err
+	}
+	for len(b) > 0 {
+		n := len(b)
+		if n > tftChunk {
+			n = tftChunk
+		}
+		if err := d.spi.Tx(b[:n], nil); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (d *TFT) Size() (int, int) {
+	return d.buf.Size()
+}
+
+func (d *TFT) Clear() {
+	d.buf.Clear()
+}
+
+func (d *TFT) Set(x, y, c int) {
+	d.buf.Set(x, y, c)
+}
+
+func (d *TFT) SetRGB(x, y int, c color.Color) {
+	d.buf.SetRGB(x, y, c)
+}
+
+// SetColors sets the colours monochrome pixels are drawn in.
+func (d *TFT) SetColors(fg, bg color.Color) {
+	d.buf.Fg, d.buf.Bg = RGB565(fg), RGB565(bg)
+}
+
+// Display sends the buffer to the display.
+func (d *TFT) Display() error {
+	x1, y1 := d.buf.Width-1, d.buf.Height-1
+	if err := d.command(tftCASet, 0, 0, byte(x1>>8), byte(x1)); err != nil {
+		return err
+	}
+	if err := d.command(tftRASet, 0, 0, byte(y1>>8), byte(y1)); err != nil {
+		return err
+	}
+	if err := d.command(tftRAMWr); err != nil {
+		return err
+	}
+	return d.data(d.buf.Pix)
+}
+
+// Reset reinitializes the display and clears it.
+func (d *TFT) Reset() error {
+	if err := d.init(); err != nil {
+		return err
+	}
+	d.buf.Clear()
+	return d.Display()
+}
+
+func (d *TFT) On() error {
+	return d.command(tftDispOn)
+}
+
+func (d *TFT) Off() error {
+	return d.command(tftDispOff)
+}
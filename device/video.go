@@ -1,6 +1,8 @@
 package device
 
 import (
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/blackjack/webcam"
@@ -38,6 +40,8 @@ type Video struct {
 	stop        chan struct{}
 	fps         uint
 	capStatus   bool
+	mu          sync.Mutex // Guards last.
+	last        []byte     // Last frame sent to the stream.
 }
 
 func NewVideo(pixelFormat webcam.PixelFormat, w uint32, h uint32, fps uint) *Video {
@@ -65,6 +69,11 @@ func (s *Video) SetFPS(fps uint) {
 	s.fps = fps
 }
 
+// IsStreaming returns true if video is being captured.
+func (s *Video) IsStreaming() bool {
+	return s.capStatus
+}
+
 func (s *Video) StartVideoStream() error {
 	cam, err := webcam.Open("/dev/video0")
 	if err != nil {
@@ -94,6 +103,17 @@ func (s *Video) StopVideoStream() {
 	}
 }
 
+// Snapshot returns the last captured JPEG frame.
+func (s *Video) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.capStatus || len(s.last) == 0 {
+		return nil, errors.New("video capture not running")
+	}
+	return append([]byte(nil), s.last...), nil
+}
+
 func (s *Video) startStreamer() {
 
 	// Since the ReadFrame is buffered, trying to read at FPS results in delay.
@@ -127,6 +147,9 @@ func (s *Video) startStreamer() {
 
 		case <-fpsTicker.C:
 			s.Stream.UpdateJPEG(frame)
+			s.mu.Lock()
+			s.last = frame
+			s.mu.Unlock()
 		}
 	}
 }
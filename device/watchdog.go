@@ -0,0 +1,89 @@
+package device
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// minWatchdogTick is the shortest interval the watchdog checks the heartbeat.
+const minWatchdogTick = 10 * time.Millisecond
+
+// StartWatchdog starts a dead-man watchdog. If the rover is moving and no
+// Heartbeat arrives within timeout the motors are stopped and the brake is
+// engaged. The watchdog is cleared when the brake is released. Calling it
+// again changes the timeout.
+func (s *Ubiquity) StartWatchdog(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("watchdog timeout needs to be positive, got %v", timeout)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	glog.Infof("Starting motor watchdog with timeout %v", timeout)
+	s.wdTimeout = timeout
+	s.lastBeat = time.Now()
+	if !s.wdStarted {
+		s.wdStarted = true
+		go s.watchdogLoop()
+	}
+	return nil
+}
+
+// watchdogLoop checks the heartbeat a few times per timeout, but not too
+// often for short ones.
+func (s *Ubiquity) watchdogLoop() {
+	for {
+		s.mu.Lock()
+		interval := s.wdTimeout / 4
+		s.mu.Unlock()
+		if interval < minWatchdogTick {
+			interval = minWatchdogTick
+		}
+		time.Sleep(interval)
+		s.checkWatchdog()
+	}
+}
+
+// checkWatchdog stops the rover and engages the brake if it is moving and
+// no heartbeat arrived within the timeout. A failed stop is retried on the
+// next check while the heartbeat is still missing.
+func (s *Ubiquity) checkWatchdog() {
+	s.mu.Lock()
+	since := time.Since(s.lastBeat)
+	expired := (s.moving() || s.wdRetry) && since > s.wdTimeout
+	s.mu.Unlock()
+
+	if !expired {
+		return
+	}
+	glog.Warningf("Watchdog: no heartbeat for %v, stopping motors and engaging brake", since)
+	err := s.Lock(true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		glog.Errorf("Watchdog failed to stop motors: %v", err)
+		s.wdRetry = true
+		return
+	}
+	s.wdRetry = false
+	s.wdTripped = true
+}
+
+// Heartbeat tells the watchdog the control client is still alive.
+func (s *Ubiquity) Heartbeat() {
+	s.mu.Lock()
+	s.lastBeat = time.Now()
+	s.mu.Unlock()
+}
+
+// WatchdogTripped returns true if the watchdog stopped the rover and the
+// brake has not been released since.
+func (s *Ubiquity) WatchdogTripped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wdTripped
+}
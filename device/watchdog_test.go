@@ -0,0 +1,169 @@
+package device
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// brokenChannel is an H-bridge input whose writes fail while broken is set.
+type brokenChannel struct {
+	fakeChannel
+	mu     sync.Mutex
+	broken bool
+}
+
+func (c *brokenChannel) PwmWrite(level byte) error {
+	c.mu.Lock()
+	broken := c.broken
+	c.mu.Unlock()
+	if broken {
+		return errors.New("write failed")
+	}
+	return c.fakeChannel.PwmWrite(level)
+}
+
+func (c *brokenChannel) Break(broken bool) {
+	c.mu.Lock()
+	c.broken = broken
+	c.mu.Unlock()
+}
+
+func TestStartWatchdog(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		u, _ := newTestRover()
+		if err := u.StartWatchdog(timeout); err == nil {
+			t.Errorf("StartWatchdog(%v) succeeded", timeout)
+		}
+	}
+
+	// The loop stops a rover that lost its heartbeat.
+	u, ch := newTestRover()
+	if err := u.StartWatchdog(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.StartWatchdog(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Drive(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for end := time.Now().Add(time.Second); !u.WatchdogTripped() && time.Now().Before(end); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !u.WatchdogTripped() || !u.Locked() {
+		t.Errorf("tripped %v locked %v, want both", u.WatchdogTripped(), u.Locked())
+	}
+	if l := levels(ch); l != [4]byte{} {
+		t.Errorf("levels = %v, want all off", l)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	tests := []struct {
+		name        string
+		moving      bool
+		since       time.Duration // Since the last heartbeat.
+		wantTripped bool
+	}{
+		{"moving without heartbeat", true, 2 * time.Second, true},
+		{"moving with heartbeat", true, 500 * time.Millisecond, false},
+		{"stopped without heartbeat", false, 2 * time.Second, false},
+	}
+	for _, tc := range tests {
+		u, ch := newTestRover()
+		u.wdTimeout = time.Second
+		if tc.moving {
+			if err := u.Drive(1, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		u.mu.Lock()
+		u.lastBeat = time.Now().Add(-tc.since)
+		u.mu.Unlock()
+
+		u.checkWatchdog()
+		if got := u.WatchdogTripped(); got != tc.wantTripped {
+			t.Errorf("%v: tripped = %v, want %v", tc.name, got, tc.wantTripped)
+		}
+		if got := u.Locked(); got != tc.wantTripped {
+			t.Errorf("%v: locked = %v, want %v", tc.name, got, tc.wantTripped)
+		}
+		if l := levels(ch); tc.wantTripped && l != [4]byte{} {
+			t.Errorf("%v: levels = %v, want all off", tc.name, l)
+		}
+		u.AllMotorStop()
+	}
+}
+
+func TestWatchdogHeartbeat(t *testing.T) {
+	u, _ := newTestRover()
+	u.wdTimeout = time.Second
+	if err := u.Drive(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	u.mu.Lock()
+	u.lastBeat = time.Now().Add(-2 * time.Second)
+	u.mu.Unlock()
+
+	u.Heartbeat()
+	u.checkWatchdog()
+	if u.WatchdogTripped() || u.Locked() {
+		t.Error("watchdog tripped after a heartbeat")
+	}
+}
+
+func TestWatchdogRelease(t *testing.T) {
+	u, _ := newTestRover()
+	u.wdTimeout = time.Second
+	if err := u.Drive(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	u.mu.Lock()
+	u.lastBeat = time.Now().Add(-2 * time.Second)
+	u.mu.Unlock()
+	u.checkWatchdog()
+	if !u.WatchdogTripped() {
+		t.Fatal("watchdog not tripped")
+	}
+
+	if err := u.Lock(false); err != nil {
+		t.Fatal(err)
+	}
+	if u.WatchdogTripped() {
+		t.Error("watchdog still tripped after releasing the brake")
+	}
+}
+
+func TestWatchdogRetry(t *testing.T) {
+	var ch [4]*brokenChannel
+	for i := range ch {
+		ch[i] = &brokenChannel{}
+	}
+	u := New(ch[0], ch[1], ch[2], ch[3], nil, nil)
+	u.wdTimeout = time.Second
+	if err := u.Drive(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	u.mu.Lock()
+	u.lastBeat = time.Now().Add(-2 * time.Second)
+	u.mu.Unlock()
+
+	// A failed stop is not reported as tripped and is tried again.
+	ch[0].Break(true)
+	u.checkWatchdog()
+	if u.WatchdogTripped() {
+		t.Error("watchdog tripped although the motors did not stop")
+	}
+	ch[0].Break(false)
+	u.checkWatchdog()
+	if !u.WatchdogTripped() || !u.Locked() {
+		t.Errorf("after retry tripped %v locked %v, want both", u.WatchdogTripped(), u.Locked())
+	}
+	for i, c := range ch {
+		if l := c.Level(); l != 0 {
+			t.Errorf("channel %v level = %v, want off", i, l)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/deepakkamesh/ubiquity/device"
+	"github.com/golang/glog"
+)
+
+// SetAnimations enables the /animations endpoints on lib. Activated
+// animations are shown by the face.
+func (s *Server) SetAnimations(lib *device.AnimationLibrary) {
+	s.animLib = lib
+}
+
+// animations lists the animations and the active ones on GET or adds one on
+// POST. Uploads are multipart forms with the image in file and the
+// AnimationInfo fields name, delay, frame_width, frame_height, scale and
+// convert.
+func (s *Server) animations(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAnimations(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Animations []device.AnimationInfo `json:"animations"`
+			Active     map[string]string      `json:"active"`
+		}{s.animLib.List(), s.animLib.Active()})
+
+	case http.MethodPost:
+		// Leave room for the other form fields.
+		r.Body = http.MaxBytesReader(w, r.Body, device.MaxAnimationBytes+64<<10)
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "animation needs a file: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		info := device.AnimationInfo{
+			Name:    r.FormValue("name"),
+			Scale:   r.FormValue("scale"),
+			Convert: r.FormValue("convert"),
+		}
+		var nums [3]int
+		for i, k := range []string{"delay", "frame_width", "frame_height"} {
+			v := r.FormValue(k)
+			if v == "" {
+				continue
+			}
+			if nums[i], err = strconv.Atoi(v); err != nil || nums[i] < 0 {
+				http.Error(w, k+" needs to be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		info.Delay, info.FrameW, info.FrameH = uint(nums[0]), nums[1], nums[2]
+
+		if err := s.animLib.Add(info, data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Show the new version if it replaced an active animation.
+		if err := s.animLib.Apply(s.face); err != nil {
+			glog.Errorf("Failed to apply animations: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// animation handles /animations/<name>. DELETE removes it,
+// GET /animations/<name>/preview?scale=2 returns it as an animated GIF of
+// how it looks on the display and POST /animations/<name>/activate with
+// form value expression (default idle) makes the face show it.
+func (s *Server) animation(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAnimations(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/animations/"), "/")
+	name, action := parts[0], ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		exprs, err := s.animLib.Delete(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		for _, expr := range exprs {
+			s.face.SetAnimation(expr, nil)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "preview" && r.Method == http.MethodGet:
+		a, err := s.animLib.Load(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		dw, dh := s.oled.Size()
+		scale, err := previewScale(r, dw, dh)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		if err := a.PreviewGIF(w, dw, dh, scale); err != nil {
+			glog.Errorf("Failed to preview animation %v: %v", name, err)
+		}
+
+	case action == "activate" && r.Method == http.MethodPost:
+		expr := r.FormValue("expression")
+		if expr == "" {
+			expr = device.EXPR_IDLE
+		}
+		a, err := s.animLib.Load(name)
+		if err == nil {
+			err = s.animLib.Activate(name, expr)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.face.SetAnimation(expr, a)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// checkAnimations replies with an error unless the animation library is
+// enabled and the request is authorized.
+func (s *Server) checkAnimations(w http.ResponseWriter, r *http.Request) bool {
+	if !checkAuth(w, r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="MY REALM"`)
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if s.animLib == nil || s.face == nil {
+		http.Error(w, "animations not enabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package httphandler
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepakkamesh/ubiquity/device"
+	"github.com/deepakkamesh/ubiquity/mission"
+)
+
+// Dashboard returns the lines of the OLED status dashboard.
+func (s *Server) Dashboard() []string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+
+	brake := "free"
+	if s.dev.Locked() {
+		brake = "LOCKED"
+	}
+	lines := []string{
+		host,
+		ipAddr(),
+		fmt.Sprintf("Clients %v %v", atomic.LoadInt32(&s.connCount), brake),
+		fmt.Sprintf("Vid %v Aud %v", onOff(s.video != nil && s.video.IsStreaming()),
+			onOff(s.audio != nil && (s.audio.IsRec() || s.audio.IsPlaying()))),
+	}
+
+	if batt, err := s.dev.Battery(); err == nil {
+		lines = append(lines, fmt.Sprintf("Batt %.0f%%", batt))
+	} else {
+		lines = append(lines, "Batt n/a")
+	}
+
+	if s.dev.HasOdometry() {
+		pose := s.dev.Pose()
+		tl, tr := s.dev.Ticks()
+		lines = append(lines,
+			fmt.Sprintf("X%.1f Y%.1f", pose.X, pose.Y),
+			fmt.Sprintf("H%.0f L%v R%v", pose.Heading*180/math.Pi, tl, tr))
+	} else {
+		lines = append(lines, "Odom n/a")
+	}
+
+	if p := s.mission.Progress(); p.State != mission.IDLE {
+		lines = append(lines, fmt.Sprintf("Msn %v %v/%v", p.State, p.Step, p.Steps))
+	}
+	return lines
+}
+
+// ipAddr returns the first non loopback IPv4 address of the rover.
+func ipAddr() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "no network"
+	}
+	for _, a := range addrs {
+		if ip, ok := a.(*net.IPNet); ok && !ip.IP.IsLoopback() && ip.IP.To4() != nil {
+			return ip.IP.String()
+		}
+	}
+	return "no network"
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// dashboardArgs returns the mode and rotate interval of an OLED_DASHBOARD
+// message. Data is the mode or [mode, rotate seconds].
+func dashboardArgs(data interface{}) (int, time.Duration, error) {
+	var mode, secs float64
+	switch d := data.(type) {
+	case float64:
+		mode = d
+	case []interface{}:
+		ok1, ok2 := false, false
+		if len(d) == 2 {
+			mode, ok1 = d[0].(float64)
+			secs, ok2 = d[1].(float64)
+		}
+		if !ok1 || !ok2 {
+			return 0, 0, fmt.Errorf("dashboard needs [mode, rotate seconds], got %v", data)
+		}
+	default:
+		return 0, 0, fmt.Errorf("dashboard needs mode, got %v", data)
+	}
+	if mode != device.DASHBOARD_OFF && mode != device.DASHBOARD_ON && mode != device.DASHBOARD_ROTATE {
+		return 0, 0, fmt.Errorf("unknown dashboard mode %v", mode)
+	}
+	return int(mode), time.Duration(secs * float64(time.Second)), nil
+}
@@ -0,0 +1,37 @@
+package httphandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deepakkamesh/ubiquity/device"
+)
+
+func TestDashboardArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       interface{}
+		wantMode   int
+		wantRotate time.Duration
+		wantOK     bool
+	}{
+		{"on", float64(device.DASHBOARD_ON), device.DASHBOARD_ON, 0, true},
+		{"off", float64(device.DASHBOARD_OFF), device.DASHBOARD_OFF, 0, true},
+		{"rotate", []interface{}{float64(device.DASHBOARD_ROTATE), 2.5}, device.DASHBOARD_ROTATE, 2500 * time.Millisecond, true},
+		{"unknown mode", float64(7), 0, 0, false},
+		{"no rotate", []interface{}{float64(device.DASHBOARD_ROTATE)}, 0, 0, false},
+		{"rotate not a number", []interface{}{float64(device.DASHBOARD_ROTATE), "5"}, 0, 0, false},
+		{"string", "on", 0, 0, false},
+		{"nothing", nil, 0, 0, false},
+	}
+	for _, tc := range tests {
+		mode, rotate, err := dashboardArgs(tc.data)
+		if (err == nil) != tc.wantOK {
+			t.Errorf("%v: dashboardArgs(%v) = %v, want ok %v", tc.name, tc.data, err, tc.wantOK)
+			continue
+		}
+		if mode != tc.wantMode || rotate != tc.wantRotate {
+			t.Errorf("%v: dashboardArgs(%v) = %v, %v, want %v, %v", tc.name, tc.data, mode, rotate, tc.wantMode, tc.wantRotate)
+		}
+	}
+}
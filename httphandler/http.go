@@ -1,13 +1,20 @@
 package httphandler
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/deepakkamesh/ubiquity/device"
+	"github.com/deepakkamesh/ubiquity/mission"
 	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
 )
@@ -37,11 +44,62 @@ const (
 	HEADLIGHT_ON
 	HEADLIGHT_OFF
 	STATUS
+	DRIVE_DIFF         // Wheel velocities [left, right] or [left, right, duration] in -100 to 100.
+	PING               // Heartbeat for the motor watchdog.
+	ODOM_RESET         // Reset odometry pose and tick counts.
+	MOVE_DISTANCE      // Drive [cm, speed]. Negative cm drives backward.
+	ROTATE             // Turn in place [degrees, speed]. Positive is counter clockwise.
+	MISSION_START      // Run mission steps. Data is JSON or YAML text or a list of steps.
+	MISSION_PAUSE      // Pause the running mission.
+	MISSION_RESUME     // Resume the paused mission.
+	MISSION_ABORT      // Abort the running mission.
+	MISSION_PROGRESS   // Mission progress sent to clients, or request for it.
+	SERVO_SET          // Move servo [name, angle].
+	SERVO_MOVE         // Move servo [name, steps]. Negative steps move down.
+	SERVO_GET          // Request the angle of servo name, or of all servos if empty.
+	SERVO_ANGLE        // Servo name, angle and limits sent to clients.
+	PAN_TILT           // Move the pan/tilt head to [pan, tilt], or center it if empty.
+	SERVO_CALIB_START  // Start calibrating servo name. Angle moves are refused until it ends.
+	SERVO_PULSE        // Drive the calibrating servo with a raw pulse [name, microsecs].
+	SERVO_CALIBRATE    // Apply and save [name, config] ending calibration.
+	SERVO_CALIB_CANCEL // End calibrating servo name without changes.
+	SERVO_CALIBRATION  // Config of servo name sent to clients, or request for it.
+	SERVO_MOVE_TO      // Move servo smoothly [name, angle, degrees per second].
+	SERVO_SWEEP        // Scan servo [name, from, to, degrees per second] until stopped.
+	SERVO_STOP         // Stop moves and sweeps of servo name.
+	SERVO_HOLD         // Keep the pulse on after moves [name, 1] or turn it off [name, 0].
+	OLED_DASHBOARD     // Set OLED dashboard mode, or [mode, rotate seconds]. 0 off, 1 on, 2 rotate.
+	FACE_EXPRESSION    // Set face expression name, or [name, seconds]. "" follows the rover again. No data returns the current one.
 )
 
+// driveDirs maps drive messages to device directions.
+var driveDirs = map[int]int{
+	DRIVE_FWD:        device.DRIVE_FWD,
+	DRIVE_BWD:        device.DRIVE_BWD,
+	DRIVE_LEFT:       device.DRIVE_LEFT,
+	DRIVE_RIGHT:      device.DRIVE_RIGHT,
+	DRIVE_LEFT_ONLY:  device.DRIVE_LEFT_ONLY,
+	DRIVE_RIGHT_ONLY: device.DRIVE_RIGHT_ONLY,
+}
+
+// faceGreeting is how long the face looks happy when a client connects.
+const faceGreeting = 3 * time.Second
+
 // Status Fields.
 const (
 	AUDIO = iota
+	LOCKED
+	WATCHDOG        // Watchdog stopped the rover.
+	ODOM_X          // Odometry x in mm.
+	ODOM_Y          // Odometry y in mm.
+	ODOM_HEADING    // Odometry heading in degrees.
+	TICKS_LEFT      // Left wheel encoder ticks.
+	TICKS_RIGHT     // Right wheel encoder ticks.
+	BATTERY         // Battery percent, -1 if unknown.
+	CLIENTS         // Connected control clients.
+	AUDIO_UNDERRUNS // Times playback ran out of audio.
+	AUDIO_OVERRUNS  // Times playback had too much audio buffered.
+	AUDIO_DROPPED   // Audio chunks dropped by playback.
 )
 
 // Control Message.
@@ -50,26 +108,42 @@ type ControlMsg struct {
 	Data    interface{}
 }
 
+// ctrlConn serializes writes to a control websocket shared by the read loop
+// and background senders such as mission progress.
+type ctrlConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *ctrlConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
+
 type Server struct {
-	dev   *device.Ubiquity
-	audio *device.Audio
-	video *device.Video
+	dev     *device.Ubiquity
+	audio   *device.Audio
+	video   *device.Video
+	oled    *device.OLED
+	face    *device.Face
+	mission *mission.Runner
+	animLib *device.AnimationLibrary // See SetAnimations.
 
-	connCount  int // number of connected http clients.
-	servoStep  int // Servo step for each click.
-	servoAngle int // Current Angle for servo.
+	connCount int32 // number of connected http clients.
 
 	pauseRec bool
 }
 
-func New(dev *device.Ubiquity, aud *device.Audio, vid *device.Video) *Server {
+func New(dev *device.Ubiquity, aud *device.Audio, vid *device.Video, oled *device.OLED, face *device.Face, mis *mission.Runner) *Server {
 	return &Server{
-		dev:        dev,
-		audio:      aud,
-		video:      vid,
-		servoAngle: 90,
-		servoStep:  30,
-		pauseRec:   false,
+		dev:      dev,
+		audio:    aud,
+		video:    vid,
+		oled:     oled,
+		face:     face,
+		mission:  mis,
+		pauseRec: false,
 	}
 }
 
@@ -78,6 +152,10 @@ func (s *Server) Start(hostPort string, resPath string, cert string, privkey str
 	// http routers.
 	http.HandleFunc("/audiostream", s.audioSock)
 	http.HandleFunc("/control", s.controlSock)
+	http.HandleFunc("/oled.png", s.oledPNG)
+	http.HandleFunc("/oledstream", s.oledSock)
+	http.HandleFunc("/animations", s.animations)
+	http.HandleFunc("/animations/", s.animation)
 	if s.video != nil {
 		http.Handle("/videostream", s.video.Stream)
 	}
@@ -129,17 +207,39 @@ func (s *Server) controlSock(w http.ResponseWriter, r *http.Request) {
 			return true
 		},
 	}
-	c, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		glog.Errorf("Failed to upgrade conn:%v", err)
 		return
 	}
+	c := &ctrlConn{Conn: conn}
+
+	atomic.AddInt32(&s.connCount, 1)
+	if s.face != nil {
+		s.face.Flash(device.EXPR_HAPPY, faceGreeting)
+	}
+
+	// Motions started from this connection are stopped when it closes.
+	ctx, cancel := context.WithCancel(context.Background())
 
-	s.connCount++
+	// Forward mission progress to the client.
+	progress, unsubscribe := s.mission.Subscribe()
+	go func() {
+		for {
+			select {
+			case p := <-progress:
+				sendData(MISSION_PROGRESS, p, c)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	defer func() {
+		cancel()
+		unsubscribe()
 		c.Close()
-		s.connCount--
+		atomic.AddInt32(&s.connCount, -1)
 	}()
 
 	for {
@@ -152,79 +252,271 @@ func (s *Server) controlSock(w http.ResponseWriter, r *http.Request) {
 		json.Unmarshal(data, &msg)
 		glog.V(2).Infof("Got control message type payload:%v", msg)
 
+		// Any message from the client keeps the watchdog happy, but only
+		// commands keep the face awake.
+		s.dev.Heartbeat()
+		if s.face != nil && msg.CmdType != PING {
+			s.face.Wake()
+		}
+
+		if err := s.checkDevice(msg.CmdType); err != nil {
+			sendError(err.Error(), c)
+			continue
+		}
+
 		switch msg.CmdType {
-		case DRIVE_FWD:
-			dur := msg.Data.(float64)
-			if err := s.dev.MotorControl(device.DRIVE_FWD, int(dur)); err != nil {
+		case DRIVE_FWD, DRIVE_BWD, DRIVE_LEFT, DRIVE_RIGHT, DRIVE_LEFT_ONLY, DRIVE_RIGHT_ONLY:
+			// Motion runs in the background so the next message (eg. brake)
+			// can preempt it.
+			speed, dur, err := driveArgs(msg.Data)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			if _, err := s.dev.StartMotor(ctx, driveDirs[msg.CmdType], speed, dur); err != nil {
 				glog.Errorf("Failed to move motor: %v", err)
 				sendError(err.Error(), c)
 			}
 
-		case DRIVE_BWD:
-			dur := msg.Data.(float64)
-			if err := s.dev.MotorControl(device.DRIVE_BWD, int(dur)); err != nil {
-				glog.Errorf("Failed to move motor: %v", err)
+		case DRIVE_DIFF:
+			left, right, dur, err := diffDriveArgs(msg.Data)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			if _, err := s.dev.StartDrive(ctx, left, right, dur); err != nil {
+				glog.Errorf("Failed to drive: %v", err)
 				sendError(err.Error(), c)
 			}
 
-		case DRIVE_LEFT:
-			dur := msg.Data.(float64)
-			if err := s.dev.MotorControl(device.DRIVE_LEFT, int(dur)); err != nil {
-				glog.Errorf("Failed to move motor: %v", err)
+		case MOVE_DISTANCE, ROTATE:
+			d, ok := msg.Data.([]interface{})
+			if !ok || len(d) != 2 {
+				sendError("move needs [amount, speed]", c)
+				continue
+			}
+			amount, ok1 := d[0].(float64)
+			speed, ok2 := d[1].(float64)
+			if !ok1 || !ok2 {
+				sendError("move needs [amount, speed]", c)
+				continue
+			}
+
+			var err error
+			if msg.CmdType == MOVE_DISTANCE {
+				_, err = s.dev.StartMoveDistance(ctx, amount, int(speed))
+			} else {
+				_, err = s.dev.StartRotate(ctx, amount, int(speed))
+			}
+			if err != nil {
+				glog.Errorf("Failed to move: %v", err)
 				sendError(err.Error(), c)
 			}
 
-		case DRIVE_LEFT_ONLY:
-			dur := msg.Data.(float64)
-			if err := s.dev.MotorControl(device.DRIVE_LEFT_ONLY, int(dur)); err != nil {
-				glog.Errorf("Failed to move motor: %v", err)
+		case SERVO_STEP:
+			// Data is the step of the default servo or [name, step].
+			name, step, err := servoArgs(msg.Data)
+			if err == nil {
+				var srv *device.NamedServo
+				if srv, err = s.dev.Servos.Get(name); err == nil {
+					err = srv.SetStep(step)
+				}
+			}
+			if err != nil {
 				sendError(err.Error(), c)
 			}
 
-		case DRIVE_RIGHT:
-			dur := msg.Data.(float64)
-			if err := s.dev.MotorControl(device.DRIVE_RIGHT, int(dur)); err != nil {
-				glog.Errorf("Failed to move motor: %v", err)
+		case SERVO_UP, SERVO_DOWN, SERVO_MOVE:
+			// SERVO_UP and SERVO_DOWN move the default servo one step.
+			name, steps := "", -1
+			if msg.CmdType == SERVO_DOWN {
+				steps = 1
+			}
+			if msg.CmdType == SERVO_MOVE {
+				var err error
+				if name, steps, err = servoArgs(msg.Data); err != nil {
+					sendError(err.Error(), c)
+					continue
+				}
+			}
+			srv, err := s.dev.Servos.Get(name)
+			if err != nil {
 				sendError(err.Error(), c)
+				continue
 			}
+			moveServos(ctx, c, func() error {
+				_, err := srv.StepBy(steps)
+				return err
+			}, srv)
 
-		case DRIVE_RIGHT_ONLY:
-			dur := msg.Data.(float64)
-			if err := s.dev.MotorControl(device.DRIVE_RIGHT_ONLY, int(dur)); err != nil {
-				glog.Errorf("Failed to move motor: %v", err)
+		case SERVO_ABS, SERVO_SET:
+			// SERVO_ABS moves the default servo.
+			name, angle, err := servoArgs(msg.Data)
+			if err != nil {
 				sendError(err.Error(), c)
+				continue
 			}
+			srv, err := s.dev.Servos.Get(name)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			moveServos(ctx, c, func() error {
+				return srv.SetAngle(angle)
+			}, srv)
 
-		case SERVO_STEP:
-			s.servoStep = int(msg.Data.(float64))
+		case SERVO_GET:
+			name, _ := msg.Data.(string)
+			if name == "" {
+				for _, srv := range s.dev.Servos.All() {
+					sendData(SERVO_ANGLE, srv.State(), c)
+				}
+				continue
+			}
+			srv, err := s.dev.Servos.Get(name)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			sendData(SERVO_ANGLE, srv.State(), c)
 
-		case SERVO_UP:
-			if err := s.dev.Servo.SetAngle(s.servoAngle - s.servoStep); err != nil {
-				glog.Errorf("Failed to move servo: %v", err)
+		case PAN_TILT:
+			pt := s.dev.Servos.PanTilt()
+			if pt == nil {
+				sendError("pan/tilt not configured", c)
+				continue
+			}
+			move := pt.Center
+			if msg.Data != nil {
+				d, ok := msg.Data.([]interface{})
+				if !ok || len(d) != 2 {
+					sendError("pan/tilt needs [pan, tilt]", c)
+					continue
+				}
+				pan, ok1 := d[0].(float64)
+				tilt, ok2 := d[1].(float64)
+				if !ok1 || !ok2 {
+					sendError("pan/tilt needs [pan, tilt]", c)
+					continue
+				}
+				move = func() error {
+					return pt.Look(int(pan), int(tilt))
+				}
+			}
+			moveServos(ctx, c, move, pt.Pan, pt.Tilt)
+
+		case SERVO_CALIB_START, SERVO_CALIB_CANCEL, SERVO_CALIBRATION:
+			name, _ := msg.Data.(string)
+			srv, err := s.dev.Servos.Get(name)
+			if err != nil {
 				sendError(err.Error(), c)
 				continue
 			}
-			s.servoAngle -= s.servoStep
+			switch msg.CmdType {
+			case SERVO_CALIB_START:
+				err = srv.StartCalibration()
+			case SERVO_CALIB_CANCEL:
+				err = srv.CancelCalibration()
+			}
+			if err != nil {
+				glog.Errorf("Failed to calibrate servo: %v", err)
+				sendError(err.Error(), c)
+			}
+			sendData(SERVO_CALIBRATION, srv.Config(), c)
 
-		case SERVO_DOWN:
-			if err := s.dev.Servo.SetAngle(s.servoAngle + s.servoStep); err != nil {
-				glog.Errorf("Failed to move servo: %v", err)
+		case SERVO_PULSE:
+			name, us, err := servoArgs(msg.Data)
+			if err != nil {
 				sendError(err.Error(), c)
 				continue
 			}
-			s.servoAngle += s.servoStep
+			srv, err := s.dev.Servos.Get(name)
+			if err == nil {
+				if us <= 0 {
+					err = fmt.Errorf("pulse needs to be positive, got %v", us)
+				} else {
+					err = srv.SetPulse(uint32(us))
+				}
+			}
+			if err != nil {
+				sendError(err.Error(), c)
+			}
 
-		case SERVO_ABS:
-			angle := int(msg.Data.(float64))
-			if angle > 180 || angle < 0 {
-				sendError("Angle needs to be 0' to 180'", c)
+		case SERVO_CALIBRATE:
+			name, cfg, err := servoConfigArgs(msg.Data)
+			if err != nil {
+				sendError(err.Error(), c)
 				continue
 			}
-			if err := s.dev.Servo.SetAngle(angle); err != nil {
-				glog.Errorf("Failed to move servo: %v", err)
+			srv, err := s.dev.Servos.Get(name)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			if err := srv.Calibrate(cfg); err != nil {
+				glog.Errorf("Failed to calibrate servo: %v", err)
+				sendError(err.Error(), c)
+				continue
+			}
+			if err := s.dev.Servos.Save(); err != nil {
+				glog.Errorf("Failed to save servo config: %v", err)
+				sendError("calibration not saved: "+err.Error(), c)
+			}
+			sendData(SERVO_CALIBRATION, srv.Config(), c)
+
+		case SERVO_MOVE_TO, SERVO_SWEEP:
+			n := 2
+			if msg.CmdType == SERVO_SWEEP {
+				n = 3
+			}
+			name, v, err := servoValues(msg.Data, n)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			srv, err := s.dev.Servos.Get(name)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			// Slow moves and sweeps run in the background so the brake and
+			// SERVO_STOP can get through.
+			move := func() error {
+				return srv.MoveTo(v[0], v[1])
+			}
+			if msg.CmdType == SERVO_SWEEP {
+				move = func() error {
+					return srv.Sweep(ctx, v[0], v[1], v[2])
+				}
+			}
+			moveServos(ctx, c, move, srv)
+
+		case SERVO_STOP, SERVO_HOLD:
+			var (
+				srv *device.NamedServo
+				err error
+			)
+			if msg.CmdType == SERVO_STOP {
+				name, _ := msg.Data.(string)
+				if srv, err = s.dev.Servos.Get(name); err == nil {
+					err = srv.Stop()
+				}
+			} else {
+				var (
+					name string
+					hold int
+				)
+				if name, hold, err = servoArgs(msg.Data); err == nil {
+					if srv, err = s.dev.Servos.Get(name); err == nil {
+						err = srv.SetHold(hold != 0)
+					}
+				}
+			}
+			if err != nil {
 				sendError(err.Error(), c)
+				continue
 			}
-			s.servoAngle = angle
+			sendData(SERVO_ANGLE, srv.State(), c)
 
 		case AUDIO_START:
 			if s.audio.IsRec() {
@@ -232,9 +524,15 @@ func (s *Server) controlSock(w http.ResponseWriter, r *http.Request) {
 				s.audio.StopRec()
 			}
 			s.audio.StartPlayback()
+			if s.face != nil {
+				s.face.SetState(device.EXPR_LISTENING, true)
+			}
 
 		case AUDIO_STOP:
 			s.audio.StopPlayback()
+			if s.face != nil {
+				s.face.SetState(device.EXPR_LISTENING, false)
+			}
 			if s.pauseRec {
 				s.pauseRec = false
 				s.audio.StartRec()
@@ -283,18 +581,288 @@ func (s *Server) controlSock(w http.ResponseWriter, r *http.Request) {
 			}
 
 		case STATUS:
-			data := []int{1, 2, 3, 4}
-			sendData(data, c)
+			sendData(STATUS, s.status(), c)
+
+		case MISSION_START:
+			steps, err := parseMission(msg.Data)
+			if err == nil {
+				err = s.mission.Start(steps)
+			}
+			if err != nil {
+				glog.Errorf("Failed to start mission: %v", err)
+				sendError(err.Error(), c)
+			}
+
+		case MISSION_PAUSE:
+			if err := s.mission.Pause(); err != nil {
+				sendError(err.Error(), c)
+			}
+
+		case MISSION_RESUME:
+			if err := s.mission.Resume(); err != nil {
+				sendError(err.Error(), c)
+			}
+
+		case MISSION_ABORT:
+			if err := s.mission.Abort(); err != nil {
+				sendError(err.Error(), c)
+			}
+
+		case MISSION_PROGRESS:
+			sendData(MISSION_PROGRESS, s.mission.Progress(), c)
+
+		case OLED_DASHBOARD:
+			mode, rotate, err := dashboardArgs(msg.Data)
+			if err != nil {
+				sendError(err.Error(), c)
+				continue
+			}
+			s.oled.SetDashboardMode(mode, rotate)
+
+		case FACE_EXPRESSION:
+			if msg.Data != nil {
+				name, d, err := faceArgs(msg.Data)
+				if err == nil {
+					err = s.face.Set(name, d)
+				}
+				if err != nil {
+					sendError(err.Error(), c)
+					continue
+				}
+			}
+			sendData(FACE_EXPRESSION, s.face.Expression(), c)
+
+		case PING:
+
+		case ODOM_RESET:
+			s.dev.ResetOdometry()
 
 		}
 
 	}
 }
 
+// parseMission returns the steps of a MISSION_START message. Data is the
+// mission as JSON or YAML text, or the decoded list of steps.
+func parseMission(data interface{}) ([]mission.Step, error) {
+	if text, ok := data.(string); ok {
+		return mission.Parse([]byte(text))
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return mission.Parse(b)
+}
+
+// status returns the status fields indexed by the Status Fields constants.
+func (s *Server) status() []int {
+	pose := s.dev.Pose()
+	tl, tr := s.dev.Ticks()
+	batt, err := s.dev.Battery()
+	if err != nil {
+		batt = -1
+	}
+	var play device.JitterStats
+	if s.audio != nil {
+		play = s.audio.PlaybackStats()
+	}
+
+	return []int{
+		AUDIO:           boolToInt(s.audio != nil && s.audio.IsRec()),
+		LOCKED:          boolToInt(s.dev.Locked()),
+		WATCHDOG:        boolToInt(s.dev.WatchdogTripped()),
+		ODOM_X:          int(math.Round(pose.X * 10)),
+		ODOM_Y:          int(math.Round(pose.Y * 10)),
+		ODOM_HEADING:    int(math.Round(pose.Heading * 180 / math.Pi)),
+		TICKS_LEFT:      int(tl),
+		TICKS_RIGHT:     int(tr),
+		BATTERY:         int(math.Round(batt)),
+		CLIENTS:         int(atomic.LoadInt32(&s.connCount)),
+		AUDIO_UNDERRUNS: play.Underruns,
+		AUDIO_OVERRUNS:  play.Overruns,
+		AUDIO_DROPPED:   play.Dropped,
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// driveArgs returns the speed percent and duration in ms of a DRIVE_*
+// message. Data is [speed, duration] or only the duration for full speed.
+func driveArgs(data interface{}) (int, int, error) {
+	switch d := data.(type) {
+	case float64:
+		return 100, int(d), nil
+	case []interface{}:
+		if len(d) == 2 {
+			speed, ok1 := d[0].(float64)
+			dur, ok2 := d[1].(float64)
+			if ok1 && ok2 {
+				return int(speed), int(dur), nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("drive needs [speed, duration], got %v", data)
+}
+
+// servoArgs returns the servo name and value of a servo message. Data is
+// [name, value] or only the value for the default servo.
+func servoArgs(data interface{}) (string, int, error) {
+	switch d := data.(type) {
+	case float64:
+		return "", int(d), nil
+	case []interface{}:
+		if len(d) == 2 {
+			name, ok1 := d[0].(string)
+			v, ok2 := d[1].(float64)
+			if ok1 && ok2 {
+				return name, int(v), nil
+			}
+		}
+	}
+	return "", 0, fmt.Errorf("servo needs [name, value], got %v", data)
+}
+
+// servoValues returns the servo name and n values of a servo message. Data
+// is [name, value...].
+func servoValues(data interface{}, n int) (string, []int, error) {
+	d, ok := data.([]interface{})
+	if !ok || len(d) != n+1 {
+		return "", nil, fmt.Errorf("servo needs name and %v values, got %v", n, data)
+	}
+	name, ok := d[0].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("servo needs name and %v values, got %v", n, data)
+	}
+	v := make([]int, n)
+	for i := range v {
+		f, ok := d[i+1].(float64)
+		if !ok {
+			return "", nil, fmt.Errorf("servo needs name and %v values, got %v", n, data)
+		}
+		v[i] = int(f)
+	}
+	return name, v, nil
+}
+
+// servoConfigArgs returns the servo name and config of a SERVO_CALIBRATE
+// message. Data is [name, config] with the ServoConfig fields.
+func servoConfigArgs(data interface{}) (string, device.ServoConfig, error) {
+	var cfg device.ServoConfig
+	d, ok := data.([]interface{})
+	if !ok || len(d) != 2 {
+		return "", cfg, fmt.Errorf("calibrate needs [name, config], got %v", data)
+	}
+	name, ok := d[0].(string)
+	if !ok {
+		return "", cfg, fmt.Errorf("calibrate needs [name, config], got %v", data)
+	}
+	b, err := json.Marshal(d[1])
+	if err != nil {
+		return "", cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return "", cfg, fmt.Errorf("invalid servo config: %v", err)
+	}
+	return name, cfg, nil
+}
+
+// diffDriveArgs returns the wheel velocities (-1 to 1) and duration in ms of
+// a DRIVE_DIFF message. Data is [left, right] in percent to drive until the
+// next command or [left, right, duration].
+func diffDriveArgs(data interface{}) (float64, float64, int, error) {
+	d, ok := data.([]interface{})
+	if !ok || len(d) < 2 || len(d) > 3 {
+		return 0, 0, 0, fmt.Errorf("drive needs [left, right, duration], got %v", data)
+	}
+	var v [3]float64
+	for i := range d {
+		if v[i], ok = d[i].(float64); !ok {
+			return 0, 0, 0, fmt.Errorf("drive needs [left, right, duration], got %v", data)
+		}
+	}
+	return v[0] / 100, v[1] / 100, int(v[2]), nil
+}
+
+// faceArgs returns the expression and duration of a FACE_EXPRESSION
+// message. Data is name or [name, seconds].
+func faceArgs(data interface{}) (string, time.Duration, error) {
+	switch d := data.(type) {
+	case string:
+		return d, 0, nil
+	case []interface{}:
+		if len(d) == 2 {
+			name, ok1 := d[0].(string)
+			secs, ok2 := d[1].(float64)
+			if ok1 && ok2 {
+				return name, time.Duration(secs * float64(time.Second)), nil
+			}
+		}
+	}
+	return "", 0, fmt.Errorf("face needs name or [name, seconds], got %v", data)
+}
+
+// checkDevice returns an error if the device needed by cmd is not enabled.
+func (s *Server) checkDevice(cmd int) error {
+	switch cmd {
+	case SERVO_UP, SERVO_DOWN, SERVO_ABS, SERVO_STEP, SERVO_SET, SERVO_MOVE, SERVO_GET, PAN_TILT,
+		SERVO_CALIB_START, SERVO_PULSE, SERVO_CALIBRATE, SERVO_CALIB_CANCEL, SERVO_CALIBRATION,
+		SERVO_MOVE_TO, SERVO_SWEEP, SERVO_STOP, SERVO_HOLD:
+		if s.dev.Servos == nil {
+			return errors.New("servo not initialized")
+		}
+	case HEADLIGHT_ON, HEADLIGHT_OFF:
+		if s.dev.Headlight == nil {
+			return errors.New("headlight not initialized")
+		}
+	case AUDIO_START, AUDIO_STOP, AUDIO_ENABLE, AUDIO_DISABLE:
+		if s.audio == nil {
+			return errors.New("audio not enabled")
+		}
+	case VIDEO_ENABLE, VIDEO_DISABLE:
+		if s.video == nil {
+			return errors.New("video not enabled")
+		}
+	case OLED_DASHBOARD:
+		if s.oled == nil {
+			return errors.New("OLED not initialized")
+		}
+	case FACE_EXPRESSION:
+		if s.face == nil {
+			return errors.New("face not enabled")
+		}
+	}
+	return nil
+}
+
+// moveServos runs move in the background so the brake and PING get through
+// while servos move at their configured speed, and then sends the angles of
+// srvs. A move preempted by a newer one is not an error.
+func moveServos(ctx context.Context, c *ctrlConn, move func() error, srvs ...*device.NamedServo) {
+	go func() {
+		err := move()
+		if ctx.Err() != nil {
+			return // Connection closed.
+		}
+		if err != nil && err != device.ErrServoPreempted {
+			glog.Errorf("Failed to move servo: %v", err)
+			sendError(err.Error(), c)
+		}
+		for _, srv := range srvs {
+			sendData(SERVO_ANGLE, srv.State(), c)
+		}
+	}()
+}
+
 // sendData constructs a data packet to send to the browser.
-func sendData(d []int, c *websocket.Conn) {
+func sendData(cmdType int, d interface{}, c *ctrlConn) {
 	msg := ControlMsg{
-		CmdType: STATUS,
+		CmdType: cmdType,
 		Data:    d,
 	}
 
@@ -310,7 +878,7 @@ func sendData(d []int, c *websocket.Conn) {
 }
 
 // sendError sends an error packet on control socket to the browser.
-func sendError(errorString string, c *websocket.Conn) {
+func sendError(errorString string, c *ctrlConn) {
 	msg := ControlMsg{
 		CmdType: ERR,
 		Data:    errorString,
@@ -327,7 +895,116 @@ func sendError(errorString string, c *websocket.Conn) {
 	}
 }
 
-// audioSock handles audio playback from browser.
+// audioHello is sent by audio websocket clients to pick the format, listing
+// the codecs they support in order of preference and their sample rate.
+// Without a rate the client uses the rates of the device.
+type audioHello struct {
+	Codecs []string
+	Rate   int
+}
+
+// audioSetup is the reply to audioHello with the codec picked and the rates
+// of the audio sent and expected.
+type audioSetup struct {
+	Codec    string
+	RecRate  int
+	PlayRate int
+	Error    string `json:",omitempty"`
+}
+
+// audioConn is the format of an audio websocket. mu also serializes writes
+// so no packet is sent in the old codec after the audioSetup reply.
+type audioConn struct {
+	mu                   sync.Mutex
+	c                    *websocket.Conn
+	enc, dec             device.AudioCodec
+	toClient, fromClient *device.Resampler
+}
+
+// send encodes pcm recorded at the device rate and sends it to the client.
+func (a *audioConn) send(pcm []int16) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.toClient != nil {
+		pcm = a.toClient.Resample(pcm)
+	}
+	packets, err := a.enc.Encode(pcm)
+	if err != nil {
+		return fmt.Errorf("failed to encode audio: %v", err)
+	}
+	for _, p := range packets {
+		if err := a.c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive returns packet from the client as pcm at the playback rate.
+func (a *audioConn) receive(packet []byte) ([]int16, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pcm, err := a.dec.Decode(packet)
+	if err != nil {
+		return nil, err
+	}
+	if a.fromClient != nil {
+		pcm = a.fromClient.Resample(pcm)
+	}
+	return pcm, nil
+}
+
+// hello switches to the format asked for by data, an audioHello, and
+// replies with an audioSetup.
+func (a *audioConn) hello(data []byte, aud *device.Audio) error {
+	recRate, playRate := aud.Rates()
+	setup := audioSetup{RecRate: recRate, PlayRate: playRate}
+	var (
+		hello                audioHello
+		enc, dec             device.AudioCodec
+		toClient, fromClient *device.Resampler
+		err                  error
+	)
+	if err := json.Unmarshal(data, &hello); err != nil {
+		setup.Error = "invalid audio handshake: " + err.Error()
+	} else if hello.Rate != 0 {
+		if toClient, fromClient, err = aud.Resamplers(hello.Rate); err != nil {
+			setup.Error = err.Error()
+		}
+		setup.RecRate, setup.PlayRate = hello.Rate, hello.Rate
+	}
+	if setup.Error == "" {
+		if enc, dec, err = audioCodecs(hello.Codecs, setup.RecRate, setup.PlayRate); err != nil {
+			setup.Error = err.Error()
+		} else {
+			setup.Codec = enc.Name()
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.c.WriteJSON(setup); err != nil {
+		return err
+	}
+	if setup.Error != "" {
+		return errors.New(setup.Error)
+	}
+	a.enc, a.dec = enc, dec
+	a.toClient, a.fromClient = toClient, fromClient
+	glog.Infof("Audio websocket using %v", enc.Name())
+	if toClient != nil {
+		from, to := fromClient.Rates()
+		glog.Infof("Audio websocket resampling %vHz to %vHz", from, to)
+	}
+	return nil
+}
+
+// audioSock streams recorded audio to the browser and plays audio from it.
+// Audio starts as raw PCM at the device rates so listen only clients need
+// not send anything. An audioHello text message switches to the codec and
+// rate picked from it.
 func (s *Server) audioSock(w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
@@ -342,25 +1019,67 @@ func (s *Server) audioSock(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close()
 
+	if s.audio == nil {
+		glog.Warning("Audio not enabled, closing audio websocket")
+		return
+	}
+
+	recRate, playRate := s.audio.Rates()
+	a := &audioConn{c: c}
+	a.enc, _ = device.NewAudioCodec(device.CODEC_PCM, recRate)
+	a.dec, _ = device.NewAudioCodec(device.CODEC_PCM, playRate)
+
 	// Send audio packets to browser.
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
 		for {
-			audData := <-s.audio.In
-			if err := c.WriteMessage(websocket.BinaryMessage, audData.Bytes()); err != nil {
-				glog.Warningf("Websocket write error:%v", err)
+			select {
+			case <-done:
 				return
+			case audData := <-s.audio.In:
+				if err := a.send(device.PCMSamples(audData.Bytes())); err != nil {
+					glog.Warningf("Audio websocket write error:%v", err)
+					return
+				}
 			}
 		}
 	}()
 
 	// Playback audio from browser.
 	for {
-		_, data, err := c.ReadMessage()
+		mt, data, err := c.ReadMessage()
 		if err != nil {
 			glog.Errorf("Audio websocket read error: %v", err)
 			return
 		}
-		b := bytes.NewBuffer(data)
-		s.audio.Out <- *b
+		if mt == websocket.TextMessage {
+			if err := a.hello(data, s.audio); err != nil {
+				glog.Warningf("Audio websocket handshake failed: %v", err)
+				return
+			}
+			continue
+		}
+		pcm, err := a.receive(data)
+		if err != nil {
+			glog.Warningf("Dropping audio packet: %v", err)
+			continue
+		}
+		s.audio.Play(pcm)
+	}
+}
+
+// audioCodecs returns the encoder and decoder of the first codec in names
+// that supports the rates.
+func audioCodecs(names []string, recRate, playRate int) (enc, dec device.AudioCodec, err error) {
+	var errs []string
+	for _, name := range names {
+		if enc, err = device.NewAudioCodec(name, recRate); err == nil {
+			if dec, err = device.NewAudioCodec(name, playRate); err == nil {
+				return enc, dec, nil
+			}
+		}
+		errs = append(errs, err.Error())
 	}
+	return nil, nil, fmt.Errorf("no supported audio codec: %v", errs)
 }
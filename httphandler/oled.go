@@ -0,0 +1,162 @@
+package httphandler
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+)
+
+// Preview scale of the OLED framebuffer. Previews are limited to
+// oledMaxPixels so big colour displays can not be scaled into huge images.
+const (
+	oledScale     = 4
+	oledMaxPixels = 2 << 20
+)
+
+// oledPNG serves the OLED framebuffer as a PNG, scaled by the scale query
+// parameter.
+func (s *Server) oledPNG(w http.ResponseWriter, r *http.Request) {
+	if s.oled == nil || s.oled.Frame() == nil {
+		http.Error(w, "OLED not initialized", http.StatusNotFound)
+		return
+	}
+	dw, dh := s.oled.Size()
+	scale, err := previewScale(r, dw, dh)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := encodeFrame(s.oled.Frame(), scale)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b)
+}
+
+// oledSock streams the OLED framebuffer as a binary PNG message on every
+// display update, scaled by the scale query parameter.
+func (s *Server) oledSock(w http.ResponseWriter, r *http.Request) {
+	var dw, dh int
+	if s.oled != nil {
+		dw, dh = s.oled.Size()
+	}
+	scale, err := previewScale(r, dw, dh)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		glog.Errorf("Failed to upgrade conn:%v", err)
+		return
+	}
+	defer c.Close()
+
+	if s.oled == nil || s.oled.Frame() == nil {
+		glog.Warning("OLED not initialized, closing OLED websocket")
+		return
+	}
+	updates, unsubscribe := s.oled.SubscribeFrames()
+	defer unsubscribe()
+
+	// The client only reads; a read error means it is gone.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func() bool {
+		b, err := encodeFrame(s.oled.Frame(), scale)
+		if err != nil {
+			glog.Errorf("Failed to encode OLED frame: %v", err)
+			return false
+		}
+		if err := c.WriteMessage(websocket.BinaryMessage, b); err != nil {
+			glog.Warningf("OLED websocket write error:%v", err)
+			return false
+		}
+		return true
+	}
+
+	// Start with the frame on screen.
+	if !send() {
+		return
+	}
+	for {
+		select {
+		case <-updates:
+			if !send() {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// previewScale returns the scale query parameter of r for a w x h display.
+// Without one the default scale is used, lowered if needed to stay within
+// oledMaxPixels.
+func previewScale(r *http.Request, w, h int) (int, error) {
+	max := maxPreviewScale(w, h)
+	v := r.URL.Query().Get("scale")
+	if v == "" {
+		if max < oledScale {
+			return max, nil
+		}
+		return oledScale, nil
+	}
+	scale, err := strconv.Atoi(v)
+	if err != nil || scale < 1 || scale > max {
+		return 0, fmt.Errorf("scale needs to be 1 to %v for a %vx%v display, got %q", max, w, h, v)
+	}
+	return scale, nil
+}
+
+// maxPreviewScale returns the largest scale of a w x h display within
+// oledMaxPixels, at least 1.
+func maxPreviewScale(w, h int) int {
+	scale := 1
+	if w <= 0 || h <= 0 {
+		return scale
+	}
+	for (scale+1)*(scale+1)*w*h <= oledMaxPixels {
+		scale++
+	}
+	return scale
+}
+
+// encodeFrame returns img scaled up by scale as a PNG.
+func encodeFrame(img *image.Gray, scale int) ([]byte, error) {
+	b := img.Bounds()
+	out := image.NewGray(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			out.Pix[y*out.Stride+x] = img.Pix[(y/scale)*img.Stride+x/scale]
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -13,6 +17,7 @@ import (
 
 	"github.com/deepakkamesh/ubiquity/device"
 	"github.com/deepakkamesh/ubiquity/httphandler"
+	"github.com/deepakkamesh/ubiquity/mission"
 	"github.com/golang/glog"
 )
 
@@ -30,19 +35,63 @@ func main() {
 		mrfwd        = flag.String("right_motor_fwd_pin", "13", "Motor controller")
 		mrbwd        = flag.String("right_motor_bwd_pin", "15", "Motor controller")
 		hlPin        = flag.String("headlight_pin", "18", "Headlight Pin")
+		servoOut     = flag.String("servo_output", "piblaster", "Servo PWM output: piblaster, sysfs or pca9685")
+		servoCfg     = flag.String("servos", "tilt:23:0:180:30", "Servos as name:pin:min:max:step,... pin is the BCM pin for pi-blaster or the channel for sysfs and pca9685")
+		servoFile    = flag.String("servo_config", "", "YAML servo config with calibration. Overrides -servos if it exists and is saved to after calibration")
+		panTilt      = flag.String("pan_tilt", "", "Names of the pan and tilt servos of the camera head eg. pan,tilt")
+		pcaAddr      = flag.Int("pca9685_address", 0x40, "PCA9685 I2C address")
+
+		motorPWM       = flag.String("motor_pwm", "none", "Motor speed control: none (on/off), piblaster or sysfs")
+		motorPWMChs    = flag.String("motor_pwm_channels", "0,1,2,3", "sysfs PWM channels for right fwd, right bwd, left fwd, left bwd")
+		motorPWMPeriod = flag.Uint("motor_pwm_period", 1000000, "sysfs PWM period in ns")
+
+		lEncPin    = flag.Int("left_encoder_pin", -1, "Left wheel encoder BCM pin. -1 disables odometry")
+		rEncPin    = flag.Int("right_encoder_pin", -1, "Right wheel encoder BCM pin. -1 disables odometry")
+		encEdge    = flag.String("encoder_edge", "both", "Encoder edges to count: rising, falling or both")
+		encTicks   = flag.Float64("encoder_ticks_per_rev", 40, "Encoder edges counted per wheel revolution")
+		wheelDia   = flag.Float64("wheel_diameter", 6.5, "Wheel diameter in cm")
+		wheelTrack = flag.Float64("wheel_track", 10, "Distance between wheels in cm")
+		calSpeed   = flag.Float64("calib_speed", 0, "Speed at full power in cm/s, times moves without encoders")
+		calTurn    = flag.Float64("calib_turn_rate", 0, "Turn rate at full power in degrees/s, times rotations without encoders")
 
 		ssl        = flag.Bool("serve_ssl", true, "Serve HTTP over ssl")
 		sslCert    = flag.String("ssl_cert", "cert.pem", "The SSL certificate in resources dir")
 		sslPrivKey = flag.String("ssl_priv_key", "privkey.pem", "SSL private Keyname in resources dir")
 		enPi       = flag.Bool("enable_pi_gpio", false, "Enable PI GPIO, I2C etc")
+		sim        = flag.Bool("sim", false, "Use the simulated rover instead of PI GPIO, I2C etc")
+		simSpeed   = flag.Float64("sim_speed", 20, "Simulated wheel speed at full power in cm/s")
+		motorRamp  = flag.Duration("motor_ramp", 0, "Time for motors to ramp from stop to full speed. 0 disables")
+		wdTimeout  = flag.Duration("watchdog_timeout", 3*time.Second, "Stop motors if the control client is silent this long. 0 disables")
+
+		dashMode   = flag.String("oled_dashboard", "off", "OLED status dashboard: off, on or rotate with the animation")
+		dashRotate = flag.Duration("oled_dashboard_rotate", 10*time.Second, "Time between dashboard and animation when rotating")
+		dispDriver = flag.String("oled_driver", "ssd1306", "Display controller: ssd1306 or sh1106 on I2C, st7735 or ili9341 on SPI")
+		dispSize   = flag.String("oled_size", "", "Display size WxH eg. 128x32. Empty uses 128x64 for OLEDs and the full TFT panel")
+		dispAddr   = flag.Int("oled_address", 0x3c, "I2C address of ssd1306 and sh1106 displays")
+		dispSPI    = flag.String("oled_spi", "/dev/spidev0.0", "SPI device of st7735 and ili9341 displays")
+		dispSPIHz  = flag.Uint("oled_spi_speed", 16000000, "SPI clock of st7735 and ili9341 displays in Hz")
+		dispDC     = flag.String("oled_dc_pin", "22", "Data/command pin of st7735 and ili9341 displays")
+		dispRST    = flag.String("oled_rst_pin", "", "Reset pin of st7735 and ili9341 displays. Empty if not wired")
+		faceDir    = flag.String("face_dir", "face", "Directory in resources dir with the face expressions, eg. listening.gif")
+		animDir    = flag.String("animations", "animations", "Directory in resources dir for animations uploaded from the web UI")
+		faceBatt   = flag.Float64("face_low_battery", 20, "Battery percent below which the face shows low battery")
+		faceSleep  = flag.Duration("face_sleep", 5*time.Minute, "Face sleeps after no control messages for this long. 0 disables")
 
 		enVid     = flag.Bool("enable_video", false, "Enable Video")
 		vidHeight = flag.Uint("vid_height", 480, "Video Height")
 		vidWidth  = flag.Uint("vid_width", 640, "Video Width")
 
-		enAud = flag.Bool("enable_audio", false, "Enable Audio")
+		enAud       = flag.Bool("enable_audio", false, "Enable Audio")
+		audRecRate  = flag.Int("audio_rec_rate", 8000, "Mic sample rate in Hz")
+		audPlayRate = flag.Int("audio_play_rate", 4000, "Speaker sample rate in Hz")
+		audRecBuf   = flag.Int("audio_rec_buf", 512, "Mic buffer size in samples")
+		audPlayBuf  = flag.Int("audio_play_buf", 740, "Speaker buffer size in samples")
+		audLatency  = flag.Duration("audio_latency", 200*time.Millisecond, "Audio buffered before playback to absorb network jitter")
+		audConceal  = flag.String("audio_conceal", device.CONCEAL_REPEAT, "Concealment of missing playback audio: silence or repeat")
 	)
 
+	// Keep the old name of -wheel_track working.
+	flag.Float64Var(wheelTrack, "sim_track", *wheelTrack, "Deprecated, use -wheel_track")
 	flag.Parse()
 	glog.Infof("Starting Ubiquity ver %s build on %s", githash, buildtime)
 
@@ -55,13 +104,63 @@ func main() {
 	}()
 
 	var (
-		motorRightBwd, motorRightFwd *gpio.DirectPinDriver
-		motorLeftBwd, motorLeftFwd   *gpio.DirectPinDriver
-		servo                        *device.Servo
+		motorRightBwd, motorRightFwd device.MotorChannel
+		motorLeftBwd, motorLeftFwd   device.MotorChannel
+		servos                       = device.NewServos()
 		oled                         *device.OLED
-		headlight                    *gpio.LedDriver
+		headlight                    device.Light
+		leftEnc, rightEnc            device.Encoder
+		battery                      device.BatterySensor
 	)
 
+	if *sim && *enPi {
+		glog.Fatalf("Only one of sim and enable_pi_gpio can be set")
+	}
+
+	servoCfgs, err := device.ParseServoConfigs(*servoCfg)
+	if err != nil {
+		glog.Fatalf("Invalid servos: %v", err)
+	}
+	if *servoFile != "" {
+		cfgs, err := device.LoadServoConfigs(*servoFile)
+		switch {
+		case err == nil:
+			servoCfgs = cfgs
+		case os.IsNotExist(err):
+			glog.Infof("Servo config %v not found, using -servos", *servoFile)
+		default:
+			glog.Fatalf("Failed to load servo config: %v", err)
+		}
+		servos.SetConfigFile(*servoFile)
+	}
+
+	if *sim {
+		glog.Info("Running simulated rover")
+		rover := device.NewSimRover(*simSpeed, *wheelTrack)
+		leftEnc, rightEnc = rover.Encoders(*encTicks, *wheelDia)
+		motorRightFwd, motorRightBwd = rover.RightFwd, rover.RightBwd
+		motorLeftFwd, motorLeftBwd = rover.LeftFwd, rover.LeftBwd
+		for _, cfg := range servoCfgs {
+			srv, err := servos.Add(cfg, device.NewSimServo())
+			if err != nil {
+				glog.Fatalf("Failed to setup servo: %v", err)
+			}
+			srv.Center()
+		}
+		headlight = device.NewSimLight()
+		battery = device.NewSimBattery(100)
+
+		// Simulated display with the same geometry as the SSD1306.
+		oled = device.NewOLED()
+		var mut sync.Mutex
+		if err := oled.InitDisplay(device.NewSimDisplay(128, 64), &mut); err != nil {
+			glog.Fatalf("Failed to initialize OLED:%v", err)
+		}
+		if err := oled.Run(); err != nil {
+			glog.Fatalf("Failed to start OLED:%v", err)
+		}
+	}
+
 	if *enPi {
 		// Initialize PI Adaptor.
 		pi := raspi.NewAdaptor()
@@ -69,63 +168,235 @@ func main() {
 			glog.Fatalf("Failed to initialize Adapter:%v", err)
 		}
 
-		// Initialize motor devices.
-		motorRightFwd = gpio.NewDirectPinDriver(pi, *mrfwd)
-		if err := motorRightFwd.Start(); err != nil {
-			glog.Fatalf("Failed to setup GPIO: %v", err)
-		}
+		// Initialize motor devices in the order right fwd, right bwd, left fwd, left bwd.
+		var motors [4]device.MotorChannel
+		switch *motorPWM {
+		case "none", "piblaster":
+			for i, pin := range []string{*mrfwd, *mrbwd, *mlfwd, *mlbwd} {
+				drv := gpio.NewDirectPinDriver(pi, pin)
+				if err := drv.Start(); err != nil {
+					glog.Fatalf("Failed to setup GPIO: %v", err)
+				}
+				motors[i] = drv
+				if *motorPWM == "none" {
+					motors[i] = device.NewOnOffChannel(drv)
+				}
+			}
 
-		motorRightBwd = gpio.NewDirectPinDriver(pi, *mrbwd)
-		if err := motorRightBwd.Start(); err != nil {
-			glog.Fatalf("Failed to setup GPIO: %v", err)
-		}
+		case "sysfs":
+			chs := strings.Split(*motorPWMChs, ",")
+			if len(chs) != len(motors) {
+				glog.Fatalf("Need %v sysfs PWM channels, got %q", len(motors), *motorPWMChs)
+			}
+			for i, ch := range chs {
+				n, err := strconv.Atoi(strings.TrimSpace(ch))
+				if err != nil {
+					glog.Fatalf("Invalid sysfs PWM channel %q: %v", ch, err)
+				}
+				if motors[i], err = device.NewSysfsPWMChannel(n, uint32(*motorPWMPeriod)); err != nil {
+					glog.Fatalf("Failed to setup PWM: %v", err)
+				}
+			}
 
-		motorLeftFwd = gpio.NewDirectPinDriver(pi, *mlfwd)
-		if err := motorRightFwd.Start(); err != nil {
-			glog.Fatalf("Failed to setup GPIO: %v", err)
+		default:
+			glog.Fatalf("Unknown motor_pwm %q", *motorPWM)
 		}
+		motorRightFwd, motorRightBwd, motorLeftFwd, motorLeftBwd = motors[0], motors[1], motors[2], motors[3]
 
-		motorLeftBwd = gpio.NewDirectPinDriver(pi, *mlbwd)
-		if err := motorRightBwd.Start(); err != nil {
-			glog.Fatalf("Failed to setup GPIO: %v", err)
+		// Initialize wheel encoders. Pin is the BCMxx
+		if *lEncPin >= 0 && *rEncPin >= 0 {
+			l, err := device.NewGPIOEncoder(*lEncPin, *encEdge)
+			if err != nil {
+				glog.Fatalf("Failed to setup encoder: %v", err)
+			}
+			r, err := device.NewGPIOEncoder(*rEncPin, *encEdge)
+			if err != nil {
+				glog.Fatalf("Failed to setup encoder: %v", err)
+			}
+			leftEnc, rightEnc = l, r
 		}
 
-		// Initialize Servo. Pin is the BCMxx
-		servo = device.NewServo(20000, "23", pi)
-		servo.SetAngle(90)
+		// Initialize Servos.
+		var board *device.PCA9685
+		if *servoOut == "pca9685" {
+			var err error
+			if board, err = device.NewPCA9685(pi, 1, *pcaAddr, 50); err != nil {
+				glog.Fatalf("Failed to setup PCA9685: %v", err)
+			}
+		}
+		for _, cfg := range servoCfgs {
+			var out device.ServoOutput
+			switch *servoOut {
+			case "piblaster":
+				out = device.NewPiBlasterOutput(cfg.Pin, 20000)
+			case "sysfs", "pca9685":
+				ch, err := strconv.Atoi(cfg.Pin)
+				if err != nil {
+					glog.Fatalf("Invalid channel for servo %v: %v", cfg.Name, err)
+				}
+				if board != nil {
+					out, err = board.Output(ch)
+				} else {
+					out, err = device.NewSysfsPWMOutput(ch, 20000)
+				}
+				if err != nil {
+					glog.Fatalf("Failed to setup servo %v: %v", cfg.Name, err)
+				}
+			default:
+				glog.Fatalf("Unknown servo_output %q", *servoOut)
+			}
+			srv, err := servos.Add(cfg, device.NewServo(out))
+			if err != nil {
+				glog.Fatalf("Failed to setup servo: %v", err)
+			}
+			if err := srv.Center(); err != nil {
+				glog.Errorf("Failed to center servo %v: %v", cfg.Name, err)
+			}
+		}
 
 		// Initialize headlight.
-		headlight = gpio.NewLedDriver(pi, *hlPin)
-		if err := headlight.Start(); err != nil {
+		hl := gpio.NewLedDriver(pi, *hlPin)
+		if err := hl.Start(); err != nil {
 			glog.Fatalf("Failed to setup headlight")
 		}
+		headlight = hl
 
-		// Initialize I2C OLED display and load some default image.
+		// Initialize the OLED or TFT display. 0x0 uses the controller default.
+		var w, h int
+		if *dispSize != "" {
+			if _, err := fmt.Sscanf(*dispSize, "%dx%d", &w, &h); err != nil {
+				glog.Fatalf("Invalid oled_size %q: %v", *dispSize, err)
+			}
+		}
+		var (
+			disp device.Display
+			err  error
+		)
+		switch *dispDriver {
+		case "ssd1306":
+			if w == 0 {
+				w, h = 128, 64
+			}
+			disp, err = device.NewSSD1306(pi, 1, *dispAddr, w, h)
+		case "sh1106":
+			if w == 0 {
+				w, h = 128, 64
+			}
+			disp, err = device.NewSH1106(pi, 1, *dispAddr, w, h)
+		case "st7735", "ili9341":
+			model := device.TFT_ST7735
+			if *dispDriver == "ili9341" {
+				model = device.TFT_ILI9341
+			}
+			dc := gpio.NewDirectPinDriver(pi, *dispDC)
+			if err := dc.Start(); err != nil {
+				glog.Fatalf("Failed to setup GPIO: %v", err)
+			}
+			var rst device.DigitalPin
+			if *dispRST != "" {
+				drv := gpio.NewDirectPinDriver(pi, *dispRST)
+				if err := drv.Start(); err != nil {
+					glog.Fatalf("Failed to setup GPIO: %v", err)
+				}
+				rst = drv
+			}
+			spi, serr := device.OpenSPIDev(*dispSPI, uint32(*dispSPIHz))
+			if serr != nil {
+				glog.Fatalf("Failed to open SPI: %v", serr)
+			}
+			disp, err = device.NewTFT(model, spi, dc, rst, w, h)
+		default:
+			glog.Fatalf("Unknown oled_driver %q", *dispDriver)
+		}
+		if err != nil {
+			glog.Fatalf("Failed to initialize display:%v", err)
+		}
 		oled = device.NewOLED()
 		var mut sync.Mutex
-		if err := oled.InitOLED(pi, 1, 0x3c, &mut, "display"); err != nil {
+		if err := oled.InitDisplay(disp, &mut); err != nil {
 			glog.Fatalf("Failed to initialize OLED:%v", err)
 		}
 		if err := oled.Run(); err != nil {
 			glog.Fatalf("Failed to start OLED:%v", err)
 		}
-		img, err := device.LoadImages(*res + "/walle_smile_medium.png")
-		if err != nil {
-			glog.Errorf("Failed to load display image:%v", err)
+	}
+
+	// Setup the pan/tilt camera head.
+	if *panTilt != "" {
+		names := strings.Split(*panTilt, ",")
+		if len(names) != 2 {
+			glog.Fatalf("pan_tilt needs pan,tilt servo names, got %q", *panTilt)
+		}
+		if err := servos.SetPanTilt(names[0], names[1]); err != nil {
+			glog.Fatalf("Failed to setup pan/tilt: %v", err)
 		}
-		oled.Animate(img, 500)
 	}
 
 	// Initialize new Ubiquity Device.
-	dev := device.New(motorRightFwd, motorRightBwd, motorLeftFwd, motorLeftBwd, servo, headlight)
+	dev := device.New(motorRightFwd, motorRightBwd, motorLeftFwd, motorLeftBwd, servos, headlight)
+	if *wdTimeout < 0 {
+		glog.Fatalf("watchdog_timeout needs to be positive or 0, got %v", *wdTimeout)
+	}
+	if *wdTimeout > 0 {
+		if err := dev.StartWatchdog(*wdTimeout); err != nil {
+			glog.Fatalf("Failed to start watchdog: %v", err)
+		}
+	}
+	if *motorRamp > 0 {
+		dev.SetRamp(*motorRamp)
+	}
+	if battery != nil {
+		dev.SetBattery(battery)
+	}
+	dev.SetCalibration(device.MotionCalibration{
+		Speed:    *calSpeed,
+		TurnRate: *calTurn,
+	})
+	if leftEnc != nil {
+		if err := dev.SetOdometry(leftEnc, rightEnc, device.OdometryConfig{
+			TicksPerRev:   *encTicks,
+			WheelDiameter: *wheelDia,
+			Track:         *wheelTrack,
+		}); err != nil {
+			glog.Fatalf("Failed to start odometry: %v", err)
+		}
+	}
+
+	// Setup the OLED face. Expressions missing from face_dir show the smile.
+	var (
+		face    *device.Face
+		animLib *device.AnimationLibrary
+	)
+	if oled != nil {
+		face = device.NewFace(oled, dev, *faceBatt, *faceSleep)
+		smile, err := device.LoadAnimation(500, 0, 0, *res+"/walle_smile_medium.png")
+		if err != nil {
+			glog.Errorf("Failed to load display image:%v", err)
+		} else {
+			face.SetDefault(smile)
+		}
+		if err := face.Load(filepath.Join(*res, *faceDir), 500); err != nil {
+			glog.Errorf("Failed to load face: %v", err)
+		}
+		// Animations activated from the web UI override the face_dir ones.
+		if animLib, err = device.OpenAnimationLibrary(filepath.Join(*res, *animDir)); err != nil {
+			glog.Errorf("Failed to open animations: %v", err)
+		} else if err := animLib.Apply(face); err != nil {
+			glog.Errorf("Failed to load animations: %v", err)
+		}
+		face.Run()
+	}
 
 	// Initialize audio device.
 	var aud *device.Audio
 	if *enAud {
 		aud = device.NewAudio()
-		if err := aud.Init(512, 740, 8000, 4000); err != nil {
+		if err := aud.Init(*audRecBuf, *audPlayBuf, float64(*audRecRate), float64(*audPlayRate)); err != nil {
 			glog.Fatalf("Unable to initialize audio:%v", err)
 		}
+		if err := aud.SetJitterBuffer(*audLatency, *audConceal); err != nil {
+			glog.Fatalf("Invalid audio jitter buffer:%v", err)
+		}
 	}
 
 	// Initialize video device.
@@ -148,7 +419,24 @@ func main() {
 	}()
 
 	// Startup HTTP service.
-	h := httphandler.New(dev, aud, vid)
+	mis := mission.NewRunner(dev, oled, vid, *res)
+	h := httphandler.New(dev, aud, vid, oled, face, mis)
+	if animLib != nil {
+		h.SetAnimations(animLib)
+	}
+	if oled != nil {
+		modes := map[string]int{
+			"off":    device.DASHBOARD_OFF,
+			"on":     device.DASHBOARD_ON,
+			"rotate": device.DASHBOARD_ROTATE,
+		}
+		mode, ok := modes[*dashMode]
+		if !ok {
+			glog.Fatalf("Unknown oled_dashboard %q", *dashMode)
+		}
+		oled.SetDashboard(h.Dashboard)
+		oled.SetDashboardMode(mode, *dashRotate)
+	}
 	if err := h.Start(*httpHostPort, *res, *sslCert, *sslPrivKey, *ssl); err != nil {
 		glog.Fatalf("Failed to start HTTP: %v", err)
 	}
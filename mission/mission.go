@@ -0,0 +1,163 @@
+// Package mission runs scripted sequences of rover commands.
+package mission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/deepakkamesh/ubiquity/device"
+	"gopkg.in/yaml.v2"
+)
+
+// Step actions.
+const (
+	DRIVE     = "drive"     // Distance (cm) at Speed, or Left/Right (-100 to 100) for Duration.
+	TURN      = "turn"      // Angle degrees in place at Speed. Positive is counter clockwise.
+	SERVO     = "servo"     // Move Servo (default servo if empty) to Angle.
+	HEADLIGHT = "headlight" // Switch headlight On.
+	SNAPSHOT  = "snapshot"  // Save a video frame to File and Show it on the OLED.
+	WAIT      = "wait"      // Wait for Duration.
+	OLED      = "oled"      // Animate Images (PNG frames, a GIF or a sprite sheet) with Delay between frames and/or show Text.
+)
+
+// Resumed drives and turns shorter than these are done.
+const (
+	minDistance = 0.1 // cm.
+	minAngle    = 0.5 // Degrees.
+)
+
+// Step is one command of a mission. Only the fields used by the action need
+// to be set.
+type Step struct {
+	Action   string   `json:"action" yaml:"action"`
+	Distance float64  `json:"distance,omitempty" yaml:"distance,omitempty"` // cm, negative is backward.
+	Left     float64  `json:"left,omitempty" yaml:"left,omitempty"`         // Left wheel percent.
+	Right    float64  `json:"right,omitempty" yaml:"right,omitempty"`       // Right wheel percent.
+	Speed    int      `json:"speed,omitempty" yaml:"speed,omitempty"`       // Percent.
+	Servo    string   `json:"servo,omitempty" yaml:"servo,omitempty"`       // Servo name.
+	Angle    float64  `json:"angle,omitempty" yaml:"angle,omitempty"`       // Degrees.
+	Duration int      `json:"duration,omitempty" yaml:"duration,omitempty"` // ms.
+	On       bool     `json:"on,omitempty" yaml:"on,omitempty"`
+	File     string   `json:"file,omitempty" yaml:"file,omitempty"`
+	Images   []string `json:"images,omitempty" yaml:"images,omitempty"`             // Relative to the resources dir.
+	Delay    uint     `json:"delay,omitempty" yaml:"delay,omitempty"`               // ms.
+	FrameW   int      `json:"frame_width,omitempty" yaml:"frame_width,omitempty"`   // Sprite sheet frame width.
+	FrameH   int      `json:"frame_height,omitempty" yaml:"frame_height,omitempty"` // Sprite sheet frame height.
+	Text     string   `json:"text,omitempty" yaml:"text,omitempty"`                 // OLED text, scrolled if it does not fit.
+	Scale    string   `json:"scale,omitempty" yaml:"scale,omitempty"`               // OLED image scaling: none, fit or fill.
+	Convert  string   `json:"convert,omitempty" yaml:"convert,omitempty"`           // OLED pixel conversion: alpha, threshold, floyd-steinberg or ordered.
+	Show     bool     `json:"show,omitempty" yaml:"show,omitempty"`                 // Show the snapshot on the OLED.
+}
+
+// Parse decodes a JSON or YAML list of steps and validates them.
+func Parse(data []byte) ([]Step, error) {
+	var steps []Step
+
+	if d := bytes.TrimSpace(data); len(d) > 0 && d[0] == '[' {
+		if err := json.Unmarshal(d, &steps); err != nil {
+			return nil, fmt.Errorf("invalid mission json: %v", err)
+		}
+	} else if err := yaml.UnmarshalStrict(data, &steps); err != nil {
+		return nil, fmt.Errorf("invalid mission yaml: %v", err)
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("mission has no steps")
+	}
+	for i, st := range steps {
+		if err := st.validate(); err != nil {
+			return nil, fmt.Errorf("step %v: %v", i+1, err)
+		}
+	}
+	return steps, nil
+}
+
+func (st Step) validate() error {
+	switch st.Action {
+	case DRIVE:
+		if st.Distance != 0 {
+			return checkSpeed(st.Speed)
+		}
+		if st.Duration <= 0 {
+			return fmt.Errorf("drive needs distance or duration")
+		}
+		if st.Left < -100 || st.Left > 100 || st.Right < -100 || st.Right > 100 {
+			return fmt.Errorf("left and right need to be -100 to 100")
+		}
+
+	case TURN:
+		if st.Angle == 0 {
+			return fmt.Errorf("turn needs angle")
+		}
+		return checkSpeed(st.Speed)
+
+	case SERVO:
+		if st.Angle < 0 || st.Angle > 180 {
+			return fmt.Errorf("Angle needs to be 0 to 180, got %v", st.Angle)
+		}
+
+	case WAIT:
+		if st.Duration <= 0 {
+			return fmt.Errorf("wait needs duration")
+		}
+
+	case OLED:
+		if st.Text == "" && len(st.Images) == 0 {
+			return fmt.Errorf("oled needs images or text")
+		}
+		_, err := st.imageOptions()
+		return err
+
+	case SNAPSHOT:
+		_, err := st.imageOptions()
+		return err
+
+	case HEADLIGHT:
+
+	default:
+		return fmt.Errorf("unknown action %q", st.Action)
+	}
+	return nil
+}
+
+// remaining returns the part of the step left after done (0 to 1) of it
+// ran and false if nothing is left. Steps that are not moves or waits are
+// run again.
+func (st Step) remaining(done float64) (Step, bool) {
+	if done <= 0 {
+		return st, true
+	}
+	if done >= 1 {
+		return st, false
+	}
+	left := 1 - done
+	switch st.Action {
+	case DRIVE:
+		if st.Distance != 0 {
+			st.Distance *= left
+			return st, math.Abs(st.Distance) >= minDistance
+		}
+		fallthrough
+	case WAIT:
+		st.Duration = int(float64(st.Duration) * left)
+		return st, st.Duration > 0
+	case TURN:
+		st.Angle *= left
+		return st, math.Abs(st.Angle) >= minAngle
+	}
+	return st, true
+}
+
+// imageOptions returns the OLED image options of the step.
+func (st Step) imageOptions() (device.ImageOptions, error) {
+	return device.ParseImageOptions(st.Scale, st.Convert)
+}
+
+func checkSpeed(speed int) error {
+	if speed <= 0 || speed > 100 {
+		return fmt.Errorf("speed needs to be 1 to 100, got %v", speed)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package mission
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Step
+		wantErr string
+	}{
+		{
+			name: "json",
+			data: `[{"action": "drive", "distance": 20, "speed": 50}, {"action": "turn", "angle": -90, "speed": 30}]`,
+			want: []Step{
+				{Action: DRIVE, Distance: 20, Speed: 50},
+				{Action: TURN, Angle: -90, Speed: 30},
+			},
+		},
+		{
+			name: "yaml",
+			data: `
+- action: drive
+  left: 50
+  right: -50
+  duration: 1000
+- action: servo
+  servo: pan
+  angle: 45
+- action: headlight
+  on: true
+- action: wait
+  duration: 500
+- action: oled
+  text: hello
+  images: [face.gif]
+  delay: 100
+  scale: fit
+  convert: ordered
+- action: snapshot
+  file: snap.jpg
+  show: true
+`,
+			want: []Step{
+				{Action: DRIVE, Left: 50, Right: -50, Duration: 1000},
+				{Action: SERVO, Servo: "pan", Angle: 45},
+				{Action: HEADLIGHT, On: true},
+				{Action: WAIT, Duration: 500},
+				{Action: OLED, Text: "hello", Images: []string{"face.gif"}, Delay: 100, Scale: "fit", Convert: "ordered"},
+				{Action: SNAPSHOT, File: "snap.jpg", Show: true},
+			},
+		},
+		{
+			name: "json with leading space",
+			data: "\n  [{\"action\": \"wait\", \"duration\": 1}]",
+			want: []Step{{Action: WAIT, Duration: 1}},
+		},
+		{name: "empty", data: "", wantErr: "no steps"},
+		{name: "empty list", data: "[]", wantErr: "no steps"},
+		{name: "bad json", data: `[{"action": }]`, wantErr: "invalid mission json"},
+		{name: "unknown yaml field", data: "- action: wait\n  duration: 1\n  speeed: 2\n", wantErr: "invalid mission yaml"},
+		{name: "unknown action", data: "- action: fly\n", wantErr: `step 1: unknown action "fly"`},
+		{name: "drive without distance or duration", data: "- action: drive\n  speed: 50\n", wantErr: "drive needs distance or duration"},
+		{name: "drive distance without speed", data: "- action: drive\n  distance: 10\n", wantErr: "speed needs to be 1 to 100"},
+		{name: "drive wheel out of range", data: "- action: drive\n  left: 101\n  duration: 10\n", wantErr: "left and right"},
+		{name: "turn without angle", data: "- action: turn\n  speed: 50\n", wantErr: "turn needs angle"},
+		{name: "turn too fast", data: "- action: turn\n  angle: 90\n  speed: 101\n", wantErr: "speed needs to be 1 to 100"},
+		{name: "servo out of range", data: "- action: servo\n  angle: 181\n", wantErr: "Angle needs to be 0 to 180"},
+		{name: "wait without duration", data: "- action: wait\n", wantErr: "wait needs duration"},
+		{name: "oled without content", data: "- action: oled\n", wantErr: "oled needs images or text"},
+		{name: "oled bad scale", data: "- action: oled\n  text: hi\n  scale: huge\n", wantErr: "scale"},
+		{name: "snapshot bad convert", data: "- action: snapshot\n  convert: blur\n", wantErr: "convert"},
+		{name: "second step invalid", data: "- action: wait\n  duration: 1\n- action: wait\n", wantErr: "step 2:"},
+	}
+	for _, tc := range tests {
+		got, err := Parse([]byte(tc.data))
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%v: Parse error = %v, want %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: Parse: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: Parse = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	tests := []struct {
+		name     string
+		st       Step
+		done     float64
+		want     Step
+		wantLeft bool
+	}{
+		{"not started", Step{Action: DRIVE, Distance: 40, Speed: 50}, 0, Step{Action: DRIVE, Distance: 40, Speed: 50}, true},
+		{"finished", Step{Action: DRIVE, Distance: 40, Speed: 50}, 1, Step{Action: DRIVE, Distance: 40, Speed: 50}, false},
+		{"drive distance", Step{Action: DRIVE, Distance: -40, Speed: 50}, 0.25, Step{Action: DRIVE, Distance: -30, Speed: 50}, true},
+		{"drive duration", Step{Action: DRIVE, Left: 50, Right: 50, Duration: 1000}, 0.75, Step{Action: DRIVE, Left: 50, Right: 50, Duration: 250}, true},
+		{"drive duration rounds to nothing", Step{Action: DRIVE, Left: 50, Duration: 10}, 0.95, Step{Action: DRIVE, Left: 50}, false},
+		{"turn", Step{Action: TURN, Angle: 90, Speed: 30}, 0.5, Step{Action: TURN, Angle: 45, Speed: 30}, true},
+		{"drive distance too short to resume", Step{Action: DRIVE, Distance: 40, Speed: 50}, 1 - 1.0/1024, Step{Action: DRIVE, Distance: 40.0 / 1024, Speed: 50}, false},
+		{"turn too small to resume", Step{Action: TURN, Angle: -90, Speed: 30}, 1 - 1.0/1024, Step{Action: TURN, Angle: -90.0 / 1024, Speed: 30}, false},
+		{"turn just big enough to resume", Step{Action: TURN, Angle: 64, Speed: 30}, 1 - 1.0/128, Step{Action: TURN, Angle: 0.5, Speed: 30}, true},
+		{"wait", Step{Action: WAIT, Duration: 2000}, 0.5, Step{Action: WAIT, Duration: 1000}, true},
+		{"servo runs again", Step{Action: SERVO, Angle: 30}, 0.5, Step{Action: SERVO, Angle: 30}, true},
+	}
+	for _, tc := range tests {
+		got, left := tc.st.remaining(tc.done)
+		if left != tc.wantLeft || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: remaining(%v) = %+v, %v, want %+v, %v", tc.name, tc.done, got, left, tc.want, tc.wantLeft)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package mission
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/deepakkamesh/ubiquity/device"
+	"github.com/golang/glog"
+)
+
+// Mission states.
+const (
+	IDLE    = "idle"
+	RUNNING = "running"
+	PAUSED  = "paused"
+	DONE    = "done"
+	ABORTED = "aborted"
+	FAILED  = "failed"
+)
+
+// Progress is the state of the current mission.
+type Progress struct {
+	State  string
+	Step   int // 1 based index of the current step.
+	Steps  int
+	Action string
+	Error  string `json:",omitempty"`
+}
+
+// Runner runs one mission at a time against the rover.
+type Runner struct {
+	dev     *device.Ubiquity
+	oled    *device.OLED
+	video   *device.Video
+	resPath string // Images are loaded from and snapshots saved to here.
+
+	mu       sync.Mutex
+	progress Progress
+	abort    context.CancelFunc // Aborts the mission.
+	stopStep context.CancelFunc // Interrupts the running step.
+	resume   chan struct{}      // Closed to resume a paused mission.
+	subs     map[chan Progress]struct{}
+}
+
+// NewRunner returns a mission runner. oled and video may be nil if the
+// rover does not have them.
+func NewRunner(dev *device.Ubiquity, oled *device.OLED, video *device.Video, resPath string) *Runner {
+	return &Runner{
+		dev:      dev,
+		oled:     oled,
+		video:    video,
+		resPath:  resPath,
+		progress: Progress{State: IDLE},
+		subs:     make(map[chan Progress]struct{}),
+	}
+}
+
+// Subscribe returns a channel of progress updates. Call the returned func
+// to unsubscribe. Updates are dropped if the channel is not drained.
+func (r *Runner) Subscribe() (<-chan Progress, func()) {
+	ch := make(chan Progress, 16)
+
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.subs, ch)
+		r.mu.Unlock()
+	}
+}
+
+// Progress returns the state of the current or last mission.
+func (r *Runner) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+// Start runs steps in the background. Engaging the brake aborts the mission.
+func (r *Runner) Start(steps []Step) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.progress.State == RUNNING || r.progress.State == PAUSED {
+		return errors.New("mission already running")
+	}
+	if r.dev.Locked() {
+		return errors.New("brake engaged")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.abort = cancel
+	r.resume = nil
+	r.update(Progress{State: RUNNING, Step: 1, Steps: len(steps), Action: steps[0].Action})
+
+	glog.Infof("Starting mission with %v steps", len(steps))
+	go r.run(ctx, steps)
+	return nil
+}
+
+// Pause stops the rover right away. The interrupted step continues with
+// what is left of it on Resume.
+func (r *Runner) Pause() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.progress.State != RUNNING {
+		return errors.New("mission not running")
+	}
+	r.resume = make(chan struct{})
+	if r.stopStep != nil {
+		r.stopStep()
+	}
+	p := r.progress
+	p.State = PAUSED
+	r.update(p)
+	glog.Infof("Paused mission at step %v", p.Step)
+	return nil
+}
+
+// Resume continues a paused mission.
+func (r *Runner) Resume() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.progress.State != PAUSED {
+		return errors.New("mission not paused")
+	}
+	close(r.resume)
+	r.resume = nil
+	p := r.progress
+	p.State = RUNNING
+	r.update(p)
+	glog.Infof("Resumed mission at step %v", p.Step)
+	return nil
+}
+
+// Abort stops the mission.
+func (r *Runner) Abort() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.progress.State != RUNNING && r.progress.State != PAUSED {
+		return errors.New("mission not running")
+	}
+	r.abort()
+	return nil
+}
+
+// update sets and broadcasts progress. Caller must hold r.mu.
+func (r *Runner) update(p Progress) {
+	r.progress = p
+	for ch := range r.subs {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// finish ends the mission in state with err.
+func (r *Runner) finish(state string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := r.progress
+	p.State = state
+	if err != nil {
+		p.Error = err.Error()
+	}
+	r.abort()
+	r.stopStep = nil
+	r.update(p)
+	glog.Infof("Mission %v at step %v %v", state, p.Step, p.Error)
+}
+
+func (r *Runner) run(ctx context.Context, steps []Step) {
+	brake := r.dev.BrakeEngaged()
+
+	st := steps[0] // What is left of step i.
+	for i := 0; i < len(steps); {
+		// Wait while paused.
+		r.mu.Lock()
+		resume := r.resume
+		r.mu.Unlock()
+		if resume != nil {
+			select {
+			case <-resume:
+			case <-brake:
+				r.finish(ABORTED, errors.New("brake engaged"))
+				return
+			case <-ctx.Done():
+				r.finish(ABORTED, nil)
+				return
+			}
+		}
+
+		stepCtx, stop := context.WithCancel(ctx)
+		r.mu.Lock()
+		if r.resume != nil {
+			// Paused again before the step started.
+			r.mu.Unlock()
+			stop()
+			continue
+		}
+		r.stopStep = stop
+		p := r.progress
+		p.Step, p.Action = i+1, steps[i].Action
+		r.update(p)
+		r.mu.Unlock()
+
+		type result struct {
+			done float64
+			err  error
+		}
+		resCh := make(chan result, 1)
+		go func(st Step) {
+			done, err := r.exec(stepCtx, st)
+			resCh <- result{done, err}
+		}(st)
+
+		var res result
+		select {
+		case res = <-resCh:
+		case <-brake:
+			stop()
+			res = <-resCh
+		}
+		stop()
+
+		// The brake preempts motions so check it before step errors.
+		select {
+		case <-brake:
+			r.finish(ABORTED, errors.New("brake engaged"))
+			return
+		default:
+		}
+		if ctx.Err() != nil {
+			r.finish(ABORTED, nil)
+			return
+		}
+
+		// Continue the interrupted step after resume.
+		r.mu.Lock()
+		paused := r.resume != nil
+		r.mu.Unlock()
+		if paused {
+			var left bool
+			if st, left = st.remaining(res.done); left {
+				glog.V(1).Infof("Step %v paused at %.0f%%", i+1, res.done*100)
+				continue
+			}
+		} else if res.err != nil {
+			r.finish(FAILED, res.err)
+			return
+		}
+		if i++; i < len(steps) {
+			st = steps[i]
+		}
+	}
+	r.finish(DONE, nil)
+}
+
+// exec runs a single step until it completes or ctx is done and returns
+// how much of it ran from 0 to 1.
+func (r *Runner) exec(ctx context.Context, st Step) (float64, error) {
+	glog.V(2).Infof("Running mission step %+v", st)
+
+	switch st.Action {
+	case DRIVE:
+		var (
+			m   *device.Motion
+			err error
+		)
+		if st.Distance != 0 {
+			m, err = r.dev.StartMoveDistance(ctx, st.Distance, st.Speed)
+		} else {
+			m, err = r.dev.StartDrive(ctx, st.Left/100, st.Right/100, st.Duration)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return waitMotion(m)
+
+	case TURN:
+		m, err := r.dev.StartRotate(ctx, st.Angle, st.Speed)
+		if err != nil {
+			return 0, err
+		}
+		return waitMotion(m)
+
+	case WAIT:
+		d := time.Duration(st.Duration) * time.Millisecond
+		start := time.Now()
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return 1, nil
+		case <-ctx.Done():
+			return float64(time.Since(start)) / float64(d), ctx.Err()
+		}
+	}
+
+	if err := r.execOnce(st); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// execOnce runs a step that completes right away.
+func (r *Runner) execOnce(st Step) error {
+	switch st.Action {
+	case SERVO:
+		if r.dev.Servos == nil {
+			return errors.New("servo not initialized")
+		}
+		srv, err := r.dev.Servos.Get(st.Servo)
+		if err != nil {
+			return err
+		}
+		return srv.SetAngle(int(st.Angle))
+
+	case HEADLIGHT:
+		if r.dev.Headlight == nil {
+			return errors.New("headlight not initialized")
+		}
+		if st.On {
+			return r.dev.Headlight.On()
+		}
+		return r.dev.Headlight.Off()
+
+	case SNAPSHOT:
+		if r.video == nil {
+			return errors.New("video not enabled")
+		}
+		img, err := r.video.Snapshot()
+		if err != nil {
+			return err
+		}
+		name := st.File
+		if name == "" {
+			name = fmt.Sprintf("snapshot_%v.jpg", time.Now().Format("20060102_150405"))
+		}
+		if err := ioutil.WriteFile(filepath.Join(r.resPath, filepath.Base(name)), img, 0644); err != nil {
+			return err
+		}
+		if !st.Show {
+			return nil
+		}
+		if r.oled == nil {
+			return errors.New("OLED not initialized")
+		}
+		thumb, err := jpeg.Decode(bytes.NewReader(img))
+		if err != nil {
+			return fmt.Errorf("failed to decode snapshot: %v", err)
+		}
+		// Photos need scaling and dithering to show on the OLED.
+		o, err := st.imageOptions()
+		if err != nil {
+			return err
+		}
+		if st.Scale == "" {
+			o.Scale, o.Center = device.SCALE_FIT, true
+		}
+		if st.Convert == "" {
+			o.Convert = device.CONVERT_FLOYD_STEINBERG
+		}
+		r.oled.ShowImage(thumb, o)
+		return nil
+
+	case OLED:
+		if r.oled == nil {
+			return errors.New("OLED not initialized")
+		}
+		if len(st.Images) > 0 {
+			files := make([]string, len(st.Images))
+			for i, img := range st.Images {
+				files[i] = filepath.Join(r.resPath, filepath.Clean("/"+img))
+			}
+			anim, err := device.LoadAnimation(st.Delay, st.FrameW, st.FrameH, files...)
+			if err != nil {
+				return err
+			}
+			o, err := st.imageOptions()
+			if err != nil {
+				return err
+			}
+			anim.SetImageOptions(o)
+			r.oled.Play(anim)
+		}
+		if st.Text != "" {
+			r.oled.ShowText(device.Text{
+				Text:   st.Text,
+				Align:  device.ALIGN_CENTER,
+				Scroll: 30,
+			})
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", st.Action)
+}
+
+// waitMotion waits for m and returns how much of it ran and an error if it
+// did not complete.
+func waitMotion(m *device.Motion) (float64, error) {
+	res, err := m.Wait()
+	if err != nil {
+		return m.Progress(), err
+	}
+	if res != device.MOTION_DONE {
+		return m.Progress(), fmt.Errorf("motion %v", res)
+	}
+	return 1, nil
+}
@@ -23,11 +23,49 @@ var CmdType = {
     HEADLIGHT_ON: 20,
     HEADLIGHT_OFF: 21,
     STATUS: 22,
+    DRIVE_DIFF: 23,
+    PING: 24,
+    ODOM_RESET: 25,
+    MOVE_DISTANCE: 26,
+    ROTATE: 27,
+    MISSION_START: 28,
+    MISSION_PAUSE: 29,
+    MISSION_RESUME: 30,
+    MISSION_ABORT: 31,
+    MISSION_PROGRESS: 32,
+    SERVO_SET: 33,
+    SERVO_MOVE: 34,
+    SERVO_GET: 35,
+    SERVO_ANGLE: 36,
+    PAN_TILT: 37,
+    SERVO_CALIB_START: 38,
+    SERVO_PULSE: 39,
+    SERVO_CALIBRATE: 40,
+    SERVO_CALIB_CANCEL: 41,
+    SERVO_CALIBRATION: 42,
+    SERVO_MOVE_TO: 43,
+    SERVO_SWEEP: 44,
+    SERVO_STOP: 45,
+    SERVO_HOLD: 46,
+    OLED_DASHBOARD: 47,
+    FACE_EXPRESSION: 48,
 }
 
 // Telemetry data from Ubiquity.
 var Statuses = {
     AUDIO: 0,
+    LOCKED: 1,
+    WATCHDOG: 2,
+    ODOM_X: 3,
+    ODOM_Y: 4,
+    ODOM_HEADING: 5,
+    TICKS_LEFT: 6,
+    TICKS_RIGHT: 7,
+    BATTERY: 8,
+    CLIENTS: 9,
+    AUDIO_UNDERRUNS: 10,
+    AUDIO_OVERRUNS: 11,
+    AUDIO_DROPPED: 12,
 }
 
 // Control Websocket message handlers
@@ -35,12 +73,20 @@ $(document).ready(function() {
     var errorContainer = document.querySelector('#error-popup');
 
     wsCtrl = new WebSocket("wss://" + window.location.host + "/control");
+    // Heartbeat so the rover stops if this client goes away.
+    var pingTimer;
     wsCtrl.onopen = function(evt) {
         $("#conn_spinner").show();
+        pingTimer = setInterval(function() {
+            wsCtrl.send(JSON.stringify({
+                CmdType: CmdType.PING,
+            }));
+        }, 1000);
     }
 
     wsCtrl.onclose = function(evt) {
         $("#conn_spinner").hide();
+        clearInterval(pingTimer);
         wsCtrl = null;
     }
 
@@ -73,6 +119,11 @@ $(document).ready(function() {
 
  });
 
+// driveData returns the [speed, duration] payload for DRIVE_* commands.
+var driveData = function() {
+    return [parseInt($('#drive_speed_sel').val()), parseInt($('#drive_velocity_sel').val())];
+}
+
 // Callback for keyboard keys Drive Control.
 $(document).keydown(function(e) {
     var cmd
@@ -103,7 +154,7 @@ $(document).keydown(function(e) {
     if (cmd == -1) {
         return
     }
-    SendControlCmd(cmd, parseInt($('#drive_velocity_sel').val()));
+    SendControlCmd(cmd, driveData());
 });
 
 // Configuration Control.
@@ -146,34 +197,104 @@ $(document).ready(function() {
     });
 });
 
+// OLED preview.
+$(document).ready(function() {
+    var wsOLED = new WebSocket("wss://" + window.location.host + "/oledstream?scale=2");
+    wsOLED.binaryType = "blob";
+    wsOLED.onmessage = function(evt) {
+        var img = document.getElementById("oled_preview");
+        if (img.src) {
+            URL.revokeObjectURL(img.src);
+        }
+        img.src = URL.createObjectURL(evt.data);
+    };
+});
+
+// OLED animation library.
+$(document).ready(function() {
+    var showError = function(text) {
+        document.querySelector('#error-popup').MaterialSnackbar.showSnackbar({
+            message: 'Error: ' + text
+        });
+    };
+
+    var refresh = function() {
+        $.getJSON("/animations", function(lib) {
+            var list = $("#anim-list").empty();
+            lib.animations.forEach(function(a) {
+                var active = Object.keys(lib.active).filter(function(e) {
+                    return lib.active[e] == a.name;
+                });
+                var item = $("<li>");
+                item.append($("<img>").attr("src", "/animations/" + a.name + "/preview?scale=1"));
+                item.append(" " + a.name + " (" + a.frames + " frames) " + active.join(", ") + " ");
+                item.append($("<button>").text("Activate").click(function() {
+                    var expr = prompt("Expression", "idle");
+                    if (expr) {
+                        $.post("/animations/" + a.name + "/activate", {
+                            expression: expr
+                        }).done(refresh).fail(function(x) {
+                            showError(x.responseText);
+                        });
+                    }
+                }));
+                item.append($("<button>").text("Delete").click(function() {
+                    $.ajax({
+                        url: "/animations/" + a.name,
+                        type: "DELETE"
+                    }).done(refresh).fail(function(x) {
+                        showError(x.responseText);
+                    });
+                }));
+                list.append(item);
+            });
+        });
+    };
+
+    $("#anim-upload").submit(function(e) {
+        e.preventDefault();
+        $.ajax({
+            url: "/animations",
+            type: "POST",
+            data: new FormData(this),
+            processData: false,
+            contentType: false
+        }).done(refresh).fail(function(x) {
+            showError(x.responseText);
+        });
+    });
+
+    refresh();
+});
+
 // Servo and Drive Controls.
 $(document).ready(function() {
 
 
     // Motor Controls.
     document.querySelector('#motor-forward').addEventListener('click', function() {
-        SendControlCmd(CmdType.DRIVE_FWD, parseInt($('#drive_velocity_sel').val()));
+        SendControlCmd(CmdType.DRIVE_FWD, driveData());
     });
 
     document.querySelector('#motor-back').addEventListener('click', function() {
-        SendControlCmd(CmdType.DRIVE_BWD, parseInt($('#drive_velocity_sel').val()));
+        SendControlCmd(CmdType.DRIVE_BWD, driveData());
     });
 
     document.querySelector('#motor-right').addEventListener('click', function() {
         if (document.getElementById('rotate_dual').checked) {
-            SendControlCmd(CmdType.DRIVE_RIGHT, parseInt($('#drive_velocity_sel').val()));
+            SendControlCmd(CmdType.DRIVE_RIGHT, driveData());
             return;
         }
-        SendControlCmd(CmdType.DRIVE_RIGHT_ONLY, parseInt($('#drive_velocity_sel').val()));
+        SendControlCmd(CmdType.DRIVE_RIGHT_ONLY, driveData());
 
     });
 
     document.querySelector('#motor-left').addEventListener('click', function() {
         if (document.getElementById('rotate_dual').checked) {
-            SendControlCmd(CmdType.DRIVE_LEFT, parseInt($('#drive_velocity_sel').val()));
+            SendControlCmd(CmdType.DRIVE_LEFT, driveData());
             return;
         }
-        SendControlCmd(CmdType.DRIVE_LEFT_ONLY, parseInt($('#drive_velocity_sel').val()));
+        SendControlCmd(CmdType.DRIVE_LEFT_ONLY, driveData());
     });
 
     // Drive velocity selector.
@@ -183,6 +304,13 @@ $(document).ready(function() {
         $("#drive_velocity_sel_disp").append(val);
     });
 
+    // Drive speed selector.
+    document.querySelector('#drive_speed_sel').addEventListener('click', function() {
+        val = $('#drive_speed_sel').val();
+        $("#drive_speed_sel_disp").empty()
+        $("#drive_speed_sel_disp").append(val);
+    });
+
     // Servo Controls.
     document.querySelector('#servo-down').addEventListener('click', function() {
         SendControlCmd(CmdType.SERVO_DOWN);
@@ -220,15 +348,25 @@ $(document).ready(function() {
     ws = new WebSocket("wss://" + window.location.host + "/audiostream");
     ws.binaryType = 'arraybuffer';
 
+    // Audio is sent and received at the native rate of the browser and
+    // resampled by Ubiquity.
+    var context = new window.AudioContext();
+
+    // Audio format picked by Ubiquity in reply to the codecs offered.
+    var codec = "";
+    var recRate = context.sampleRate;
+    var playRate = context.sampleRate;
+
+    ws.onopen = function() {
+        ws.send(JSON.stringify({Codecs: ["pcmu", "pcma", "pcm"], Rate: context.sampleRate}));
+    }
+
     ws.onerror = function(evt) {
         print("ERROR: " + evt.data);
     }
 
     // downsampleBuffer downsamples and converts to int16 array.
     var downsampleBuffer = function(buffer, sampleRate, outSampleRate) {
-        if (outSampleRate == sampleRate) {
-            return buffer;
-        }
         if (outSampleRate > sampleRate) {
             throw "downsampling rate show be smaller than original sample rate";
         }
@@ -245,7 +383,7 @@ $(document).ready(function() {
                 accum += buffer[i];
                 count++;
             }
-            result[offsetResult] = Math.min(1, accum / count) * 0x7FFF;
+            result[offsetResult] = Math.max(-1, Math.min(1, accum / count)) * 0x7FFF;
             offsetResult++;
             offsetBuffer = nextOffsetBuffer;
         }
@@ -262,6 +400,95 @@ $(document).ready(function() {
             return output;
         } */
 
+    // G.711 companding of int16 samples, as in the reference implementation.
+    var uLawSegEnd = [0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF];
+    var aLawSegEnd = [0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF];
+
+    var segment = function(v, ends) {
+        for (var i = 0; i < ends.length; i++) {
+            if (v <= ends[i]) {
+                return i;
+            }
+        }
+        return 8;
+    }
+
+    var linearToULaw = function(s) {
+        var v = s >> 2;
+        var mask = 0xFF;
+        if (v < 0) {
+            v = -v;
+            mask = 0x7F;
+        }
+        v = Math.min(v, 8159) + (0x84 >> 2);
+        var seg = segment(v, uLawSegEnd);
+        if (seg >= 8) {
+            return 0x7F ^ mask;
+        }
+        return ((seg << 4) | ((v >> (seg + 1)) & 0x0F)) ^ mask;
+    }
+
+    var uLawToLinear = function(u) {
+        u = ~u & 0xFF;
+        var t = (((u & 0x0F) << 3) + 0x84) << ((u & 0x70) >> 4);
+        return (u & 0x80) ? 0x84 - t : t - 0x84;
+    }
+
+    var linearToALaw = function(s) {
+        var v = s >> 3;
+        var mask = 0xD5;
+        if (v < 0) {
+            mask = 0x55;
+            v = -v - 1;
+        }
+        var seg = segment(v, aLawSegEnd);
+        if (seg >= 8) {
+            return 0x7F ^ mask;
+        }
+        var a = seg << 4;
+        a |= (seg < 2 ? v >> 1 : v >> seg) & 0x0F;
+        return a ^ mask;
+    }
+
+    var aLawToLinear = function(a) {
+        a ^= 0x55;
+        var t = (a & 0x0F) << 4;
+        var seg = (a & 0x70) >> 4;
+        if (seg == 0) {
+            t += 8;
+        } else {
+            t = (t + 0x108) << (seg - 1);
+        }
+        return (a & 0x80) ? t : -t;
+    }
+
+    // encodeAudio returns int16 samples in the negotiated codec.
+    var encodeAudio = function(samples) {
+        var enc = {pcmu: linearToULaw, pcma: linearToALaw}[codec];
+        if (!enc) {
+            return samples;
+        }
+        var out = new Uint8Array(samples.length);
+        for (var i = 0; i < samples.length; i++) {
+            out[i] = enc(samples[i]);
+        }
+        return out;
+    }
+
+    // decodeAudio returns the int16 samples of a packet.
+    var decodeAudio = function(packet) {
+        var dec = {pcmu: uLawToLinear, pcma: aLawToLinear}[codec];
+        if (!dec) {
+            return new Int16Array(packet);
+        }
+        var data = new Uint8Array(packet);
+        var out = new Int16Array(data.length);
+        for (var i = 0; i < data.length; i++) {
+            out[i] = dec(data[i]);
+        }
+        return out;
+    }
+
     var int16ToFloat32 = function(inputArray, startIndex, length) {
         var output = new Float32Array(inputArray.length - startIndex);
         for (var i = startIndex; i < length; i++) {
@@ -276,7 +503,6 @@ $(document).ready(function() {
     // Send audio packets from browser to Ubiquity.
     var startRec;
     var handleSuccess = function(stream) {
-        var context = new AudioContext();
         var source = context.createMediaStreamSource(stream);
       //  var processor = context.createScriptProcessor(16384, 1, 1); 
         var processor = context.createScriptProcessor(8192, 1, 1); 
@@ -285,14 +511,14 @@ $(document).ready(function() {
         processor.connect(context.destination);
 
         processor.onaudioprocess = function(e) {
-            if (!startRec) {
+            if (!startRec || !codec) {
                 return;
             }
             var ib = e.inputBuffer;
             var i = ib.getChannelData(0);
-            var conv = downsampleBuffer(i, 44100, 4000);
+            var conv = downsampleBuffer(i, ib.sampleRate, playRate);
             //console.log(conv)
-            ws.send(conv);
+            ws.send(encodeAudio(conv));
         };
     };
     navigator.mediaDevices.getUserMedia({
@@ -313,14 +539,30 @@ $(document).ready(function() {
     });
 
     // Recieve and play audio packets from Ubiquity.
-    var context = new window.AudioContext()
     var channels = 1
-    var sampleRate = 8000
-    var frames = 1024
-    var buffer = context.createBuffer(channels, frames, sampleRate)
 
     ws.onmessage = function(evt) {
-        var data = new Int16Array(evt.data);
+        if (typeof evt.data === "string") {
+            var setup = JSON.parse(evt.data);
+            if (setup.Error) {
+                console.log("Audio setup failed: " + setup.Error);
+                return;
+            }
+            codec = setup.Codec;
+            recRate = setup.RecRate;
+            playRate = setup.PlayRate;
+            console.log("Audio codec " + codec);
+            return;
+        }
+        if (codec == "") {
+            // Raw PCM sent before the setup reply.
+            return;
+        }
+        var data = decodeAudio(evt.data);
+        if (data.length == 0) {
+            return;
+        }
+        var buffer = context.createBuffer(channels, data.length, recRate)
         var floatData = int16ToFloat32(data, 0, data.length)
         buffer.getChannelData(0).set(floatData)
 